@@ -1,10 +1,23 @@
 package api
 
 import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/NinjaPerson24119/MapProject/backend/internal/database"
+	"github.com/NinjaPerson24119/MapProject/backend/internal/export"
 	"github.com/NinjaPerson24119/MapProject/backend/internal/filters"
+	"github.com/NinjaPerson24119/MapProject/backend/internal/geojson"
+	"github.com/NinjaPerson24119/MapProject/backend/internal/importer"
+	"github.com/NinjaPerson24119/MapProject/backend/internal/metrics"
+	"github.com/NinjaPerson24119/MapProject/backend/internal/partitions"
+	"github.com/NinjaPerson24119/MapProject/backend/internal/relay"
+	"github.com/NinjaPerson24119/MapProject/backend/internal/retention"
 	"github.com/gin-gonic/gin"
 )
 
@@ -12,21 +25,56 @@ type AddDeviceResponse struct {
 	DeviceID string `json:"device_id"`
 }
 
+type DeviceIDRequest struct {
+	DeviceID string `json:"device_id"`
+}
+
 type ListDevicesRequest struct {
 	Paging filters.PageOptions `json:"paging"`
+	filters.DeviceFilter
 }
 
 type GetDevicesResponse struct {
-	Devices []*database.Device `json:"devices"`
+	Devices    []*database.Device `json:"devices"`
+	NextCursor string             `json:"next_cursor"`
 }
 
 type ListLatestGeolocationsRequest struct {
 	Paging filters.PageOptions `json:"paging"`
-	// TODO: maybe add filters later by (lat,lng) and radius
+	filters.SpatialFilter
+	filters.DeviceFilter
 }
 
 type ListLatestGeolocationsResponse struct {
 	Geolocations []*database.DeviceGeolocation `json:"geolocations"`
+	NextCursor   string                        `json:"next_cursor"`
+}
+
+type ListGeolocationHistoryRequest struct {
+	DeviceID string `json:"device_id"`
+	filters.HistoryOptions
+	Paging filters.PageOptions `json:"paging"`
+}
+
+type ListGeolocationHistoryResponse struct {
+	Geolocations []*database.DeviceGeolocation `json:"geolocations"`
+	NextCursor   string                        `json:"next_cursor"`
+}
+
+type CreateGeolocationRequest struct {
+	database.DeviceGeolocation
+	// overrides the server's policy for this request
+	DuplicatePolicy database.DuplicatePolicy `json:"duplicate_policy"`
+}
+
+type CreateMultiGeolocationRequest struct {
+	Geolocations []*database.DeviceGeolocation `json:"geolocations"`
+	// overrides the server's policy for this request
+	DuplicatePolicy database.DuplicatePolicy `json:"duplicate_policy"`
+}
+
+type IngestGeolocationsResponse struct {
+	Results []*database.IngestResult `json:"results"`
 }
 
 type GetMultiLatestGeolocationsRequest struct {
@@ -37,19 +85,115 @@ type GetMultiLatestGeolocationsResponse struct {
 	Geolocations []*database.DeviceGeolocation `json:"geolocations"`
 }
 
-func RouterWithGeolocationAPI(router *gin.Engine, repo database.Repo) {
-	router.POST("/device/create", func(c *gin.Context) {
+type ExportTracksRequest struct {
+	// the devices to export. without ids, every device matching the filter is exported
+	DeviceIDs []string `json:"device_ids"`
+	filters.DeviceFilter
+	filters.HistoryOptions
+	Format string `json:"format"`
+}
+
+type CreateOrganizationRequest struct {
+	Name string `json:"name"`
+}
+
+type CreateOrganizationResponse struct {
+	OrgID string `json:"org_id"`
+	// only shown once, since just its hash is kept
+	APIKey string `json:"api_key"`
+}
+
+type OrganizationIDRequest struct {
+	OrgID string `json:"org_id"`
+}
+
+type OrganizationAPIKeyResponse struct {
+	APIKey string `json:"api_key"`
+}
+
+type ListOrganizationsResponse struct {
+	Organizations []*database.Organization `json:"organizations"`
+}
+
+type MoveDeviceRequest struct {
+	DeviceID string `json:"device_id"`
+	OrgID    string `json:"org_id"`
+}
+
+// uploads are stored in one transaction, so they are kept to a size that doesn't hold locks for long
+const maxGeolocationsPerRequest = 10000
+
+// imports are written in batches, so files can be far bigger than uploads, but not unbounded
+const maxImportBytes = 64 << 20
+
+// requestDuplicatePolicy is the policy a request asked for, or the server's
+func requestDuplicatePolicy(requested database.DuplicatePolicy, fallback database.DuplicatePolicy) (database.DuplicatePolicy, error) {
+	if requested == "" {
+		return fallback, nil
+	}
+	return database.ParseDuplicatePolicy(string(requested))
+}
+
+// exportDevices looks up the devices by id, or else pages through the devices matching the filter
+func exportDevices(ctx context.Context, repo database.Repo, orgID string, deviceIDs []string, filter filters.DeviceFilter) ([]*database.Device, error) {
+	if len(deviceIDs) > 0 {
+		devices, err := repo.GetMultiDevices(ctx, orgID, deviceIDs)
+		if err != nil {
+			return nil, err
+		}
+		for i, device := range devices {
+			if device == nil {
+				return nil, fmt.Errorf("device %v: %w", deviceIDs[i], database.ErrNotFound)
+			}
+		}
+		return devices, nil
+	}
+
+	devices := []*database.Device{}
+	cursor := ""
+	for {
+		page, nextCursor, err := repo.ListDevices(ctx, orgID, filters.PageOptions{
+			PageSize: filters.MaxPageSize,
+			Cursor:   cursor,
+		}, filter)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, page...)
+		if nextCursor == "" {
+			return devices, nil
+		}
+		cursor = nextCursor
+	}
+}
+
+// repoErrorStatus tells apart lookups that found nothing from everything else going wrong
+func repoErrorStatus(err error) int {
+	if errors.Is(err, database.ErrNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// RouterWithGeolocationAPI requires an org's api key on device and geolocation requests.
+// it handles conflicting duplicate geolocations with the given policy, unless a request says otherwise
+func RouterWithGeolocationAPI(router *gin.Engine, repo database.Repo, duplicatePolicy database.DuplicatePolicy) {
+	// everything is scoped to the org of the request's api key
+	orgs := router.Group("/", newOrgAuthenticator(repo).authenticate)
+
+	orgs.POST("/device/create", func(c *gin.Context) {
+		orgID := requestOrg(c)
 		var request database.Device
 		if err := c.ShouldBindJSON(&request); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		if request.Name == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "missing name"})
+		if err := database.ValidateDevice(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		id, err := repo.InsertDevice(c.Request.Context(), &request)
+		id, err := repo.InsertDevice(c.Request.Context(), orgID, &request)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -61,65 +205,255 @@ func RouterWithGeolocationAPI(router *gin.Engine, repo database.Repo) {
 		c.JSON(http.StatusCreated, resp)
 	})
 
-	router.POST("/device/list", func(c *gin.Context) {
+	orgs.POST("/device/list", func(c *gin.Context) {
+		orgID := requestOrg(c)
 		var request ListDevicesRequest
 		if err := c.ShouldBindJSON(&request); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		if request.Paging.Page < 1 || request.Paging.PageSize < 1 || request.Paging.PageSize > 1000 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page or page_size"})
+		if err := request.Paging.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := filters.DecodeDeviceCursor(request.Paging.Cursor); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := request.DeviceFilter.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		devices, err := repo.ListDevices(c.Request.Context(), request.Paging)
+		devices, nextCursor, err := repo.ListDevices(c.Request.Context(), orgID, request.Paging, request.DeviceFilter)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 		resp := GetDevicesResponse{
-			Devices: devices,
+			Devices:    devices,
+			NextCursor: nextCursor,
 		}
 		c.JSON(http.StatusOK, resp)
 	})
 
-	router.POST("/geolocation/create", func(c *gin.Context) {
-		var request database.DeviceGeolocation
+	orgs.POST("/device/get", func(c *gin.Context) {
+		orgID := requestOrg(c)
+		var request DeviceIDRequest
 		if err := c.ShouldBindJSON(&request); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		if request.DeviceID == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "missing device_id"})
+		if !isDeviceID(request.DeviceID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid device_id"})
 			return
 		}
-		if request.Latitude < -90 || request.Latitude > 90 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid latitude"})
+
+		device, err := repo.GetDevice(c.Request.Context(), orgID, request.DeviceID)
+		if err != nil {
+			c.JSON(repoErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, device)
+	})
+
+	// only the fields in the request are changed
+	orgs.POST("/device/update", func(c *gin.Context) {
+		orgID := requestOrg(c)
+		var request database.DeviceUpdate
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		if request.Longitude < -180 || request.Longitude > 180 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid longitude"})
+		if !isDeviceID(request.DeviceID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid device_id"})
 			return
 		}
-		if request.EventTime.IsZero() {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "missing event_time"})
+		if err := request.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
-		err := repo.InsertGeolocation(c.Request.Context(), &request)
+
+		device, err := repo.UpdateDevice(c.Request.Context(), orgID, &request)
+		if err != nil {
+			c.JSON(repoErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, device)
+	})
+
+	// soft deleted devices drop out of listings, latest positions and streams until they are restored
+	orgs.POST("/device/delete", func(c *gin.Context) {
+		orgID := requestOrg(c)
+		var request DeviceIDRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !isDeviceID(request.DeviceID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid device_id"})
+			return
+		}
+
+		device, err := repo.DeleteDevice(c.Request.Context(), orgID, request.DeviceID)
+		if err != nil {
+			c.JSON(repoErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, device)
+	})
+
+	orgs.POST("/device/restore", func(c *gin.Context) {
+		orgID := requestOrg(c)
+		var request DeviceIDRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !isDeviceID(request.DeviceID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid device_id"})
+			return
+		}
+
+		device, err := repo.RestoreDevice(c.Request.Context(), orgID, request.DeviceID)
+		if err != nil {
+			c.JSON(repoErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, device)
+	})
+
+	orgs.POST("/geolocation/create", func(c *gin.Context) {
+		orgID := requestOrg(c)
+		var request CreateGeolocationRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validateGeolocation(&request.DeviceGeolocation); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		policy, err := requestDuplicatePolicy(request.DuplicatePolicy, duplicatePolicy)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		results, err := repo.IngestMultiGeolocation(c.Request.Context(), orgID, []*database.DeviceGeolocation{&request.DeviceGeolocation}, policy)
+		if err != nil {
+			c.JSON(repoErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		// retries of a point that was already stored succeed, so devices can stop retrying
+		status := http.StatusOK
+		switch results[0].Status {
+		case database.IngestInserted:
+			status = http.StatusCreated
+		case database.IngestQueued:
+			status = http.StatusAccepted
+		case database.IngestRejected:
+			status = http.StatusConflict
+		}
+		c.JSON(status, IngestGeolocationsResponse{
+			Results: results,
+		})
+	})
+
+	// for trackers uploading what they buffered while offline. every point gets a result,
+	// and points that fail validation or are for unknown devices don't stop the rest from being stored
+	orgs.POST("/geolocation/createMulti", func(c *gin.Context) {
+		orgID := requestOrg(c)
+		var request CreateMultiGeolocationRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(request.Geolocations) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing geolocations"})
+			return
+		}
+		if len(request.Geolocations) > maxGeolocationsPerRequest {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("too many geolocations, the limit is %d", maxGeolocationsPerRequest)})
+			return
+		}
+		policy, err := requestDuplicatePolicy(request.DuplicatePolicy, duplicatePolicy)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		results := make([]*database.IngestResult, len(request.Geolocations))
+		validationErrors := make([]error, len(request.Geolocations))
+		// whether each device is in the org, looked up in one go
+		inOrg := map[string]bool{}
+		deviceIDs := []string{}
+		for i, geolocation := range request.Geolocations {
+			if geolocation == nil {
+				continue
+			}
+			validationErrors[i] = validateGeolocation(geolocation)
+			if _, ok := inOrg[geolocation.DeviceID]; validationErrors[i] == nil && !ok {
+				inOrg[geolocation.DeviceID] = false
+				deviceIDs = append(deviceIDs, geolocation.DeviceID)
+			}
+		}
+		devices, err := repo.GetMultiDevices(c.Request.Context(), orgID, deviceIDs)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		for i, device := range devices {
+			inOrg[deviceIDs[i]] = device != nil
+		}
+
+		valid := []*database.DeviceGeolocation{}
+		validIndexes := []int{}
+		for i, geolocation := range request.Geolocations {
+			if geolocation == nil {
+				results[i] = &database.IngestResult{Status: database.IngestInvalid, Error: "missing geolocation"}
+				continue
+			}
+			err := validationErrors[i]
+			if err == nil && !inOrg[geolocation.DeviceID] {
+				err = fmt.Errorf("device not found: %v", geolocation.DeviceID)
+			}
+			if err != nil {
+				results[i] = &database.IngestResult{
+					DeviceID:  geolocation.DeviceID,
+					EventTime: geolocation.EventTime,
+					Status:    database.IngestInvalid,
+					Error:     err.Error(),
+				}
+				continue
+			}
+			valid = append(valid, geolocation)
+			validIndexes = append(validIndexes, i)
+		}
 
-		c.Status(http.StatusCreated)
+		if len(valid) > 0 {
+			ingested, err := repo.IngestMultiGeolocation(c.Request.Context(), orgID, valid, policy)
+			if err != nil {
+				c.JSON(repoErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			for i, result := range ingested {
+				results[validIndexes[i]] = result
+			}
+		}
+		c.JSON(http.StatusOK, IngestGeolocationsResponse{
+			Results: results,
+		})
 	})
 
-	router.POST("/geolocation/getMulti", func(c *gin.Context) {
+	orgs.POST("/geolocation/getMulti", func(c *gin.Context) {
+		orgID := requestOrg(c)
 		var request GetMultiLatestGeolocationsRequest
 		if err := c.ShouldBindJSON(&request); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		geolocation, err := repo.GetMultiLatestGeolocations(c.Request.Context(), request.DeviceIDs)
+		geolocation, err := repo.GetMultiLatestGeolocations(c.Request.Context(), orgID, request.DeviceIDs)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -130,31 +464,371 @@ func RouterWithGeolocationAPI(router *gin.Engine, repo database.Repo) {
 		c.JSON(http.StatusOK, resp)
 	})
 
-	router.POST("/geolocation/list", func(c *gin.Context) {
+	orgs.POST("/geolocation/list", func(c *gin.Context) {
+		orgID := requestOrg(c)
+		format, ok := formatFromRequest(c)
+		if !ok {
+			return
+		}
 		var request ListLatestGeolocationsRequest
 		if err := c.ShouldBindJSON(&request); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		if request.Paging.Page < 1 || request.Paging.PageSize < 1 || request.Paging.PageSize > 1000 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid page or page_size"})
+		if err := request.Paging.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := filters.DecodeDeviceCursor(request.Paging.Cursor); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := request.SpatialFilter.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := request.DeviceFilter.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		geolocations, err := repo.ListLatestGeolocations(c.Request.Context(), request.Paging)
+		geolocations, nextCursor, err := repo.ListLatestGeolocations(c.Request.Context(), orgID, request.Paging, request.SpatialFilter, request.DeviceFilter)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
+		if format == geoJSONFormat {
+			deviceIDs := make([]string, len(geolocations))
+			for i, geolocation := range geolocations {
+				deviceIDs[i] = geolocation.DeviceID
+			}
+			devices, err := repo.GetMultiDevices(c.Request.Context(), orgID, deviceIDs)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.Header("Content-Type", geojson.ContentType)
+			c.JSON(http.StatusOK, geojson.LatestPositions(geolocations, devices, nextCursor))
+			return
+		}
+
 		if len(geolocations) == 0 {
 			geolocations = []*database.DeviceGeolocation{}
 		}
 		resp := ListLatestGeolocationsResponse{
 			Geolocations: geolocations,
+			NextCursor:   nextCursor,
+		}
+		c.JSON(http.StatusOK, resp)
+	})
+
+	orgs.POST("/geolocation/history", func(c *gin.Context) {
+		orgID := requestOrg(c)
+		format, ok := formatFromRequest(c)
+		if !ok {
+			return
+		}
+		var request ListGeolocationHistoryRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if request.DeviceID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing device_id"})
+			return
+		}
+		if err := request.HistoryOptions.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := request.Paging.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := filters.DecodeTimeCursor(request.Paging.Cursor); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		geolocations, nextCursor, err := repo.ListGeolocationHistory(c.Request.Context(), orgID, request.DeviceID, request.HistoryOptions, request.Paging)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if format == geoJSONFormat {
+			// an unknown device has no history, which is an empty collection like it's an empty page
+			device, err := repo.GetDevice(c.Request.Context(), orgID, request.DeviceID)
+			if err != nil && !errors.Is(err, database.ErrNotFound) {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.Header("Content-Type", geojson.ContentType)
+			c.JSON(http.StatusOK, geojson.Track(request.DeviceID, device, geolocations, nextCursor))
+			return
+		}
+		resp := ListGeolocationHistoryResponse{
+			Geolocations: geolocations,
+			NextCursor:   nextCursor,
 		}
 		c.JSON(http.StatusOK, resp)
 	})
 
-	router.GET("/geolocation/stream", geolocationsWebSocketGenerator(repo))
+	// streams tracks as a file download, a page of history at a time
+	orgs.POST("/geolocation/export", func(c *gin.Context) {
+		orgID := requestOrg(c)
+		var request ExportTracksRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		format, err := export.ParseFormat(request.Format)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := request.HistoryOptions.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := request.DeviceFilter.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		for _, deviceID := range request.DeviceIDs {
+			if !isDeviceID(deviceID) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid device_id"})
+				return
+			}
+		}
+
+		// problems are reported before the download starts, since a status can't be sent after
+		devices, err := exportDevices(c.Request.Context(), repo, orgID, request.DeviceIDs, request.DeviceFilter)
+		if err != nil {
+			c.JSON(repoErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Header("Content-Type", format.ContentType())
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="tracks%s"`, format.Extension()))
+		c.Status(http.StatusOK)
+		w, err := export.NewTrackWriter(format, c.Writer)
+		if err == nil {
+			err = export.WriteTracks(c.Request.Context(), repo, orgID, devices, request.HistoryOptions, w, c.Writer.Flush)
+		}
+		if err != nil {
+			// the client is left with a truncated file, which won't parse
+			fmt.Printf("failed to export tracks: %v\n", err)
+		}
+	})
+
+	// a multipart upload of a file, with its format, column mapping, device name and duplicate policy as optional form fields
+	orgs.POST("/geolocation/import", func(c *gin.Context) {
+		orgID := requestOrg(c)
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes)
+		header, err := c.FormFile("file")
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("file too large, the limit is %d bytes", maxImportBytes)})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		format, err := importer.FormatFromFilename(header.Filename)
+		if value := c.PostForm("format"); value != "" {
+			format, err = importer.ParseFormat(value)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		mapping := importer.ColumnMapping{}
+		if value := c.PostForm("mapping"); value != "" {
+			if err := json.Unmarshal([]byte(value), &mapping); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid mapping: %v", err)})
+				return
+			}
+		}
+		policy, err := requestDuplicatePolicy(database.DuplicatePolicy(c.PostForm("duplicate_policy")), duplicatePolicy)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		file, err := header.Open()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer file.Close()
+		source, err := importer.NewSource(format, file, mapping, c.PostForm("device_name"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		report, err := importer.Import(c.Request.Context(), repo, orgID, source, policy)
+		if err != nil {
+			// whatever was imported before the failure stays imported, so the report is still useful
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "report": report})
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	})
+
+	orgs.GET("/geolocation/stream", geolocationsWebSocketGenerator(repo))
+
+	orgs.GET("/geolocation/playback", playbackWebSocketGenerator(repo))
+}
+
+// StatsGroup serves operational stats. they span every org and can name devices, so they need the admin token when there is one
+func StatsGroup(router *gin.Engine, adminToken string) *gin.RouterGroup {
+	if adminToken == "" {
+		return router.Group("/")
+	}
+	return router.Group("/", adminAuthenticator(adminToken))
+}
+
+func RouterWithListenerStatsAPI(router gin.IRoutes, repo database.Repo) {
+	router.GET("/geolocation/stream/stats", func(c *gin.Context) {
+		c.JSON(http.StatusOK, repo.ListenerStats())
+	})
+}
+
+func RouterWithMetricsAPI(router gin.IRoutes, instrumentedRepo *metrics.InstrumentedRepo) {
+	router.GET("/metrics", func(c *gin.Context) {
+		c.JSON(http.StatusOK, instrumentedRepo.Stats())
+	})
+}
+
+func RouterWithRelayAPI(router gin.IRoutes, relayRepo *relay.RelayRepo) {
+	router.GET("/relay/stats", func(c *gin.Context) {
+		c.JSON(http.StatusOK, relayRepo.Stats())
+	})
+}
+
+func RouterWithRetentionAPI(router gin.IRoutes, job retention.Retention, partitionJob partitions.Partitions) {
+	router.GET("/retention/stats", func(c *gin.Context) {
+		c.JSON(http.StatusOK, job.Stats())
+	})
+
+	router.GET("/retention/partitions/stats", func(c *gin.Context) {
+		c.JSON(http.StatusOK, partitionJob.Stats())
+	})
+}
+
+// adminAuthenticator only lets through requests with the admin token in X-Admin-Token
+func adminAuthenticator(adminToken string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.GetHeader("X-Admin-Token")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "invalid admin token"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RouterWithAdminAPI manages orgs across the whole deployment, for callers holding the admin token
+func RouterWithAdminAPI(router *gin.Engine, repo database.Repo, adminToken string) {
+	admin := router.Group("/admin", adminAuthenticator(adminToken))
+
+	admin.POST("/org/create", func(c *gin.Context) {
+		var request CreateOrganizationRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if request.Name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing name"})
+			return
+		}
+
+		key, err := database.NewAPIKey()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		id, err := repo.InsertOrganization(c.Request.Context(), &database.Organization{
+			Name: request.Name,
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := repo.SetOrganizationAPIKey(c.Request.Context(), id, database.HashAPIKey(key)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, CreateOrganizationResponse{
+			OrgID:  id,
+			APIKey: key,
+		})
+	})
+
+	// issues a new api key for an org, including the default one. the old key stops working
+	admin.POST("/org/key", func(c *gin.Context) {
+		var request OrganizationIDRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !isDeviceID(request.OrgID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid org_id"})
+			return
+		}
+
+		key, err := database.NewAPIKey()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		err = repo.SetOrganizationAPIKey(c.Request.Context(), strings.ToLower(request.OrgID), database.HashAPIKey(key))
+		if err != nil {
+			c.JSON(repoErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, OrganizationAPIKeyResponse{
+			APIKey: key,
+		})
+	})
+
+	admin.POST("/org/list", func(c *gin.Context) {
+		orgs, err := repo.ListOrganizations(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, ListOrganizationsResponse{
+			Organizations: orgs,
+		})
+	})
+
+	// the device keeps its history, which moves with it
+	admin.POST("/device/move", func(c *gin.Context) {
+		var request MoveDeviceRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !isDeviceID(request.DeviceID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid device_id"})
+			return
+		}
+		if !isDeviceID(request.OrgID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid org_id"})
+			return
+		}
+
+		device, err := repo.MoveDevice(c.Request.Context(), request.DeviceID, strings.ToLower(request.OrgID))
+		if err != nil {
+			c.JSON(repoErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, device)
+	})
 }
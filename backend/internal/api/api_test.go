@@ -0,0 +1,246 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+const testAPIKey = "test-api-key-that-is-long-enough-to-use"
+
+// newTestRouter serves the geolocation api for a memory repo whose default org has a device, and another org with one of its own
+func newTestRouter(t *testing.T) (router *gin.Engine, deviceID string, otherDeviceID string) {
+	t.Helper()
+	ctx := context.Background()
+	repo := database.NewMemory()
+	if err := repo.SetOrganizationAPIKey(ctx, database.DefaultOrgID, database.HashAPIKey(testAPIKey)); err != nil {
+		t.Fatalf("failed to set api key: %v", err)
+	}
+	deviceID, err := repo.InsertDevice(ctx, database.DefaultOrgID, &database.Device{Name: "alpha"})
+	if err != nil {
+		t.Fatalf("failed to insert device: %v", err)
+	}
+	otherOrgID, err := repo.InsertOrganization(ctx, &database.Organization{Name: "other"})
+	if err != nil {
+		t.Fatalf("failed to insert org: %v", err)
+	}
+	otherDeviceID, err = repo.InsertDevice(ctx, otherOrgID, &database.Device{Name: "bravo"})
+	if err != nil {
+		t.Fatalf("failed to insert device: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router = gin.New()
+	router.Use(APIKeyFromQuery)
+	RouterWithGeolocationAPI(router, repo, database.RejectDuplicates)
+	return router, deviceID, otherDeviceID
+}
+
+func post(router *gin.Engine, path string, apiKey string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		request.Header.Set("X-API-Key", apiKey)
+	}
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	return response
+}
+
+func TestListRejectsInvalidCursors(t *testing.T) {
+	router, deviceID, _ := newTestRouter(t)
+	tests := []struct {
+		path   string
+		body   string
+		status int
+	}{
+		{path: "/device/list", body: `{"paging": {"page_size": 10}}`, status: http.StatusOK},
+		// valid base64, but not a device id
+		{path: "/device/list", body: `{"paging": {"page_size": 10, "cursor": "YWJj"}}`, status: http.StatusBadRequest},
+		{path: "/geolocation/list", body: `{"paging": {"page_size": 10, "cursor": "YWJj"}}`, status: http.StatusBadRequest},
+		{
+			path:   "/geolocation/history",
+			body:   fmt.Sprintf(`{"device_id": %q, "start_time": "2024-01-01T00:00:00Z", "end_time": "2024-01-02T00:00:00Z", "paging": {"page_size": 10, "cursor": "YWJj"}}`, deviceID),
+			status: http.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		response := post(router, test.path, testAPIKey, test.body)
+		if response.Code != test.status {
+			t.Errorf("%v %v: got status %d, want %d: %s", test.path, test.body, response.Code, test.status, response.Body)
+		}
+	}
+}
+
+func geolocationJSON(deviceID string, second int, latitude float64) string {
+	return fmt.Sprintf(`{"device_id": %q, "event_time": "2024-01-01T00:00:%02dZ", "latitude": %v, "longitude": -113.5}`, deviceID, second, latitude)
+}
+
+func TestCreateGeolocation(t *testing.T) {
+	router, deviceID, otherDeviceID := newTestRouter(t)
+	withPolicy := func(geolocation string, policy string) string {
+		return strings.TrimSuffix(geolocation, "}") + fmt.Sprintf(`, "duplicate_policy": %q}`, policy)
+	}
+
+	// in order, since later requests find what earlier ones stored
+	tests := []struct {
+		name   string
+		apiKey string
+		body   string
+		status int
+		result database.IngestStatus
+	}{
+		{name: "no api key", body: geolocationJSON(deviceID, 0, 53.5), status: http.StatusUnauthorized},
+		{name: "wrong api key", apiKey: "wrong", body: geolocationJSON(deviceID, 0, 53.5), status: http.StatusUnauthorized},
+		{name: "not json", apiKey: testAPIKey, body: "{", status: http.StatusBadRequest},
+		{name: "invalid device id", apiKey: testAPIKey, body: geolocationJSON("abc", 0, 53.5), status: http.StatusBadRequest},
+		{name: "invalid latitude", apiKey: testAPIKey, body: geolocationJSON(deviceID, 0, 91), status: http.StatusBadRequest},
+		{name: "unknown policy", apiKey: testAPIKey, body: withPolicy(geolocationJSON(deviceID, 0, 53.5), "sometimes"), status: http.StatusBadRequest},
+		{name: "unknown device", apiKey: testAPIKey, body: geolocationJSON("6f1c2b9e-3f4a-4c1d-9a7b-2e5d8c0f1a23", 0, 53.5), status: http.StatusNotFound},
+		{name: "another org's device", apiKey: testAPIKey, body: geolocationJSON(otherDeviceID, 0, 53.5), status: http.StatusNotFound},
+		{name: "new point", apiKey: testAPIKey, body: geolocationJSON(deviceID, 0, 53.5), status: http.StatusCreated, result: database.IngestInserted},
+		{name: "retried point", apiKey: testAPIKey, body: geolocationJSON(deviceID, 0, 53.5), status: http.StatusOK, result: database.IngestDuplicate},
+		{name: "conflicting point", apiKey: testAPIKey, body: geolocationJSON(deviceID, 0, 53.6), status: http.StatusConflict, result: database.IngestRejected},
+		{
+			name:   "conflicting point kept",
+			apiKey: testAPIKey,
+			body:   withPolicy(geolocationJSON(deviceID, 0, 53.6), string(database.KeepFirst)),
+			status: http.StatusOK,
+			result: database.IngestKeptExisting,
+		},
+		{
+			name:   "conflicting point replaces",
+			apiKey: testAPIKey,
+			body:   withPolicy(geolocationJSON(deviceID, 0, 53.6), string(database.LastWriteWins)),
+			status: http.StatusOK,
+			result: database.IngestUpdated,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := post(router, "/geolocation/create", test.apiKey, test.body)
+			if response.Code != test.status {
+				t.Fatalf("got status %d, want %d: %s", response.Code, test.status, response.Body)
+			}
+			if test.result == "" {
+				return
+			}
+			body := IngestGeolocationsResponse{}
+			if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+			if len(body.Results) != 1 || body.Results[0].Status != test.result {
+				t.Errorf("got %s, want %v", response.Body, test.result)
+			}
+		})
+	}
+}
+
+func TestCreateMultiGeolocation(t *testing.T) {
+	router, deviceID, otherDeviceID := newTestRouter(t)
+	tooMany := make([]string, maxGeolocationsPerRequest+1)
+	for i := range tooMany {
+		tooMany[i] = geolocationJSON(deviceID, 0, 53.5)
+	}
+
+	tests := []struct {
+		name         string
+		geolocations []string
+		status       int
+		results      []database.IngestStatus
+	}{
+		{name: "nothing", geolocations: []string{}, status: http.StatusBadRequest},
+		{name: "too many", geolocations: tooMany, status: http.StatusBadRequest},
+		{
+			name: "bad points don't stop the rest",
+			geolocations: []string{
+				geolocationJSON(deviceID, 0, 53.5),
+				"null",
+				geolocationJSON(deviceID, 1, 91),
+				geolocationJSON("6f1c2b9e-3f4a-4c1d-9a7b-2e5d8c0f1a23", 1, 53.5),
+				geolocationJSON(otherDeviceID, 1, 53.5),
+				geolocationJSON(deviceID, 2, 53.5),
+				geolocationJSON(deviceID, 2, 53.5),
+				geolocationJSON(deviceID, 2, 53.6),
+			},
+			status: http.StatusOK,
+			results: []database.IngestStatus{
+				database.IngestInserted,
+				database.IngestInvalid,
+				database.IngestInvalid,
+				database.IngestInvalid,
+				// another org's device is reported like one that doesn't exist
+				database.IngestInvalid,
+				database.IngestInserted,
+				database.IngestDuplicate,
+				database.IngestRejected,
+			},
+		},
+		{
+			name:         "retried batch",
+			geolocations: []string{geolocationJSON(deviceID, 0, 53.5), geolocationJSON(deviceID, 2, 53.5)},
+			status:       http.StatusOK,
+			results:      []database.IngestStatus{database.IngestDuplicate, database.IngestDuplicate},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body := fmt.Sprintf(`{"geolocations": [%s]}`, strings.Join(test.geolocations, ","))
+			response := post(router, "/geolocation/createMulti", testAPIKey, body)
+			if response.Code != test.status {
+				t.Fatalf("got status %d, want %d: %s", response.Code, test.status, response.Body)
+			}
+			if test.results == nil {
+				return
+			}
+			results := IngestGeolocationsResponse{}
+			if err := json.Unmarshal(response.Body.Bytes(), &results); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+			if len(results.Results) != len(test.results) {
+				t.Fatalf("got %d results, want %d", len(results.Results), len(test.results))
+			}
+			for i, result := range results.Results {
+				if result.Status != test.results[i] {
+					t.Errorf("result %d: got %v (%v), want %v", i, result.Status, result.Error, test.results[i])
+				}
+			}
+		})
+	}
+}
+
+func TestStatsNeedTheAdminToken(t *testing.T) {
+	tests := []struct {
+		name       string
+		adminToken string
+		header     string
+		status     int
+	}{
+		{name: "no admin token", status: http.StatusOK},
+		{name: "missing", adminToken: "secret", status: http.StatusForbidden},
+		{name: "wrong", adminToken: "secret", header: "guess", status: http.StatusForbidden},
+		{name: "right", adminToken: "secret", header: "secret", status: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			router, _, _ := newTestRouter(t)
+			RouterWithListenerStatsAPI(StatsGroup(router, test.adminToken), database.NewMemory())
+			request := httptest.NewRequest(http.MethodGet, "/geolocation/stream/stats", nil)
+			if test.header != "" {
+				request.Header.Set("X-Admin-Token", test.header)
+			}
+			response := httptest.NewRecorder()
+			router.ServeHTTP(response, request)
+			if response.Code != test.status {
+				t.Errorf("got status %d, want %d: %s", response.Code, test.status, response.Body)
+			}
+		})
+	}
+}
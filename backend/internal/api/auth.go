@@ -0,0 +1,108 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	apiKeyHeader = "X-API-Key"
+	// set by authenticate for handlers
+	orgIDContextKey = "org_id"
+	// how long a key is trusted before it's looked up again, so keys that are replaced stop working within this long
+	apiKeyCacheTTL = time.Minute
+)
+
+// APIKeyFromQuery moves an api_key query parameter, which browsers opening websockets have to use since they can't set headers,
+// into the X-API-Key header. it runs before the logger, so keys don't end up in access logs
+func APIKeyFromQuery(c *gin.Context) {
+	query := c.Request.URL.Query()
+	if key := query.Get("api_key"); key != "" {
+		if c.GetHeader(apiKeyHeader) == "" {
+			c.Request.Header.Set(apiKeyHeader, key)
+		}
+		query.Del("api_key")
+		c.Request.URL.RawQuery = query.Encode()
+	}
+	c.Next()
+}
+
+type cachedOrg struct {
+	orgID   string
+	expires time.Time
+}
+
+// orgAuthenticator works out which org a request is for from its api key
+type orgAuthenticator struct {
+	repo database.Repo
+
+	mu sync.Mutex
+	// by key hash. only keys that were found are cached
+	orgs map[string]cachedOrg
+}
+
+func newOrgAuthenticator(repo database.Repo) *orgAuthenticator {
+	return &orgAuthenticator{
+		repo: repo,
+		orgs: map[string]cachedOrg{},
+	}
+}
+
+// authenticate is middleware that answers requests without a valid api key with unauthorized.
+// handlers get the key's org with requestOrg
+func (a *orgAuthenticator) authenticate(c *gin.Context) {
+	key := c.GetHeader(apiKeyHeader)
+	if key == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing api key"})
+		return
+	}
+	orgID, err := a.orgForKey(c, key)
+	if errors.Is(err, database.ErrNotFound) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid api key"})
+		return
+	}
+	if err != nil {
+		fmt.Printf("error authenticating api key: %v\n", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to authenticate api key"})
+		return
+	}
+	c.Set(orgIDContextKey, orgID)
+	c.Next()
+}
+
+func (a *orgAuthenticator) orgForKey(c *gin.Context, key string) (string, error) {
+	hash := database.HashAPIKey(key)
+	now := time.Now()
+	a.mu.Lock()
+	cached, ok := a.orgs[string(hash)]
+	a.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.orgID, nil
+	}
+
+	org, err := a.repo.GetOrganizationByAPIKey(c.Request.Context(), hash)
+	if err != nil {
+		a.mu.Lock()
+		delete(a.orgs, string(hash))
+		a.mu.Unlock()
+		return "", err
+	}
+	a.mu.Lock()
+	a.orgs[string(hash)] = cachedOrg{
+		orgID:   org.OrgID,
+		expires: now.Add(apiKeyCacheTTL),
+	}
+	a.mu.Unlock()
+	return org.OrgID, nil
+}
+
+// requestOrg is the org that authenticate found for the request
+func requestOrg(c *gin.Context) string {
+	return c.GetString(orgIDContextKey)
+}
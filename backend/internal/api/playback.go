@@ -0,0 +1,306 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/database"
+	"github.com/NinjaPerson24119/MapProject/backend/internal/filters"
+	"github.com/NinjaPerson24119/MapProject/backend/internal/playback"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+type PlaybackState struct {
+	Time   time.Time `json:"time"`
+	Speed  float64   `json:"speed"`
+	Paused bool      `json:"paused"`
+	// every geolocation in the window has been played. seek back to play again
+	Ended bool `json:"ended"`
+}
+
+// PlaybackControl is sent by clients to pause, play, seek to a time, or change the speed
+type PlaybackControl struct {
+	Action string    `json:"action"`
+	Time   time.Time `json:"time"`
+	Speed  float64   `json:"speed"`
+}
+
+// playbackOptionsFromQuery reads ?start_time=...&end_time=...&speed=10
+func playbackOptionsFromQuery(c *gin.Context) (filters.HistoryOptions, float64, error) {
+	history := filters.HistoryOptions{}
+	for name, t := range map[string]*time.Time{"start_time": &history.StartTime, "end_time": &history.EndTime} {
+		if value := c.Query(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				return history, 0, fmt.Errorf("invalid %v", name)
+			}
+			*t = parsed
+		}
+	}
+	if err := history.Validate(); err != nil {
+		return history, 0, err
+	}
+	speed := 1.0
+	if value := c.Query("speed"); value != "" {
+		var err error
+		speed, err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			return history, 0, fmt.Errorf("invalid speed")
+		}
+	}
+	return history, speed, playback.ValidateSpeed(speed)
+}
+
+// playbackWebSocketGenerator replays stored history between two times, as if it was streaming live.
+// it takes the same device filters as the stream, or repeated device_id parameters
+func playbackWebSocketGenerator(repo database.Repo) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		orgID := requestOrg(c)
+		deviceFilter, err := deviceFilterFromQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		window, speed, err := playbackOptionsFromQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		requestedIDs := c.QueryArray("device_id")
+		for _, deviceID := range requestedIDs {
+			if !isDeviceID(deviceID) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid device_id"})
+				return
+			}
+		}
+		devices, err := exportDevices(c.Request.Context(), repo, orgID, requestedIDs, deviceFilter)
+		if err != nil {
+			c.JSON(repoErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		deviceIDs := make([]string, len(devices))
+		for i, device := range devices {
+			deviceIDs[i] = device.DeviceID
+		}
+
+		ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer ws.Close()
+		fmt.Print("playback websocket connection opened\n")
+
+		// like the stream, the request's context outlives the hijacked connection, so queries use one cancelled when it closes
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		defer cancel()
+
+		// it is safe to have one reader and one writer concurrently, but the reader and pinger write too
+		muWriter := sync.Mutex{}
+		writeWait := 3 * time.Second
+		write := func(message any) error {
+			muWriter.Lock()
+			defer muWriter.Unlock()
+			ws.SetWriteDeadline(time.Now().Add(writeWait))
+			if text, ok := message.(string); ok {
+				return ws.WriteMessage(websocket.TextMessage, []byte(text))
+			}
+			if message == nil {
+				return ws.WriteMessage(websocket.PingMessage, nil)
+			}
+			return ws.WriteJSON(message)
+		}
+
+		// ping pong
+		pongWait := 10 * time.Second
+		pingPeriod := (pongWait * 9) / 10
+		controls := make(chan PlaybackControl, 16)
+		closed := make(chan struct{})
+		// the reader stops handing over controls once playback has stopped taking them
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			defer cancel()
+			defer close(closed)
+			ws.SetReadDeadline(time.Now().Add(pongWait))
+			ws.SetPongHandler(func(string) error {
+				ws.SetReadDeadline(time.Now().Add(pongWait))
+				return nil
+			})
+			for {
+				messageType, bytes, err := ws.ReadMessage()
+				if err != nil {
+					fmt.Printf("error reading from playback websocket: %v\n", err)
+					return
+				}
+				if messageType != websocket.TextMessage {
+					continue
+				}
+				if string(bytes) == "ping" {
+					if err := write("pong"); err != nil {
+						fmt.Printf("error writing user-level pong message to playback websocket: %v\n", err)
+						return
+					}
+					continue
+				}
+				control := PlaybackControl{}
+				if err := json.Unmarshal(bytes, &control); err != nil {
+					fmt.Printf("ignoring invalid playback control %q: %v\n", bytes, err)
+					continue
+				}
+				select {
+				case controls <- control:
+				case <-done:
+					return
+				}
+			}
+		}()
+		go func() {
+			for {
+				select {
+				case <-closed:
+					return
+				case <-time.After(pingPeriod):
+				}
+				if err := write(nil); err != nil {
+					fmt.Printf("error writing ping message to playback websocket: %v\n", err)
+					cancel()
+					return
+				}
+			}
+		}()
+
+		clock := playback.NewClock(window.StartTime, speed)
+		timeline := playback.NewTimeline(repo, orgID, deviceIDs, window.StartTime, window.EndTime)
+		ended := false
+		send := func(geolocations []*database.DeviceGeolocation, resync bool) error {
+			return write(GeolocationsWebSocketMessage{
+				Geolocations: geolocations,
+				Resync:       resync,
+				Playback: &PlaybackState{
+					Time:   clock.Now(),
+					Speed:  clock.Speed(),
+					Paused: clock.Paused(),
+					Ended:  ended,
+				},
+			})
+		}
+
+		// seed starts the map from where each device was at the time, rather than empty until it next reports
+		seed := func(at time.Time) error {
+			positions, err := repo.GetMultiGeolocationsAt(ctx, orgID, deviceIDs, at)
+			if err != nil {
+				return err
+			}
+			geolocations := []*database.DeviceGeolocation{}
+			for _, geolocation := range positions {
+				if geolocation != nil {
+					geolocations = append(geolocations, geolocation)
+				}
+			}
+			return send(geolocations, true)
+		}
+
+		if err := seed(window.StartTime); err != nil {
+			fmt.Printf("error starting playback: %v\n", err)
+			return
+		}
+
+		// frames are sent this often, with each device's latest geolocation since the last frame
+		frameInterval := 100 * time.Millisecond
+		ticker := time.NewTicker(frameInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-closed:
+				fmt.Print("playback websocket connection closed\n")
+				return
+			case control := <-controls:
+				resync := false
+				switch control.Action {
+				case "pause":
+					clock.Pause()
+				case "play":
+					clock.Play()
+				case "seek":
+					if control.Time.Before(window.StartTime) || !control.Time.Before(window.EndTime) {
+						fmt.Printf("ignoring seek outside the playback window: %v\n", control.Time)
+						continue
+					}
+					// clients clear the map, and it's seeded again like at the start
+					clock.Seek(control.Time)
+					timeline = playback.NewTimeline(repo, orgID, deviceIDs, control.Time, window.EndTime)
+					ended = false
+					resync = true
+				case "speed":
+					if err := playback.ValidateSpeed(control.Speed); err != nil {
+						fmt.Printf("ignoring playback speed: %v\n", err)
+						continue
+					}
+					clock.SetSpeed(control.Speed)
+				default:
+					fmt.Printf("ignoring unknown playback action: %v\n", control.Action)
+					continue
+				}
+				// acknowledge with the new state
+				var err error
+				if resync {
+					err = seed(control.Time)
+				} else {
+					err = send([]*database.DeviceGeolocation{}, false)
+				}
+				if err != nil {
+					fmt.Printf("error writing json to playback websocket: %v\n", err)
+					return
+				}
+			case <-ticker.C:
+				if clock.Paused() || ended {
+					continue
+				}
+				now := clock.Now()
+				played, err := timeline.Until(ctx, now)
+				if err != nil {
+					fmt.Printf("error playing back geolocations: %v\n", err)
+					return
+				}
+				done, err := timeline.Done(ctx)
+				if err != nil {
+					fmt.Printf("error playing back geolocations: %v\n", err)
+					return
+				}
+				if done || !now.Before(window.EndTime) {
+					ended = true
+					clock.Pause()
+					if clock.Now().After(window.EndTime) {
+						clock.Seek(window.EndTime)
+					}
+				}
+				if len(played) == 0 && !ended {
+					continue
+				}
+
+				// like the live stream, only each device's latest position is sent
+				latest := map[string]int{}
+				geolocations := []*database.DeviceGeolocation{}
+				for _, g := range played {
+					if i, ok := latest[g.DeviceID]; ok {
+						geolocations[i] = g
+						continue
+					}
+					latest[g.DeviceID] = len(geolocations)
+					geolocations = append(geolocations, g)
+				}
+				if err := send(geolocations, false); err != nil {
+					fmt.Printf("error writing json to playback websocket: %v\n", err)
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/database"
+	"github.com/NinjaPerson24119/MapProject/backend/internal/geojson"
+	"github.com/gin-gonic/gin"
+)
+
+var deviceIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// device and org ids are uuids, so anything else can be rejected before it reaches the database
+func isDeviceID(id string) bool {
+	return deviceIDPattern.MatchString(id)
+}
+
+// validateGeolocation applies the rules for geolocations sent by devices, with errors meant for them
+func validateGeolocation(geolocation *database.DeviceGeolocation) error {
+	if geolocation.DeviceID == "" {
+		return fmt.Errorf("missing device_id")
+	}
+	if !isDeviceID(geolocation.DeviceID) {
+		return fmt.Errorf("invalid device_id")
+	}
+	if geolocation.Latitude < -90 || geolocation.Latitude > 90 {
+		return fmt.Errorf("invalid latitude")
+	}
+	if geolocation.Longitude < -180 || geolocation.Longitude > 180 {
+		return fmt.Errorf("invalid longitude")
+	}
+	if geolocation.EventTime.IsZero() {
+		return fmt.Errorf("missing event_time")
+	}
+	return geolocation.Telemetry.Validate()
+}
+
+const (
+	jsonFormat    = "json"
+	geoJSONFormat = "geojson"
+)
+
+// formatFromRequest reads the response format from the format query parameter, or else the Accept header.
+// an unknown format is answered with a bad request, and ok is false
+func formatFromRequest(c *gin.Context) (format string, ok bool) {
+	switch format := c.Query("format"); format {
+	case jsonFormat, geoJSONFormat:
+		return format, true
+	case "":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown format: %v", format)})
+		return "", false
+	}
+	if strings.Contains(c.GetHeader("Accept"), geojson.ContentType) {
+		return geoJSONFormat, true
+	}
+	return jsonFormat, true
+}
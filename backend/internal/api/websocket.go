@@ -2,6 +2,8 @@ package api
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"sync"
@@ -17,6 +19,12 @@ import (
 
 type GeolocationsWebSocketMessage struct {
 	Geolocations []*database.DeviceGeolocation `json:"geolocations"`
+	// devices that were deleted since the last message, so clients can take them off the map
+	RemovedDeviceIDs []string `json:"removed_device_ids,omitempty"`
+	// the server lost track of updates, so clients should replace what they have with these geolocations
+	Resync bool `json:"resync,omitempty"`
+	// only sent by playback
+	Playback *PlaybackState `json:"playback,omitempty"`
 }
 
 var upgrader = websocket.Upgrader{
@@ -25,8 +33,31 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
+// deviceFilterFromQuery reads a device filter like ?device_type=quadcopter&tag=a&tag=b&attributes={"operator":"acme"}
+func deviceFilterFromQuery(c *gin.Context) (filters.DeviceFilter, error) {
+	filter := filters.DeviceFilter{
+		DeviceType: c.Query("device_type"),
+		Model:      c.Query("model"),
+		Tags:       c.QueryArray("tag"),
+	}
+	if attributes := c.Query("attributes"); attributes != "" {
+		if err := json.Unmarshal([]byte(attributes), &filter.Attributes); err != nil {
+			return filters.DeviceFilter{}, fmt.Errorf("invalid attributes: %v", err)
+		}
+	}
+	return filter, filter.Validate()
+}
+
 func geolocationsWebSocketGenerator(repo database.Repo) func(c *gin.Context) {
 	return func(c *gin.Context) {
+		// streams only carry the org's own devices
+		orgID := requestOrg(c)
+		deviceFilter, err := deviceFilterFromQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
 		ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -35,6 +66,10 @@ func geolocationsWebSocketGenerator(repo database.Repo) func(c *gin.Context) {
 		defer ws.Close()
 		var wsClosed atomic.Bool
 		wsClosed.Store(false)
+		// the request's context isn't cancelled when the client goes away, since the connection was hijacked,
+		// so this one is, to stop listening for the org's notifications
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		defer cancel()
 
 		fmt.Print("websocket connection opened\n")
 
@@ -79,6 +114,7 @@ func geolocationsWebSocketGenerator(repo database.Repo) func(c *gin.Context) {
 			}
 			fmt.Print("propagated close over atomic bool")
 			wsClosed.Store(true)
+			cancel()
 		}()
 		go func() {
 			for {
@@ -94,111 +130,194 @@ func geolocationsWebSocketGenerator(repo database.Repo) func(c *gin.Context) {
 				time.Sleep(pingPeriod)
 			}
 			wsClosed.Store(true)
+			cancel()
 		}()
 
-		// buffer geolocations
-		muFlaggedDeviceIDs := sync.Mutex{}
-		flaggedDeviceIDs := map[string]bool{}
+		// buffer geolocations. notifications carry the whole row, so only changed devices need a query
+		muBuffered := sync.Mutex{}
+		bufferedGeolocations := map[string]*database.DeviceGeolocation{}
+		changedDeviceIDs := map[string]bool{}
+		// set on resync, since devices may have changed while the listener was disconnected
+		staleMatches := false
 		bufferSize := constants.SimulatedDevices
 		bufferPeriod := time.Second / 2
 		timeAtLastSend := time.Now()
 		checkPeriod := time.Millisecond * 10
+
+		// whether devices match the filter, looked up once each and again when they change.
+		// only the sending goroutine uses this
+		matches := map[string]bool{}
+		matchesDeviceFilter := func(deviceID string) (bool, error) {
+			if matched, ok := matches[deviceID]; ok {
+				return matched, nil
+			}
+			device, err := repo.GetDevice(ctx, orgID, deviceID)
+			if errors.Is(err, database.ErrNotFound) {
+				return false, nil
+			}
+			if err != nil {
+				return false, err
+			}
+			matches[deviceID] = device.Deleted == nil && device.Matches(deviceFilter)
+			return matches[deviceID], nil
+		}
+
 		go func() {
+			// failing to send means the client is gone too
+			defer cancel()
 			for {
 				if wsClosed.Load() {
-					fmt.Print("websocket closed while processing flagged geolocations")
+					fmt.Print("websocket closed while processing buffered geolocations")
 					return
 				}
 
-				// wait until there are enough flagged geolocations or enough time has passed
-				muFlaggedDeviceIDs.Lock()
-				flaggedDeviceIDsLength := len(flaggedDeviceIDs)
-				muFlaggedDeviceIDs.Unlock()
-				if (flaggedDeviceIDsLength < bufferSize && time.Since(timeAtLastSend) < bufferPeriod) || flaggedDeviceIDsLength == 0 {
+				// wait until there are enough buffered geolocations or enough time has passed
+				muBuffered.Lock()
+				bufferedLength := len(bufferedGeolocations) + len(changedDeviceIDs)
+				muBuffered.Unlock()
+				if (bufferedLength < bufferSize && time.Since(timeAtLastSend) < bufferPeriod) || bufferedLength == 0 {
 					continue
 				}
 
-				// get flagged geolocations as a list of IDs, then clear the flag
-				geolocationIDs := []string{}
-				muFlaggedDeviceIDs.Lock()
-				for k := range flaggedDeviceIDs {
-					geolocationIDs = append(geolocationIDs, k)
+				// take the buffer, then reset it
+				muBuffered.Lock()
+				geolocations := bufferedGeolocations
+				changed := changedDeviceIDs
+				bufferedGeolocations = map[string]*database.DeviceGeolocation{}
+				changedDeviceIDs = map[string]bool{}
+				if staleMatches {
+					matches = map[string]bool{}
+					staleMatches = false
 				}
-				flaggedDeviceIDs = map[string]bool{}
-				muFlaggedDeviceIDs.Unlock()
-				fmt.Printf("got %v flagged geolocations and reset flags\n", len(geolocationIDs))
+				muBuffered.Unlock()
+				fmt.Printf("got %v buffered geolocations and %v changed devices\n", len(geolocations), len(changed))
 
-				// get flagged geolocations from the database
-				geolocations, err := repo.GetMultiLatestGeolocations(c.Request.Context(), geolocationIDs)
-				if err != nil {
-					fmt.Printf("error getting flagged geolocations: %v\n", err)
-					return
+				// look up changed devices, which may have been deleted or restored
+				removedDeviceIDs := []string{}
+				if len(changed) > 0 {
+					changedIDs := []string{}
+					for deviceID := range changed {
+						changedIDs = append(changedIDs, deviceID)
+					}
+					latest, err := repo.GetMultiLatestGeolocations(ctx, orgID, changedIDs)
+					if err != nil {
+						fmt.Printf("error getting changed devices' geolocations: %v\n", err)
+						return
+					}
+					// not found geolocations will be returned from GetMulti as nil, which happens when the device was deleted
+					for i, g := range latest {
+						if g != nil {
+							geolocations[changedIDs[i]] = g
+						} else {
+							delete(geolocations, changedIDs[i])
+							removedDeviceIDs = append(removedDeviceIDs, changedIDs[i])
+						}
+					}
 				}
-
-				// not found geolocations will be returned from GetMulti as nil
-				geolocationsWithoutNil := []*database.DeviceGeolocation{}
-				for _, g := range geolocations {
-					if g != nil {
-						geolocationsWithoutNil = append(geolocationsWithoutNil, g)
-					} else {
-						fmt.Printf("geolocation not found after notification: %v\n", g)
+				// changed devices may no longer match the filter, so clients drop them like deleted devices
+				if !deviceFilter.IsEmpty() {
+					for deviceID := range changed {
+						delete(matches, deviceID)
+					}
+					for deviceID := range geolocations {
+						matched, err := matchesDeviceFilter(deviceID)
+						if err != nil {
+							fmt.Printf("error checking device filter: %v\n", err)
+							return
+						}
+						if !matched {
+							delete(geolocations, deviceID)
+							if changed[deviceID] {
+								removedDeviceIDs = append(removedDeviceIDs, deviceID)
+							}
+						}
 					}
 				}
-				if len(geolocationsWithoutNil) == 0 {
-					fmt.Printf("getmulti only returned nil geolocations\n")
+				if len(geolocations) == 0 && len(removedDeviceIDs) == 0 {
 					continue
 				}
 
-				// send flagged geolocations to the websocket
-				muWriter.Lock()
-				ws.SetWriteDeadline(time.Now().Add(writeWait))
+				// send buffered geolocations to the websocket
 				json := GeolocationsWebSocketMessage{
-					Geolocations: geolocationsWithoutNil,
+					Geolocations:     make([]*database.DeviceGeolocation, 0, len(geolocations)),
+					RemovedDeviceIDs: removedDeviceIDs,
 				}
-				err = ws.WriteJSON(json)
+				for _, g := range geolocations {
+					json.Geolocations = append(json.Geolocations, g)
+				}
+				muWriter.Lock()
+				ws.SetWriteDeadline(time.Now().Add(writeWait))
+				err := ws.WriteJSON(json)
 				muWriter.Unlock()
 				if err != nil {
 					fmt.Printf("error writing json to websocket: %v\n", err)
 					return
 				}
 				timeAtLastSend = time.Now()
-				fmt.Printf("sent %v geolocations to websocket\n", len(geolocations))
+				fmt.Printf("sent %v geolocations to websocket\n", len(json.Geolocations))
 
 				// avoid hammering the locks
 				time.Sleep(checkPeriod)
 			}
 		}()
 
+		sendAllGeolocations := func(resync bool) error {
+			fmt.Print("sending complete geolocations update to websocket\n")
+			geolocations, err := getLatestGeolocations(ctx, repo, orgID, deviceFilter)
+			if err != nil {
+				return err
+			}
+			json := GeolocationsWebSocketMessage{
+				Geolocations: geolocations,
+				Resync:       resync,
+			}
+			muWriter.Lock()
+			ws.SetWriteDeadline(time.Now().Add(writeWait))
+			err = ws.WriteJSON(json)
+			muWriter.Unlock()
+			if err != nil {
+				return fmt.Errorf("error writing json to websocket: %v", err)
+			}
+			fmt.Print("sent complete geolocations update to websocket\n")
+			return nil
+		}
+
 		// begin connection by sending all geolocations
-		fmt.Print("sending complete geolocations update to websocket\n")
-		geolocations, err := getLatestGeolocations(c.Request.Context(), repo)
+		err = sendAllGeolocations(false)
 		if err != nil {
+			fmt.Printf("error sending geolocations: %v\n", err)
 			c.Status(http.StatusInternalServerError)
 			return
 		}
-		json := GeolocationsWebSocketMessage{
-			Geolocations: geolocations,
-		}
-		muWriter.Lock()
-		ws.SetWriteDeadline(time.Now().Add(writeWait))
-		err = ws.WriteJSON(json)
-		muWriter.Unlock()
-		if err != nil {
-			fmt.Printf("error writing json to websocket: %v\n", err)
-			return
-		}
-		fmt.Print("sent complete geolocations update to websocket\n")
 
 		// listen to updates and send new geolocations as they occur
-		err = repo.ListenToGeolocationInserted(c.Request.Context(), func(deviceID string) error {
-			muFlaggedDeviceIDs.Lock()
-			flaggedDeviceIDs[deviceID] = true
-			muFlaggedDeviceIDs.Unlock()
+		err = repo.ListenToGeolocationInserted(ctx, orgID, func(geolocation *database.DeviceGeolocation) error {
+			muBuffered.Lock()
+			if geolocation.EventTime.IsZero() {
+				changedDeviceIDs[geolocation.DeviceID] = true
+			} else if buffered, ok := bufferedGeolocations[geolocation.DeviceID]; !ok || !geolocation.EventTime.Before(buffered.EventTime) {
+				// out of order inserts don't move a device backwards, like the latest geolocation table.
+				// the same event time again is a position that was corrected in place
+				bufferedGeolocations[geolocation.DeviceID] = geolocation
+			}
+			muBuffered.Unlock()
 
 			if wsClosed.Load() {
 				return fmt.Errorf("websocket closed while handling geolocation inserted")
 			}
 			return nil
+		}, func() error {
+			// the listener reconnected, so anything buffered is superseded by a fresh snapshot
+			muBuffered.Lock()
+			bufferedGeolocations = map[string]*database.DeviceGeolocation{}
+			changedDeviceIDs = map[string]bool{}
+			staleMatches = true
+			muBuffered.Unlock()
+
+			if wsClosed.Load() {
+				return fmt.Errorf("websocket closed while resyncing")
+			}
+			return sendAllGeolocations(true)
 		})
 		if err != nil {
 			fmt.Printf("error listening to geolocation inserted: %v\n", err)
@@ -209,26 +328,24 @@ func geolocationsWebSocketGenerator(repo database.Repo) func(c *gin.Context) {
 	}
 }
 
-func getLatestGeolocations(ctx context.Context, repo database.Repo) ([]*database.DeviceGeolocation, error) {
+func getLatestGeolocations(ctx context.Context, repo database.Repo, orgID string, deviceFilter filters.DeviceFilter) ([]*database.DeviceGeolocation, error) {
+	// cursor paging, so devices inserted while we page don't shift rows between pages
 	geolocations := []*database.DeviceGeolocation{}
-	page := 1
+	cursor := ""
 	for {
-		fmt.Printf("getting latest geolocations page %v\n", page)
-		geolocationsPage, err := repo.ListLatestGeolocations(ctx, filters.PageOptions{
-			Page:     page,
+		fmt.Printf("getting latest geolocations page after cursor %q\n", cursor)
+		geolocationsPage, nextCursor, err := repo.ListLatestGeolocations(ctx, orgID, filters.PageOptions{
 			PageSize: 100,
-		})
+			Cursor:   cursor,
+		}, filters.SpatialFilter{}, deviceFilter)
 		if err != nil {
 			return nil, fmt.Errorf("error getting latest geolocations: %v\n", err)
 		}
-		if len(geolocationsPage) == 0 {
-			break
-		}
 		geolocations = append(geolocations, geolocationsPage...)
-		if len(geolocations) >= constants.SimulatedDevices {
+		if nextCursor == "" || len(geolocations) >= constants.SimulatedDevices {
 			break
 		}
-		page++
+		cursor = nextCursor
 	}
 	upperBound := constants.SimulatedDevices
 	if upperBound > len(geolocations) {
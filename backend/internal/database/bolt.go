@@ -0,0 +1,841 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/filters"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	orgsBucket = []byte("orgs")
+	// org ids keyed by the hash of their api key
+	apiKeysBucket = []byte("api_keys")
+	devicesBucket = []byte("devices")
+	// one nested bucket of history per device, keyed by event time so it iterates oldest first
+	geolocationsBucket = []byte("geolocations")
+	// like device.latest_geolocation, this outlives history that retention removes
+	latestBucket = []byte("latest")
+)
+
+// BoltRepo is a Repo that keeps everything in a single bbolt file, for running without a postgres server.
+// like MemoryRepo, it enforces the same constraints as the postgres schema and notifies listeners in process.
+// only one process can open the file at a time
+type BoltRepo struct {
+	db  *bolt.DB
+	hub *NotificationHub
+}
+
+func NewBolt(path string) (*BoltRepo, error) {
+	if path == "" {
+		return nil, fmt.Errorf("missing bolt file path")
+	}
+	// fail instead of waiting forever when another server has the file open
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt file %v: %v", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{orgsBucket, apiKeysBucket, devicesBucket, geolocationsBucket, latestBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		// like the migration, there's always a default org
+		orgs := tx.Bucket(orgsBucket)
+		if orgs.Get([]byte(DefaultOrgID)) != nil {
+			return nil
+		}
+		return putJSON(orgs, []byte(DefaultOrgID), &Organization{
+			OrgID:   DefaultOrgID,
+			Name:    "default",
+			Created: time.Now(),
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create bolt buckets: %v", err)
+	}
+	return &BoltRepo{
+		db:  db,
+		hub: NewNotificationHub(),
+	}, nil
+}
+
+func (s *BoltRepo) Close() {
+	s.hub.CloseAll()
+	s.db.Close()
+}
+
+// eventTimeKey sorts bytewise in time order. flipping the sign bit keeps times before 1970 ahead of those after
+func eventTimeKey(eventTime time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(eventTime.UnixNano())^(1<<63))
+	return key
+}
+
+// unixNanoFromKey reverses eventTimeKey
+func unixNanoFromKey(key []byte) int64 {
+	return int64(binary.BigEndian.Uint64(key) ^ (1 << 63))
+}
+
+func getJSON[T any](bucket *bolt.Bucket, key []byte) (*T, error) {
+	value := bucket.Get(key)
+	if value == nil {
+		return nil, nil
+	}
+	decoded := new(T)
+	if err := json.Unmarshal(value, decoded); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %v", key, err)
+	}
+	return decoded, nil
+}
+
+func putJSON(bucket *bolt.Bucket, key []byte, value any) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, encoded)
+}
+
+// getDevice is getJSON for devices. devices stored before organizations existed belong to the default org
+func getDevice(bucket *bolt.Bucket, deviceID []byte) (*Device, error) {
+	device, err := getJSON[Device](bucket, deviceID)
+	if device != nil && device.OrgID == "" {
+		device.OrgID = DefaultOrgID
+	}
+	return device, err
+}
+
+// getOrgDevice returns nil if the device doesn't exist or is in another org
+func getOrgDevice(bucket *bolt.Bucket, orgID string, deviceID []byte) (*Device, error) {
+	device, err := getDevice(bucket, deviceID)
+	if err != nil || device == nil || device.OrgID != orgID {
+		return nil, err
+	}
+	return device, nil
+}
+
+func (s *BoltRepo) InsertDevice(ctx context.Context, orgID string, device *Device) (string, error) {
+	id, err := newUUID()
+	if err != nil {
+		return "", fmt.Errorf("failed to insert device: %v", err)
+	}
+	now := time.Now()
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(orgsBucket).Get([]byte(orgID)) == nil {
+			return fmt.Errorf("org %v: %w", orgID, ErrNotFound)
+		}
+		return putJSON(tx.Bucket(devicesBucket), []byte(id), &Device{
+			DeviceID:   id,
+			OrgID:      orgID,
+			Name:       device.Name,
+			DeviceType: device.DeviceType,
+			Model:      device.Model,
+			Tags:       append([]string{}, device.Tags...),
+			Attributes: copyAttributes(device.Attributes),
+			Created:    now,
+			Updated:    &now,
+		})
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to insert device: %v", err)
+	}
+	return id, nil
+}
+
+func (s *BoltRepo) ListDevices(ctx context.Context, orgID string, paging filters.PageOptions, filter filters.DeviceFilter) ([]*Device, string, error) {
+	if err := paging.Validate(); err != nil {
+		return nil, "", fmt.Errorf("repo: %v", err)
+	}
+	if err := filter.Validate(); err != nil {
+		return nil, "", fmt.Errorf("repo: %v", err)
+	}
+
+	devices := []*Device{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// keys are device ids, so walking backwards is already sorted descending
+		c := tx.Bucket(devicesBucket).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			device := &Device{OrgID: DefaultOrgID}
+			if err := json.Unmarshal(v, device); err != nil {
+				return fmt.Errorf("failed to decode device %s: %v", k, err)
+			}
+			if device.OrgID == orgID && device.Deleted == nil && device.Matches(filter) {
+				devices = append(devices, device.Copy())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list devices: %v", err)
+	}
+	page, nextCursor, err := paginate(devices, paging, func(device *Device) string {
+		return device.DeviceID
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return page, nextCursor, nil
+}
+
+func (s *BoltRepo) GetDevice(ctx context.Context, orgID string, deviceID string) (*Device, error) {
+	var device *Device
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		device, err = getOrgDevice(tx.Bucket(devicesBucket), orgID, []byte(deviceID))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device: %v", err)
+	}
+	if device == nil {
+		return nil, fmt.Errorf("device %v: %w", deviceID, ErrNotFound)
+	}
+	return device.Copy(), nil
+}
+
+func (s *BoltRepo) GetMultiDevices(ctx context.Context, orgID string, deviceIDs []string) ([]*Device, error) {
+	devices := make([]*Device, len(deviceIDs))
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(devicesBucket)
+		for i, deviceID := range deviceIDs {
+			device, err := getOrgDevice(bucket, orgID, []byte(deviceID))
+			if err != nil {
+				return err
+			}
+			if device != nil {
+				devices[i] = device.Copy()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get devices: %v", err)
+	}
+	return devices, nil
+}
+
+// updateDevice applies change to a stored device in the org and saves it, unless change returns an error
+func (s *BoltRepo) updateDevice(orgID string, deviceID string, change func(device *Device) error) (*Device, error) {
+	var device *Device
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(devicesBucket)
+		var err error
+		device, err = getOrgDevice(bucket, orgID, []byte(deviceID))
+		if err != nil {
+			return err
+		}
+		if device == nil {
+			return fmt.Errorf("device %v: %w", deviceID, ErrNotFound)
+		}
+		if err := change(device); err != nil {
+			return err
+		}
+		return putJSON(bucket, []byte(deviceID), device)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return device.Copy(), nil
+}
+
+func (s *BoltRepo) UpdateDevice(ctx context.Context, orgID string, update *DeviceUpdate) (*Device, error) {
+	device, err := s.updateDevice(orgID, update.DeviceID, func(existing *Device) error {
+		if existing.Deleted != nil {
+			return fmt.Errorf("device %v: %w", update.DeviceID, ErrNotFound)
+		}
+		update.apply(existing, time.Now())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// streams filtering on what changed pick up or drop the device
+	s.hub.Publish(orgID, &DeviceGeolocation{DeviceID: update.DeviceID})
+	return device, nil
+}
+
+func (s *BoltRepo) setDeviceDeleted(orgID string, deviceID string, deleted bool) (*Device, error) {
+	device, err := s.updateDevice(orgID, deviceID, func(existing *Device) error {
+		now := time.Now()
+		if !deleted {
+			existing.Deleted = nil
+		} else if existing.Deleted == nil {
+			existing.Deleted = &now
+		}
+		existing.Updated = &now
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// streams drop or pick up the device, like the notification the postgres repo sends
+	s.hub.Publish(orgID, &DeviceGeolocation{DeviceID: deviceID})
+	return device, nil
+}
+
+func (s *BoltRepo) DeleteDevice(ctx context.Context, orgID string, deviceID string) (*Device, error) {
+	return s.setDeviceDeleted(orgID, deviceID, true)
+}
+
+func (s *BoltRepo) RestoreDevice(ctx context.Context, orgID string, deviceID string) (*Device, error) {
+	return s.setDeviceDeleted(orgID, deviceID, false)
+}
+
+func (s *BoltRepo) InsertOrganization(ctx context.Context, org *Organization) (string, error) {
+	id, err := newUUID()
+	if err != nil {
+		return "", fmt.Errorf("failed to insert org: %v", err)
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(orgsBucket), []byte(id), &Organization{
+			OrgID:   id,
+			Name:    org.Name,
+			Created: time.Now(),
+		})
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to insert org: %v", err)
+	}
+	return id, nil
+}
+
+func (s *BoltRepo) ListOrganizations(ctx context.Context) ([]*Organization, error) {
+	orgs := []*Organization{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(orgsBucket).ForEach(func(k []byte, v []byte) error {
+			org := &Organization{}
+			if err := json.Unmarshal(v, org); err != nil {
+				return fmt.Errorf("failed to decode org %s: %v", k, err)
+			}
+			orgs = append(orgs, org)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list orgs: %v", err)
+	}
+	sortOrganizations(orgs)
+	return orgs, nil
+}
+
+func (s *BoltRepo) SetOrganizationAPIKey(ctx context.Context, orgID string, keyHash []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(orgsBucket).Get([]byte(orgID)) == nil {
+			return fmt.Errorf("org %v: %w", orgID, ErrNotFound)
+		}
+		// an org has one key, so the old one stops working
+		keys := tx.Bucket(apiKeysBucket)
+		old := [][]byte{}
+		err := keys.ForEach(func(k []byte, v []byte) error {
+			if string(v) == orgID {
+				old = append(old, bytes.Clone(k))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range old {
+			if err := keys.Delete(k); err != nil {
+				return err
+			}
+		}
+		return keys.Put(keyHash, []byte(orgID))
+	})
+}
+
+func (s *BoltRepo) GetOrganizationByAPIKey(ctx context.Context, keyHash []byte) (*Organization, error) {
+	var org *Organization
+	err := s.db.View(func(tx *bolt.Tx) error {
+		orgID := tx.Bucket(apiKeysBucket).Get(keyHash)
+		if orgID == nil {
+			return fmt.Errorf("api key: %w", ErrNotFound)
+		}
+		var err error
+		org, err = getJSON[Organization](tx.Bucket(orgsBucket), orgID)
+		if err != nil {
+			return err
+		}
+		if org == nil {
+			return fmt.Errorf("api key: %w", ErrNotFound)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return org, nil
+}
+
+func (s *BoltRepo) MoveDevice(ctx context.Context, deviceID string, orgID string) (*Device, error) {
+	var device *Device
+	from := ""
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(orgsBucket).Get([]byte(orgID)) == nil {
+			return fmt.Errorf("org %v: %w", orgID, ErrNotFound)
+		}
+		bucket := tx.Bucket(devicesBucket)
+		var err error
+		device, err = getDevice(bucket, []byte(deviceID))
+		if err != nil {
+			return err
+		}
+		if device == nil {
+			return fmt.Errorf("device %v: %w", deviceID, ErrNotFound)
+		}
+		from = device.OrgID
+		now := time.Now()
+		device.OrgID = orgID
+		device.Updated = &now
+		return putJSON(bucket, []byte(deviceID), device)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the old org's streams drop the device, and the new org's pick it up
+	s.hub.Publish(from, &DeviceGeolocation{DeviceID: deviceID})
+	s.hub.Publish(orgID, &DeviceGeolocation{DeviceID: deviceID})
+	return device.Copy(), nil
+}
+
+func (s *BoltRepo) InsertGeolocation(ctx context.Context, orgID string, geolocation *DeviceGeolocation) error {
+	err := s.InsertMultiGeolocation(ctx, orgID, []*DeviceGeolocation{geolocation})
+	if err != nil {
+		return fmt.Errorf("failed to insert geolocation: %w", err)
+	}
+	return nil
+}
+
+func (s *BoltRepo) InsertMultiGeolocation(ctx context.Context, orgID string, geolocations []*DeviceGeolocation) error {
+	_, err := s.insertMulti(orgID, geolocations)
+	return err
+}
+
+// insertMulti skips geolocations that are already stored, and returns how many it inserted
+func (s *BoltRepo) insertMulti(orgID string, geolocations []*DeviceGeolocation) (int, error) {
+	now := time.Now()
+	notifications := make([]*DeviceGeolocation, 0, len(geolocations))
+	inserted := 0
+
+	// all or nothing, like the transaction in the postgres implementation
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		devices := tx.Bucket(devicesBucket)
+		histories := tx.Bucket(geolocationsBucket)
+		latest := tx.Bucket(latestBucket)
+
+		for _, geolocation := range geolocations {
+			device, err := getOrgDevice(devices, orgID, []byte(geolocation.DeviceID))
+			if err != nil {
+				return err
+			}
+			if device == nil {
+				return fmt.Errorf("device %v: %w", geolocation.DeviceID, ErrNotFound)
+			}
+			if err := ValidateGeolocation(geolocation); err != nil {
+				return err
+			}
+
+			history, err := histories.CreateBucketIfNotExists([]byte(geolocation.DeviceID))
+			if err != nil {
+				return err
+			}
+			key := eventTimeKey(geolocation.EventTime)
+			// earlier rows in this batch are already in the bucket, so this keeps the first of duplicates within the batch too
+			if history.Get(key) != nil {
+				continue
+			}
+			copied := *geolocation
+			copied.Created = now
+			copied.Updated = &now
+			copied.Deleted = nil
+			if err := putJSON(history, key, &copied); err != nil {
+				return err
+			}
+			inserted++
+
+			current, err := getJSON[DeviceGeolocation](latest, []byte(geolocation.DeviceID))
+			if err != nil {
+				return err
+			}
+			if current == nil || copied.EventTime.After(current.EventTime) {
+				if err := putJSON(latest, []byte(geolocation.DeviceID), &copied); err != nil {
+					return err
+				}
+			}
+
+			// like the insert trigger, deleted devices don't notify
+			if device.Deleted == nil {
+				notifications = append(notifications, NotificationFor(geolocation))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert multi geolocation: %w", err)
+	}
+
+	for _, notification := range notifications {
+		s.hub.Publish(orgID, notification)
+	}
+	return inserted, nil
+}
+
+func (s *BoltRepo) IngestMultiGeolocation(ctx context.Context, orgID string, geolocations []*DeviceGeolocation, policy DuplicatePolicy) ([]*IngestResult, error) {
+	if _, err := ParseDuplicatePolicy(string(policy)); err != nil {
+		return nil, fmt.Errorf("repo: %v", err)
+	}
+
+	var plan *ingestPlan
+	notifications := []*DeviceGeolocation{}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		devices := tx.Bucket(devicesBucket)
+		histories := tx.Bucket(geolocationsBucket)
+		latest := tx.Bucket(latestBucket)
+
+		// anything other than a duplicate still fails the whole batch, like the constraints in the postgres implementation
+		deleted := map[string]bool{}
+		for _, geolocation := range geolocations {
+			device, err := getOrgDevice(devices, orgID, []byte(geolocation.DeviceID))
+			if err != nil {
+				return err
+			}
+			if device == nil {
+				return fmt.Errorf("device %v: %w", geolocation.DeviceID, ErrNotFound)
+			}
+			if err := ValidateGeolocation(geolocation); err != nil {
+				return err
+			}
+			deleted[geolocation.DeviceID] = device.Deleted != nil
+		}
+
+		var storedErr error
+		plan = planIngest(geolocations, policy, func(geolocation *DeviceGeolocation) *DeviceGeolocation {
+			history := histories.Bucket([]byte(geolocation.DeviceID))
+			if history == nil {
+				return nil
+			}
+			stored, err := getJSON[DeviceGeolocation](history, eventTimeKey(geolocation.EventTime))
+			if err != nil {
+				storedErr = err
+			}
+			return stored
+		})
+		if storedErr != nil {
+			return storedErr
+		}
+
+		now := time.Now()
+		for _, geolocation := range append(plan.inserts, plan.updates...) {
+			history, err := histories.CreateBucketIfNotExists([]byte(geolocation.DeviceID))
+			if err != nil {
+				return err
+			}
+			key := eventTimeKey(geolocation.EventTime)
+			stored, err := getJSON[DeviceGeolocation](history, key)
+			if err != nil {
+				return err
+			}
+			if stored == nil {
+				stored = geolocation
+				stored.Created = now
+			}
+			stored.Latitude = geolocation.Latitude
+			stored.Longitude = geolocation.Longitude
+			stored.Telemetry = geolocation.Telemetry
+			stored.Updated = &now
+			stored.Deleted = nil
+			if err := putJSON(history, key, stored); err != nil {
+				return err
+			}
+
+			// an update to the latest geolocation changes it in place
+			current, err := getJSON[DeviceGeolocation](latest, []byte(geolocation.DeviceID))
+			if err != nil {
+				return err
+			}
+			if current == nil || !stored.EventTime.Before(current.EventTime) {
+				if err := putJSON(latest, []byte(geolocation.DeviceID), stored); err != nil {
+					return err
+				}
+			}
+
+			if !deleted[geolocation.DeviceID] {
+				notifications = append(notifications, NotificationFor(stored))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to ingest multi geolocation: %w", err)
+	}
+
+	for _, notification := range notifications {
+		s.hub.Publish(orgID, notification)
+	}
+	return plan.results, nil
+}
+
+func (s *BoltRepo) CopyMultiGeolocation(ctx context.Context, orgID string, geolocations []*DeviceGeolocation) (*IngestStats, error) {
+	before := time.Now()
+	inserted, err := s.insertMulti(orgID, geolocations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy multi geolocation: %w", err)
+	}
+	return &IngestStats{
+		Rows:       inserted,
+		Duplicates: len(geolocations) - inserted,
+		Duration:   time.Since(before),
+	}, nil
+}
+
+// activeLatest returns the latest geolocation of a device, or nil if it has none, was deleted, or is in another org
+func activeLatest(tx *bolt.Tx, orgID string, deviceID []byte, filter filters.DeviceFilter) (*DeviceGeolocation, error) {
+	device, err := getOrgDevice(tx.Bucket(devicesBucket), orgID, deviceID)
+	if err != nil || device == nil || device.Deleted != nil || !device.Matches(filter) {
+		return nil, err
+	}
+	return getJSON[DeviceGeolocation](tx.Bucket(latestBucket), deviceID)
+}
+
+func (s *BoltRepo) ListLatestGeolocations(ctx context.Context, orgID string, paging filters.PageOptions, spatial filters.SpatialFilter, device filters.DeviceFilter) ([]*DeviceGeolocation, string, error) {
+	if err := paging.Validate(); err != nil {
+		return nil, "", fmt.Errorf("repo: %v", err)
+	}
+	if err := spatial.Validate(); err != nil {
+		return nil, "", fmt.Errorf("repo: %v", err)
+	}
+	if err := device.Validate(); err != nil {
+		return nil, "", fmt.Errorf("repo: %v", err)
+	}
+
+	geolocations := []*DeviceGeolocation{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(latestBucket).Cursor()
+		for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+			geolocation, err := activeLatest(tx, orgID, k, device)
+			if err != nil {
+				return err
+			}
+			if geolocation != nil && spatial.Contains(geolocation.Latitude, geolocation.Longitude) {
+				geolocations = append(geolocations, geolocation)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list latest geolocations: %v", err)
+	}
+	page, nextCursor, err := paginate(geolocations, paging, func(geolocation *DeviceGeolocation) string {
+		return geolocation.DeviceID
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return page, nextCursor, nil
+}
+
+func (s *BoltRepo) GetMultiLatestGeolocations(ctx context.Context, orgID string, deviceIDs []string) ([]*DeviceGeolocation, error) {
+	// get multi returns the same order as the input. if a device is not found, it will be nil
+	ptrs := make([]*DeviceGeolocation, len(deviceIDs))
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for i, deviceID := range deviceIDs {
+			geolocation, err := activeLatest(tx, orgID, []byte(deviceID), filters.DeviceFilter{})
+			if err != nil {
+				return err
+			}
+			ptrs[i] = geolocation
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get multi latest geolocations: %v", err)
+	}
+	return ptrs, nil
+}
+
+func (s *BoltRepo) GetMultiGeolocationsAt(ctx context.Context, orgID string, deviceIDs []string, at time.Time) ([]*DeviceGeolocation, error) {
+	ptrs := make([]*DeviceGeolocation, len(deviceIDs))
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for i, deviceID := range deviceIDs {
+			device, err := getOrgDevice(tx.Bucket(devicesBucket), orgID, []byte(deviceID))
+			if err != nil {
+				return err
+			}
+			bucket := tx.Bucket(geolocationsBucket).Bucket([]byte(deviceID))
+			if device == nil || bucket == nil {
+				continue
+			}
+			// the last key before the first one after at
+			c := bucket.Cursor()
+			k, v := c.Seek(eventTimeKey(at.Add(time.Nanosecond)))
+			if k == nil {
+				k, v = c.Last()
+			} else {
+				k, v = c.Prev()
+			}
+			if k == nil {
+				continue
+			}
+			geolocation := &DeviceGeolocation{}
+			if err := json.Unmarshal(v, geolocation); err != nil {
+				return fmt.Errorf("failed to decode geolocation: %v", err)
+			}
+			ptrs[i] = geolocation
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get geolocations at %v: %v", at, err)
+	}
+	return ptrs, nil
+}
+
+func (s *BoltRepo) ListGeolocationHistory(ctx context.Context, orgID string, deviceID string, history filters.HistoryOptions, paging filters.PageOptions) ([]*DeviceGeolocation, string, error) {
+	if err := history.Validate(); err != nil {
+		return nil, "", fmt.Errorf("repo: %v", err)
+	}
+	if err := paging.Validate(); err != nil {
+		return nil, "", fmt.Errorf("repo: %v", err)
+	}
+	after, err := filters.DecodeTimeCursor(paging.Cursor)
+	if err != nil {
+		return nil, "", fmt.Errorf("repo: %v", err)
+	}
+
+	// subsampling needs the whole range, so it is read before paging.
+	// otherwise only the page is read, starting where the cursor left off
+	readPage := history.MaxPoints == 0
+	from := history.StartTime
+	if readPage && after != nil && after.After(from) {
+		from = *after
+	}
+	ranged := []*DeviceGeolocation{}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		// another org's device has no history, like one that doesn't exist
+		device, err := getOrgDevice(tx.Bucket(devicesBucket), orgID, []byte(deviceID))
+		if err != nil || device == nil {
+			return err
+		}
+		bucket := tx.Bucket(geolocationsBucket).Bucket([]byte(deviceID))
+		if bucket == nil {
+			return nil
+		}
+		end := eventTimeKey(history.EndTime)
+		c := bucket.Cursor()
+		for k, v := c.Seek(eventTimeKey(from)); k != nil && bytes.Compare(k, end) < 0; k, v = c.Next() {
+			if readPage && len(ranged) == paging.Offset()+paging.PageSize {
+				break
+			}
+			geolocation := &DeviceGeolocation{}
+			if err := json.Unmarshal(v, geolocation); err != nil {
+				return fmt.Errorf("failed to decode geolocation: %v", err)
+			}
+			if readPage && after != nil && !geolocation.EventTime.After(*after) {
+				continue
+			}
+			ranged = append(ranged, geolocation)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list geolocation history: %v", err)
+	}
+	if readPage {
+		after = nil
+	}
+	page, nextCursor := pageHistory(ranged, history, paging, after)
+	return page, nextCursor, nil
+}
+
+// deleteHistory removes the keys that remove returns from every device's history, and returns how many it removed
+func (s *BoltRepo) deleteHistory(remove func(history *bolt.Bucket) ([][]byte, error)) (int64, error) {
+	var deleted int64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		histories := tx.Bucket(geolocationsBucket)
+		return histories.ForEachBucket(func(deviceID []byte) error {
+			history := histories.Bucket(deviceID)
+			keys, err := remove(history)
+			if err != nil {
+				return err
+			}
+			// deleted after iterating, since deleting moves the cursor. keys are copied for the same reason
+			for _, key := range keys {
+				if err := history.Delete(key); err != nil {
+					return err
+				}
+			}
+			deleted += int64(len(keys))
+			return nil
+		})
+	})
+	return deleted, err
+}
+
+func (s *BoltRepo) CompactGeolocations(ctx context.Context, from time.Time, to time.Time, resolution time.Duration) (int64, error) {
+	if resolution <= 0 {
+		return 0, fmt.Errorf("repo: invalid resolution")
+	}
+
+	end := eventTimeKey(to)
+	compacted, err := s.deleteHistory(func(history *bolt.Bucket) ([][]byte, error) {
+		keys := [][]byte{}
+		c := history.Cursor()
+		k, _ := c.Seek(eventTimeKey(from))
+		for k != nil && bytes.Compare(k, end) < 0 {
+			key := k
+			k, _ = c.Next()
+			// history is sorted, so the last of each bucket is the one followed by a different bucket
+			lastInBucket := k == nil || bytes.Compare(k, end) >= 0 ||
+				unixNanoFromKey(k)/int64(resolution) != unixNanoFromKey(key)/int64(resolution)
+			if !lastInBucket {
+				keys = append(keys, append([]byte(nil), key...))
+			}
+		}
+		return keys, nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to compact geolocations: %v", err)
+	}
+	return compacted, nil
+}
+
+func (s *BoltRepo) DeleteGeolocationsBefore(ctx context.Context, before time.Time) (int64, error) {
+	end := eventTimeKey(before)
+	deleted, err := s.deleteHistory(func(history *bolt.Bucket) ([][]byte, error) {
+		keys := [][]byte{}
+		c := history.Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, end) < 0; k, _ = c.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		return keys, nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete geolocations: %v", err)
+	}
+	return deleted, nil
+}
+
+// EnsureGeolocationPartitions has nothing to do, since history is already split by device and sorted by time
+func (s *BoltRepo) EnsureGeolocationPartitions(ctx context.Context, from time.Time, to time.Time) (int, error) {
+	return 0, nil
+}
+
+func (s *BoltRepo) ListenToGeolocationInserted(ctx context.Context, orgID string, handler func(*DeviceGeolocation) error, resync func() error) error {
+	return s.hub.Listen(ctx, orgID, handler, resync)
+}
+
+func (s *BoltRepo) ListenerStats() ListenerStats {
+	return s.hub.Stats()
+}
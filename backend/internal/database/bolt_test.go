@@ -0,0 +1,231 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/filters"
+)
+
+func newTestBolt(t *testing.T, path string) *BoltRepo {
+	t.Helper()
+	repo, err := NewBolt(path)
+	if err != nil {
+		t.Fatalf("failed to open bolt file: %v", err)
+	}
+	return repo
+}
+
+func TestBoltKeepsStateAcrossRestarts(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "map-project.db")
+	repo := newTestBolt(t, path)
+	orgID, err := repo.InsertOrganization(ctx, &Organization{Name: "other"})
+	if err != nil {
+		t.Fatalf("failed to insert org: %v", err)
+	}
+	if err := repo.SetOrganizationAPIKey(ctx, orgID, HashAPIKey("other-key")); err != nil {
+		t.Fatalf("failed to set api key: %v", err)
+	}
+	deviceID := insertDevices(t, repo, orgID, 1)[0]
+	eventTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := repo.InsertGeolocation(ctx, orgID, &DeviceGeolocation{DeviceID: deviceID, EventTime: eventTime, Latitude: 53.5}); err != nil {
+		t.Fatalf("failed to insert geolocation: %v", err)
+	}
+	repo.Close()
+
+	repo = newTestBolt(t, path)
+	defer repo.Close()
+	org, err := repo.GetOrganizationByAPIKey(ctx, HashAPIKey("other-key"))
+	if err != nil || org.OrgID != orgID {
+		t.Fatalf("got org %+v and error %v, want %v", org, err, orgID)
+	}
+	if _, err := repo.GetDevice(ctx, orgID, deviceID); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	latest, err := repo.GetMultiLatestGeolocations(ctx, orgID, []string{deviceID})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(latest) != 1 || !latest[0].EventTime.Equal(eventTime) || latest[0].Latitude != 53.5 {
+		t.Errorf("got latest %+v", latest)
+	}
+	// the default org is only created once
+	orgs, err := repo.ListOrganizations(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(orgs) != 2 {
+		t.Errorf("got %d orgs, want 2", len(orgs))
+	}
+}
+
+func TestBoltOpenedOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "map-project.db")
+	repo := newTestBolt(t, path)
+	defer repo.Close()
+	if _, err := NewBolt(path); err == nil {
+		t.Errorf("expected an error opening a file that's already open")
+	}
+}
+
+func TestBoltOrgsAreSeparate(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestBolt(t, filepath.Join(t.TempDir(), "map-project.db"))
+	defer repo.Close()
+	orgID, err := repo.InsertOrganization(ctx, &Organization{Name: "other"})
+	if err != nil {
+		t.Fatalf("failed to insert org: %v", err)
+	}
+	deviceID := insertDevices(t, repo, orgID, 1)[0]
+
+	if _, err := repo.GetDevice(ctx, DefaultOrgID, deviceID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, want not found", err)
+	}
+	geolocation := &DeviceGeolocation{DeviceID: deviceID, EventTime: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	if err := repo.InsertGeolocation(ctx, DefaultOrgID, geolocation); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, want not found", err)
+	}
+	devices, _, err := repo.ListDevices(ctx, DefaultOrgID, filters.PageOptions{PageSize: 10}, filters.DeviceFilter{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(devices) != 0 {
+		t.Errorf("got %d devices from another org", len(devices))
+	}
+}
+
+func TestBoltHistoryIsInTimeOrder(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestBolt(t, filepath.Join(t.TempDir(), "map-project.db"))
+	defer repo.Close()
+	deviceID := insertDevices(t, repo, DefaultOrgID, 1)[0]
+	epoch := time.Unix(0, 0).UTC()
+	// inserted out of order, and on both sides of 1970
+	offsets := []time.Duration{time.Hour, -time.Hour, 0, -time.Second, time.Second}
+	geolocations := []*DeviceGeolocation{}
+	for _, offset := range offsets {
+		geolocations = append(geolocations, &DeviceGeolocation{DeviceID: deviceID, EventTime: epoch.Add(offset)})
+	}
+	if err := repo.InsertMultiGeolocation(ctx, DefaultOrgID, geolocations); err != nil {
+		t.Fatalf("failed to insert geolocations: %v", err)
+	}
+
+	history := filters.HistoryOptions{StartTime: epoch.Add(-2 * time.Hour), EndTime: epoch.Add(2 * time.Hour)}
+	played := []*DeviceGeolocation{}
+	cursor := ""
+	for {
+		page, nextCursor, err := repo.ListGeolocationHistory(ctx, DefaultOrgID, deviceID, history, filters.PageOptions{PageSize: 2, Cursor: cursor})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		played = append(played, page...)
+		if nextCursor == "" {
+			break
+		}
+		cursor = nextCursor
+	}
+	if len(played) != len(offsets) {
+		t.Fatalf("got %d points, want %d", len(played), len(offsets))
+	}
+	for i := 1; i < len(played); i++ {
+		if !played[i].EventTime.After(played[i-1].EventTime) {
+			t.Fatalf("points are out of order at %d: %v after %v", i, played[i].EventTime, played[i-1].EventTime)
+		}
+	}
+
+	at, err := repo.GetMultiGeolocationsAt(ctx, DefaultOrgID, []string{deviceID}, epoch.Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if at[0] == nil || !at[0].EventTime.Equal(epoch.Add(-time.Hour)) {
+		t.Errorf("got %+v, want the point an hour before 1970", at[0])
+	}
+}
+
+func TestBoltCopyMultiGeolocationCountsInserted(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestBolt(t, filepath.Join(t.TempDir(), "map-project.db"))
+	defer repo.Close()
+	deviceID := insertDevices(t, repo, DefaultOrgID, 1)[0]
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(seconds int) *DeviceGeolocation {
+		return &DeviceGeolocation{DeviceID: deviceID, EventTime: start.Add(time.Duration(seconds) * time.Second)}
+	}
+
+	// in order, since later batches overlap what earlier ones stored
+	tests := []struct {
+		name           string
+		geolocations   []*DeviceGeolocation
+		wantRows       int
+		wantDuplicates int
+	}{
+		{name: "new points", geolocations: []*DeviceGeolocation{at(0), at(1)}, wantRows: 2},
+		{name: "a stored point doesn't stop the rest", geolocations: []*DeviceGeolocation{at(1), at(2)}, wantRows: 1, wantDuplicates: 1},
+		{name: "the same point twice", geolocations: []*DeviceGeolocation{at(3), at(3)}, wantRows: 1, wantDuplicates: 1},
+		{name: "all stored", geolocations: []*DeviceGeolocation{at(0), at(1), at(2), at(3)}, wantDuplicates: 4},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stats, err := repo.CopyMultiGeolocation(ctx, DefaultOrgID, test.geolocations)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if stats.Rows != test.wantRows || stats.Duplicates != test.wantDuplicates {
+				t.Errorf("got %d rows and %d duplicates, want %d and %d", stats.Rows, stats.Duplicates, test.wantRows, test.wantDuplicates)
+			}
+		})
+	}
+}
+
+func TestBoltCompactGeolocations(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestBolt(t, filepath.Join(t.TempDir(), "map-project.db"))
+	defer repo.Close()
+	deviceID := insertDevices(t, repo, DefaultOrgID, 1)[0]
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	geolocations := []*DeviceGeolocation{}
+	// 4 points a second for 3 seconds
+	for i := 0; i < 12; i++ {
+		geolocations = append(geolocations, &DeviceGeolocation{DeviceID: deviceID, EventTime: start.Add(time.Duration(i) * 250 * time.Millisecond)})
+	}
+	if err := repo.InsertMultiGeolocation(ctx, DefaultOrgID, geolocations); err != nil {
+		t.Fatalf("failed to insert geolocations: %v", err)
+	}
+
+	// only the first 2 seconds are compacted, keeping the last point of each
+	compacted, err := repo.CompactGeolocations(ctx, start, start.Add(2*time.Second), time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if compacted != 6 {
+		t.Errorf("got %d compacted, want 6", compacted)
+	}
+	deleted, err := repo.DeleteGeolocationsBefore(ctx, start.Add(time.Second))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("got %d deleted, want 1", deleted)
+	}
+
+	history := filters.HistoryOptions{StartTime: start, EndTime: start.Add(time.Hour)}
+	kept, _, err := repo.ListGeolocationHistory(ctx, DefaultOrgID, deviceID, history, filters.PageOptions{PageSize: 100})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(kept) != 5 || !kept[0].EventTime.Equal(start.Add(1750*time.Millisecond)) {
+		t.Errorf("got %d points starting at %v", len(kept), kept[0].EventTime)
+	}
+	// the latest position outlives its history
+	latest, err := repo.GetMultiLatestGeolocations(ctx, DefaultOrgID, []string{deviceID})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(latest) != 1 || !latest[0].EventTime.Equal(start.Add(2750*time.Millisecond)) {
+		t.Errorf("got latest %+v", latest)
+	}
+}
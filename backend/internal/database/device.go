@@ -0,0 +1,105 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/filters"
+)
+
+// DeviceUpdate changes the fields that are set, and leaves the rest alone, so clients that only rename devices keep working
+type DeviceUpdate struct {
+	DeviceID   string          `json:"device_id"`
+	Name       *string         `json:"name"`
+	DeviceType *string         `json:"device_type"`
+	Model      *string         `json:"model"`
+	Tags       *[]string       `json:"tags"`
+	Attributes *map[string]any `json:"attributes"`
+}
+
+func validateTags(tags []string) error {
+	for _, tag := range tags {
+		if tag == "" {
+			return fmt.Errorf("invalid empty tag")
+		}
+	}
+	return nil
+}
+
+func validateAttributes(attributes map[string]any) error {
+	if _, err := json.Marshal(attributes); err != nil {
+		return fmt.Errorf("invalid attributes: %v", err)
+	}
+	return nil
+}
+
+func ValidateDevice(device *Device) error {
+	if device.Name == "" {
+		return fmt.Errorf("missing name")
+	}
+	if err := validateTags(device.Tags); err != nil {
+		return err
+	}
+	return validateAttributes(device.Attributes)
+}
+
+func (u *DeviceUpdate) Validate() error {
+	if u.Name != nil && *u.Name == "" {
+		return fmt.Errorf("missing name")
+	}
+	if u.Tags != nil {
+		if err := validateTags(*u.Tags); err != nil {
+			return err
+		}
+	}
+	if u.Attributes != nil {
+		if err := validateAttributes(*u.Attributes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply makes the update to a stored device, for repos that don't have a database to do it
+func (u *DeviceUpdate) apply(device *Device, now time.Time) {
+	if u.Name != nil {
+		device.Name = *u.Name
+	}
+	if u.DeviceType != nil {
+		device.DeviceType = *u.DeviceType
+	}
+	if u.Model != nil {
+		device.Model = *u.Model
+	}
+	if u.Tags != nil {
+		device.Tags = append([]string{}, *u.Tags...)
+	}
+	if u.Attributes != nil {
+		device.Attributes = copyAttributes(*u.Attributes)
+	}
+	device.Updated = &now
+}
+
+func (d *Device) Matches(filter filters.DeviceFilter) bool {
+	return filter.Matches(d.DeviceType, d.Model, d.Tags, d.Attributes)
+}
+
+// Copy doesn't share tags or attributes with the original, so stored devices can't be changed through what a repo returns.
+// devices stored before tags and attributes existed get empty ones, like the column defaults
+func (d *Device) Copy() *Device {
+	copied := *d
+	copied.Tags = append([]string{}, d.Tags...)
+	copied.Attributes = copyAttributes(d.Attributes)
+	return &copied
+}
+
+// copyAttributes is shallow, since attributes are only ever replaced as a whole.
+// missing attributes are an empty map, like the column default
+func copyAttributes(attributes map[string]any) map[string]any {
+	copied := make(map[string]any, len(attributes))
+	for key, value := range attributes {
+		copied[key] = value
+	}
+	return copied
+}
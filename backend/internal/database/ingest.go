@@ -0,0 +1,133 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// DuplicatePolicy decides what happens to a geolocation whose device and event time are already stored with a different position or telemetry.
+// exact duplicates, such as device retries, are always accepted without changing anything
+type DuplicatePolicy string
+
+const (
+	// RejectDuplicates reports the conflicting geolocation as rejected, and keeps the stored one
+	RejectDuplicates DuplicatePolicy = "reject"
+	// KeepFirst quietly keeps the stored geolocation
+	KeepFirst DuplicatePolicy = "keep_first"
+	// LastWriteWins replaces the stored position and telemetry with the new ones
+	LastWriteWins DuplicatePolicy = "last_write_wins"
+)
+
+func ParseDuplicatePolicy(s string) (DuplicatePolicy, error) {
+	switch DuplicatePolicy(s) {
+	case RejectDuplicates, KeepFirst, LastWriteWins:
+		return DuplicatePolicy(s), nil
+	}
+	return "", fmt.Errorf("unknown duplicate policy: %v", s)
+}
+
+type IngestStatus string
+
+const (
+	IngestInserted IngestStatus = "inserted"
+	// an exact duplicate of what was stored, so nothing changed
+	IngestDuplicate    IngestStatus = "duplicate"
+	IngestRejected     IngestStatus = "rejected"
+	IngestKeptExisting IngestStatus = "kept_existing"
+	IngestUpdated      IngestStatus = "updated"
+	// failed validation, or is for a device the org doesn't have, so it never reached the repo
+	IngestInvalid IngestStatus = "invalid"
+	// streamed, and stored in the background in relay mode. duplicates are handled by the policy then
+	IngestQueued IngestStatus = "queued"
+)
+
+// IngestResult is what happened to one geolocation passed to IngestMultiGeolocation
+type IngestResult struct {
+	DeviceID  string       `json:"device_id"`
+	EventTime time.Time    `json:"event_time"`
+	Status    IngestStatus `json:"status"`
+	Error     string       `json:"error,omitempty"`
+}
+
+type geolocationKey struct {
+	deviceID  string
+	eventTime int64
+}
+
+func keyOf(geolocation *DeviceGeolocation) geolocationKey {
+	return geolocationKey{geolocation.DeviceID, geolocation.EventTime.UnixNano()}
+}
+
+func sameGeolocation(a *DeviceGeolocation, b *DeviceGeolocation) bool {
+	return a.Latitude == b.Latitude && a.Longitude == b.Longitude && a.Telemetry.Equal(b.Telemetry)
+}
+
+// ingestPlan is what IngestMultiGeolocation should write, in input order
+type ingestPlan struct {
+	results []*IngestResult
+	inserts []*DeviceGeolocation
+	updates []*DeviceGeolocation
+}
+
+// planIngest compares each geolocation against what is stored, and earlier geolocations in the same batch, and applies the policy.
+// stored returns the geolocation already stored under the same key, or nil
+func planIngest(geolocations []*DeviceGeolocation, policy DuplicatePolicy, stored func(geolocation *DeviceGeolocation) *DeviceGeolocation) *ingestPlan {
+	plan := &ingestPlan{
+		results: make([]*IngestResult, len(geolocations)),
+	}
+	// what each key will hold once the batch is written
+	pending := map[geolocationKey]*DeviceGeolocation{}
+	for i, geolocation := range geolocations {
+		result := &IngestResult{
+			DeviceID:  geolocation.DeviceID,
+			EventTime: geolocation.EventTime,
+		}
+		plan.results[i] = result
+
+		key := keyOf(geolocation)
+		current, ok := pending[key]
+		if !ok {
+			current = stored(geolocation)
+		}
+		switch {
+		case current == nil:
+			copied := *geolocation
+			copied.Telemetry = geolocation.Telemetry.Copy()
+			pending[key] = &copied
+			plan.inserts = append(plan.inserts, &copied)
+			result.Status = IngestInserted
+		case sameGeolocation(current, geolocation):
+			result.Status = IngestDuplicate
+		case policy == KeepFirst:
+			result.Status = IngestKeptExisting
+		case policy == LastWriteWins:
+			if ok {
+				// already planned, so change what will be written instead of writing twice
+				current.Latitude = geolocation.Latitude
+				current.Longitude = geolocation.Longitude
+				current.Telemetry = geolocation.Telemetry.Copy()
+			} else {
+				copied := *geolocation
+				copied.Telemetry = geolocation.Telemetry.Copy()
+				pending[key] = &copied
+				plan.updates = append(plan.updates, &copied)
+			}
+			result.Status = IngestUpdated
+		default:
+			result.Status = IngestRejected
+			result.Error = fmt.Sprintf("conflicts with the existing geolocation at %v, %v", current.Latitude, current.Longitude)
+		}
+	}
+	return plan
+}
+
+// NotificationFor is what listeners hear about an inserted or updated geolocation, without the bookkeeping timestamps
+func NotificationFor(geolocation *DeviceGeolocation) *DeviceGeolocation {
+	return &DeviceGeolocation{
+		DeviceID:  geolocation.DeviceID,
+		EventTime: geolocation.EventTime,
+		Latitude:  geolocation.Latitude,
+		Longitude: geolocation.Longitude,
+		Telemetry: geolocation.Telemetry.Copy(),
+	}
+}
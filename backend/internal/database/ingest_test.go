@@ -0,0 +1,121 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func float(value float64) *float64 {
+	return &value
+}
+
+func TestPlanIngest(t *testing.T) {
+	deviceID := "6f1c2b9e-3f4a-4c1d-9a7b-2e5d8c0f1a23"
+	eventTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(latitude float64, offset time.Duration) *DeviceGeolocation {
+		return &DeviceGeolocation{DeviceID: deviceID, EventTime: eventTime.Add(offset), Latitude: latitude, Longitude: 2}
+	}
+	stored := at(1, 0)
+
+	tests := []struct {
+		name         string
+		policy       DuplicatePolicy
+		geolocations []*DeviceGeolocation
+		want         []IngestStatus
+		inserts      int
+		updates      int
+		// the latitude planned for the stored event time, when it's updated
+		updatedLatitude float64
+	}{
+		{
+			name:         "new points are inserted",
+			policy:       RejectDuplicates,
+			geolocations: []*DeviceGeolocation{at(1, time.Second), at(1, 2*time.Second)},
+			want:         []IngestStatus{IngestInserted, IngestInserted},
+			inserts:      2,
+		},
+		{
+			name:         "the same point again is a duplicate under every policy",
+			policy:       RejectDuplicates,
+			geolocations: []*DeviceGeolocation{at(1, 0)},
+			want:         []IngestStatus{IngestDuplicate},
+		},
+		{
+			name:         "a different point is rejected",
+			policy:       RejectDuplicates,
+			geolocations: []*DeviceGeolocation{at(5, 0)},
+			want:         []IngestStatus{IngestRejected},
+		},
+		{
+			name:         "keep first keeps what is stored",
+			policy:       KeepFirst,
+			geolocations: []*DeviceGeolocation{at(5, 0)},
+			want:         []IngestStatus{IngestKeptExisting},
+		},
+		{
+			name:            "last write wins replaces what is stored",
+			policy:          LastWriteWins,
+			geolocations:    []*DeviceGeolocation{at(5, 0)},
+			want:            []IngestStatus{IngestUpdated},
+			updates:         1,
+			updatedLatitude: 5,
+		},
+		{
+			name:            "last write wins within a batch only writes once",
+			policy:          LastWriteWins,
+			geolocations:    []*DeviceGeolocation{at(5, 0), at(6, 0)},
+			want:            []IngestStatus{IngestUpdated, IngestUpdated},
+			updates:         1,
+			updatedLatitude: 6,
+		},
+		{
+			name:         "duplicates within a batch are found before they're stored",
+			policy:       RejectDuplicates,
+			geolocations: []*DeviceGeolocation{at(1, time.Second), at(1, time.Second), at(5, time.Second)},
+			want:         []IngestStatus{IngestInserted, IngestDuplicate, IngestRejected},
+			inserts:      1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plan := planIngest(test.geolocations, test.policy, func(geolocation *DeviceGeolocation) *DeviceGeolocation {
+				if geolocation.EventTime.Equal(stored.EventTime) {
+					return stored
+				}
+				return nil
+			})
+			if len(plan.results) != len(test.want) {
+				t.Fatalf("got %d results, want %d", len(plan.results), len(test.want))
+			}
+			for i, result := range plan.results {
+				if result.Status != test.want[i] {
+					t.Errorf("result %d: got %v, want %v", i, result.Status, test.want[i])
+				}
+			}
+			if len(plan.inserts) != test.inserts {
+				t.Errorf("got %d inserts, want %d", len(plan.inserts), test.inserts)
+			}
+			if len(plan.updates) != test.updates {
+				t.Fatalf("got %d updates, want %d", len(plan.updates), test.updates)
+			}
+			if test.updates > 0 && plan.updates[0].Latitude != test.updatedLatitude {
+				t.Errorf("got updated latitude %v, want %v", plan.updates[0].Latitude, test.updatedLatitude)
+			}
+			if stored.Latitude != 1 {
+				t.Errorf("planning changed the stored geolocation")
+			}
+		})
+	}
+}
+
+func TestPlanIngestComparesTelemetry(t *testing.T) {
+	eventTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	stored := &DeviceGeolocation{DeviceID: "a", EventTime: eventTime, Telemetry: Telemetry{Heading: float(90)}}
+	incoming := &DeviceGeolocation{DeviceID: "a", EventTime: eventTime, Telemetry: Telemetry{Heading: float(180)}}
+	plan := planIngest([]*DeviceGeolocation{incoming}, RejectDuplicates, func(*DeviceGeolocation) *DeviceGeolocation {
+		return stored
+	})
+	if plan.results[0].Status != IngestRejected {
+		t.Errorf("got %v, want %v", plan.results[0].Status, IngestRejected)
+	}
+}
@@ -2,17 +2,53 @@ package database
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/NinjaPerson24119/MapProject/backend/internal/filters"
 )
 
+var ErrNotFound = errors.New("not found")
+
+// Repo methods that take an orgID only see and change that org's devices and their geolocations.
+// a device in another org is reported the same way as one that doesn't exist.
+// retention and partition maintenance apply to every org
 type Repo interface {
 	Close()
-	InsertDevice(ctx context.Context, device *Device) (string, error)
-	ListDevices(ctx context.Context, paging filters.PageOptions) ([]*Device, error)
-	InsertGeolocation(ctx context.Context, geolocation *DeviceGeolocation) error
-	InsertMultiGeolocation(ctx context.Context, geolocations []*DeviceGeolocation) error
-	ListLatestGeolocations(ctx context.Context, paging filters.PageOptions) ([]*DeviceGeolocation, error)
-	GetMultiLatestGeolocations(ctx context.Context, deviceIDs []string) ([]*DeviceGeolocation, error)
-	ListenToGeolocationInserted(ctx context.Context, handler func(string) error) error
+	InsertDevice(ctx context.Context, orgID string, device *Device) (string, error)
+	ListDevices(ctx context.Context, orgID string, paging filters.PageOptions, filter filters.DeviceFilter) ([]*Device, string, error)
+	GetDevice(ctx context.Context, orgID string, deviceID string) (*Device, error)
+	// GetMultiDevices returns devices in the same order as the ids, with nil for any that aren't found
+	GetMultiDevices(ctx context.Context, orgID string, deviceIDs []string) ([]*Device, error)
+	// UpdateDevice changes the fields that are set, and notifies listeners since streams may filter on them
+	UpdateDevice(ctx context.Context, orgID string, update *DeviceUpdate) (*Device, error)
+	DeleteDevice(ctx context.Context, orgID string, deviceID string) (*Device, error)
+	RestoreDevice(ctx context.Context, orgID string, deviceID string) (*Device, error)
+	InsertGeolocation(ctx context.Context, orgID string, geolocation *DeviceGeolocation) error
+	// InsertMultiGeolocation keeps what is already stored when a device and event time are inserted again, instead of failing
+	InsertMultiGeolocation(ctx context.Context, orgID string, geolocations []*DeviceGeolocation) error
+	CopyMultiGeolocation(ctx context.Context, orgID string, geolocations []*DeviceGeolocation) (*IngestStats, error)
+	// IngestMultiGeolocation is InsertMultiGeolocation, except duplicates are handled by the policy instead of failing the batch
+	IngestMultiGeolocation(ctx context.Context, orgID string, geolocations []*DeviceGeolocation, policy DuplicatePolicy) ([]*IngestResult, error)
+	ListLatestGeolocations(ctx context.Context, orgID string, paging filters.PageOptions, spatial filters.SpatialFilter, device filters.DeviceFilter) ([]*DeviceGeolocation, string, error)
+	GetMultiLatestGeolocations(ctx context.Context, orgID string, deviceIDs []string) ([]*DeviceGeolocation, error)
+	// GetMultiGeolocationsAt returns where each device was at a time, which is its last geolocation at or before it.
+	// it's in the same order as the ids, with nil for devices that hadn't reported yet
+	GetMultiGeolocationsAt(ctx context.Context, orgID string, deviceIDs []string, at time.Time) ([]*DeviceGeolocation, error)
+	ListGeolocationHistory(ctx context.Context, orgID string, deviceID string, history filters.HistoryOptions, paging filters.PageOptions) ([]*DeviceGeolocation, string, error)
+	ListenToGeolocationInserted(ctx context.Context, orgID string, handler func(*DeviceGeolocation) error, resync func() error) error
+	ListenerStats() ListenerStats
+	CompactGeolocations(ctx context.Context, from time.Time, to time.Time, resolution time.Duration) (int64, error)
+	DeleteGeolocationsBefore(ctx context.Context, before time.Time) (int64, error)
+	EnsureGeolocationPartitions(ctx context.Context, from time.Time, to time.Time) (int, error)
+
+	// admin operations across orgs
+	InsertOrganization(ctx context.Context, org *Organization) (string, error)
+	ListOrganizations(ctx context.Context) ([]*Organization, error)
+	// SetOrganizationAPIKey replaces the org's api key with the one that hashes to keyHash
+	SetOrganizationAPIKey(ctx context.Context, orgID string, keyHash []byte) error
+	// GetOrganizationByAPIKey finds the org whose api key hashes to keyHash, for authenticating requests
+	GetOrganizationByAPIKey(ctx context.Context, keyHash []byte) (*Organization, error)
+	// MoveDevice gives a device, and its history, to another org. streams of both orgs hear about it
+	MoveDevice(ctx context.Context, deviceID string, orgID string) (*Device, error)
 }
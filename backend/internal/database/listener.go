@@ -0,0 +1,198 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// backoff between attempts to get the LISTEN connection back
+const (
+	minReconnectDelay = 100 * time.Millisecond
+	maxReconnectDelay = 30 * time.Second
+)
+
+// pgListener holds the one LISTEN connection for the whole process and multicasts its notifications through a hub.
+// if the connection drops, it reconnects with backoff and tells subscribers to resync, since notifications sent in between are lost
+type pgListener struct {
+	connectionURL string
+	channel       string
+	hub           *NotificationHub
+	reconnects    atomic.Int64
+
+	mu      sync.Mutex
+	running bool
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func newPGListener(connectionURL string, channel string) *pgListener {
+	return &pgListener{
+		connectionURL: connectionURL,
+		channel:       channel,
+		hub:           NewNotificationHub(),
+	}
+}
+
+func (l *pgListener) connect(ctx context.Context) (*pgx.Conn, error) {
+	// connect directly without pool to avoid competing with other connections
+	conn, err := pgx.Connect(ctx, l.connectionURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection: %v", err)
+	}
+	_, err = conn.Exec(ctx, fmt.Sprintf("LISTEN %s;", l.channel))
+	if err != nil {
+		conn.Close(ctx)
+		return nil, fmt.Errorf("failed to listen: %v", err)
+	}
+	return conn, nil
+}
+
+// ensureRunning opens the LISTEN connection if it isn't open yet
+func (l *pgListener) ensureRunning(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.running {
+		return nil
+	}
+
+	conn, err := l.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	// the connection outlives the request that happened to open it
+	listenCtx, cancel := context.WithCancel(context.Background())
+	l.running = true
+	l.cancel = cancel
+	l.done = make(chan struct{})
+	go l.receive(listenCtx, conn, l.done)
+	fmt.Printf("listening to %s\n", l.channel)
+	return nil
+}
+
+func (l *pgListener) receive(ctx context.Context, conn *pgx.Conn, done chan struct{}) {
+	defer close(done)
+
+	for conn != nil {
+		err := l.forward(ctx, conn)
+		conn.Close(context.Background())
+		if ctx.Err() != nil {
+			break
+		}
+		fmt.Printf("lost connection to %s, reconnecting: %v\n", l.channel, err)
+
+		conn = l.reconnect(ctx)
+		if conn != nil {
+			l.reconnects.Add(1)
+			fmt.Printf("listening to %s again\n", l.channel)
+			l.hub.Resync()
+		}
+	}
+
+	// subscribers find out through their closed channel, and the next subscriber reconnects
+	l.mu.Lock()
+	l.running = false
+	l.hub.CloseAll()
+	l.mu.Unlock()
+}
+
+// forward publishes notifications until the connection fails or the context is done
+func (l *pgListener) forward(ctx context.Context, conn *pgx.Conn) error {
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		orgID, geolocation, err := decodeNotification(notification.Payload)
+		if err != nil {
+			fmt.Printf("failed to decode notification: %v\n", err)
+			continue
+		}
+		if orgID == "" {
+			// sent by a trigger from before organizations, which may still be running mid deploy.
+			// every org hears about the device, but can only look it up if it's theirs
+			l.hub.PublishToAll(&DeviceGeolocation{DeviceID: geolocation.DeviceID})
+			continue
+		}
+		l.hub.Publish(orgID, geolocation)
+	}
+}
+
+// reconnect retries until it has a connection, or returns nil once the context is done
+func (l *pgListener) reconnect(ctx context.Context) *pgx.Conn {
+	delay := minReconnectDelay
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+		conn, err := l.connect(ctx)
+		if err == nil {
+			return conn
+		}
+		fmt.Printf("failed to reconnect to %s, retrying in %v: %v\n", l.channel, delay, err)
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+// notificationPayload is the JSON row sent by the insert trigger, plus the device's org
+type notificationPayload struct {
+	OrgID string `json:"org_id"`
+	DeviceGeolocation
+}
+
+// decodeNotification reads the JSON row sent by the insert trigger.
+// device updates only send device_id and org_id. older triggers, which may still be running mid deploy, leave out org_id,
+// and before that sent only the device id
+func decodeNotification(payload string) (string, *DeviceGeolocation, error) {
+	if !strings.HasPrefix(payload, "{") {
+		return "", &DeviceGeolocation{DeviceID: payload}, nil
+	}
+	decoded := &notificationPayload{}
+	err := json.Unmarshal([]byte(payload), decoded)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid payload %q: %v", payload, err)
+	}
+	if decoded.DeviceID == "" {
+		return "", nil, fmt.Errorf("invalid payload %q: missing device_id", payload)
+	}
+	return decoded.OrgID, &decoded.DeviceGeolocation, nil
+}
+
+func (l *pgListener) listen(ctx context.Context, orgID string, handler func(*DeviceGeolocation) error, resync func() error) error {
+	// subscribe before checking the connection, so a connection that drops in between closes us instead of leaving us waiting forever
+	id, subscriber := l.hub.subscribe(orgID)
+	defer l.hub.unsubscribe(id)
+
+	err := l.ensureRunning(ctx)
+	if err != nil {
+		return err
+	}
+	return deliver(ctx, subscriber, handler, resync)
+}
+
+func (l *pgListener) stats() ListenerStats {
+	stats := l.hub.Stats()
+	stats.Reconnects = l.reconnects.Load()
+	return stats
+}
+
+func (l *pgListener) close() {
+	l.mu.Lock()
+	cancel := l.cancel
+	done := l.done
+	l.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
@@ -0,0 +1,684 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/filters"
+)
+
+// MemoryRepo is a Repo that keeps everything in process memory.
+// it mirrors the postgres schema closely enough (foreign keys, primary keys, check constraints, notify trigger)
+// that the simulator and websocket behave the same way without a database
+type MemoryRepo struct {
+	mu   sync.RWMutex
+	orgs map[string]*Organization
+	// org ids by the hash of their api key
+	apiKeys map[string]string
+	devices map[string]*Device
+	// history per device, sorted by event_time ascending
+	geolocations map[string][]*DeviceGeolocation
+	// like device.latest_geolocation, this outlives history that retention removes
+	latest map[string]*DeviceGeolocation
+
+	hub *NotificationHub
+}
+
+func NewMemory() *MemoryRepo {
+	return &MemoryRepo{
+		// like the migration, there's always a default org
+		orgs: map[string]*Organization{
+			DefaultOrgID: {OrgID: DefaultOrgID, Name: "default", Created: time.Now()},
+		},
+		apiKeys:      map[string]string{},
+		devices:      map[string]*Device{},
+		geolocations: map[string][]*DeviceGeolocation{},
+		latest:       map[string]*DeviceGeolocation{},
+		hub:          NewNotificationHub(),
+	}
+}
+
+func (s *MemoryRepo) Close() {
+	s.hub.CloseAll()
+}
+
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	// version 4, variant 10
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// orgDevice returns the device if it's in the org. caller must hold the lock
+func (s *MemoryRepo) orgDevice(orgID string, deviceID string) (*Device, bool) {
+	device, ok := s.devices[deviceID]
+	if !ok || device.OrgID != orgID {
+		return nil, false
+	}
+	return device, true
+}
+
+func (s *MemoryRepo) InsertDevice(ctx context.Context, orgID string, device *Device) (string, error) {
+	id, err := newUUID()
+	if err != nil {
+		return "", fmt.Errorf("failed to insert device: %v", err)
+	}
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.orgs[orgID]; !ok {
+		return "", fmt.Errorf("org %v: %w", orgID, ErrNotFound)
+	}
+	s.devices[id] = &Device{
+		DeviceID:   id,
+		OrgID:      orgID,
+		Name:       device.Name,
+		DeviceType: device.DeviceType,
+		Model:      device.Model,
+		Tags:       append([]string{}, device.Tags...),
+		Attributes: copyAttributes(device.Attributes),
+		Created:    now,
+		Updated:    &now,
+	}
+	return id, nil
+}
+
+func (s *MemoryRepo) ListDevices(ctx context.Context, orgID string, paging filters.PageOptions, filter filters.DeviceFilter) ([]*Device, string, error) {
+	if err := paging.Validate(); err != nil {
+		return nil, "", fmt.Errorf("repo: %v", err)
+	}
+	if err := filter.Validate(); err != nil {
+		return nil, "", fmt.Errorf("repo: %v", err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	devices := []*Device{}
+	for _, device := range s.devices {
+		if device.OrgID != orgID || device.Deleted != nil || !device.Matches(filter) {
+			continue
+		}
+		devices = append(devices, device.Copy())
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].DeviceID > devices[j].DeviceID
+	})
+	page, nextCursor, err := paginate(devices, paging, func(device *Device) string {
+		return device.DeviceID
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return page, nextCursor, nil
+}
+
+func (s *MemoryRepo) GetDevice(ctx context.Context, orgID string, deviceID string) (*Device, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	device, ok := s.orgDevice(orgID, deviceID)
+	if !ok {
+		return nil, fmt.Errorf("device %v: %w", deviceID, ErrNotFound)
+	}
+	return device.Copy(), nil
+}
+
+func (s *MemoryRepo) GetMultiDevices(ctx context.Context, orgID string, deviceIDs []string) ([]*Device, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	devices := make([]*Device, len(deviceIDs))
+	for i, deviceID := range deviceIDs {
+		if device, ok := s.orgDevice(orgID, deviceID); ok {
+			devices[i] = device.Copy()
+		}
+	}
+	return devices, nil
+}
+
+func (s *MemoryRepo) UpdateDevice(ctx context.Context, orgID string, update *DeviceUpdate) (*Device, error) {
+	s.mu.Lock()
+	existing, ok := s.orgDevice(orgID, update.DeviceID)
+	if !ok || existing.Deleted != nil {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("device %v: %w", update.DeviceID, ErrNotFound)
+	}
+	update.apply(existing, time.Now())
+	copied := existing.Copy()
+	s.mu.Unlock()
+
+	// streams filtering on what changed pick up or drop the device
+	s.hub.Publish(orgID, &DeviceGeolocation{DeviceID: update.DeviceID})
+	return copied, nil
+}
+
+func (s *MemoryRepo) setDeviceDeleted(orgID string, deviceID string, deleted bool) (*Device, error) {
+	s.mu.Lock()
+	existing, ok := s.orgDevice(orgID, deviceID)
+	if !ok {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("device %v: %w", deviceID, ErrNotFound)
+	}
+	now := time.Now()
+	if !deleted {
+		existing.Deleted = nil
+	} else if existing.Deleted == nil {
+		existing.Deleted = &now
+	}
+	existing.Updated = &now
+	copied := existing.Copy()
+	s.mu.Unlock()
+
+	// streams drop or pick up the device, like the notification the postgres repo sends
+	s.hub.Publish(orgID, &DeviceGeolocation{DeviceID: deviceID})
+	return copied, nil
+}
+
+func (s *MemoryRepo) DeleteDevice(ctx context.Context, orgID string, deviceID string) (*Device, error) {
+	return s.setDeviceDeleted(orgID, deviceID, true)
+}
+
+func (s *MemoryRepo) RestoreDevice(ctx context.Context, orgID string, deviceID string) (*Device, error) {
+	return s.setDeviceDeleted(orgID, deviceID, false)
+}
+
+func (s *MemoryRepo) InsertOrganization(ctx context.Context, org *Organization) (string, error) {
+	id, err := newUUID()
+	if err != nil {
+		return "", fmt.Errorf("failed to insert org: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.orgs[id] = &Organization{
+		OrgID:   id,
+		Name:    org.Name,
+		Created: time.Now(),
+	}
+	return id, nil
+}
+
+func (s *MemoryRepo) ListOrganizations(ctx context.Context) ([]*Organization, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	orgs := make([]*Organization, 0, len(s.orgs))
+	for _, org := range s.orgs {
+		copied := *org
+		orgs = append(orgs, &copied)
+	}
+	sortOrganizations(orgs)
+	return orgs, nil
+}
+
+func (s *MemoryRepo) SetOrganizationAPIKey(ctx context.Context, orgID string, keyHash []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.orgs[orgID]; !ok {
+		return fmt.Errorf("org %v: %w", orgID, ErrNotFound)
+	}
+	// an org has one key, so the old one stops working
+	for hash, keyOrgID := range s.apiKeys {
+		if keyOrgID == orgID {
+			delete(s.apiKeys, hash)
+		}
+	}
+	s.apiKeys[string(keyHash)] = orgID
+	return nil
+}
+
+func (s *MemoryRepo) GetOrganizationByAPIKey(ctx context.Context, keyHash []byte) (*Organization, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	org, ok := s.orgs[s.apiKeys[string(keyHash)]]
+	if !ok {
+		return nil, fmt.Errorf("api key: %w", ErrNotFound)
+	}
+	copied := *org
+	return &copied, nil
+}
+
+// sortOrganizations orders orgs by name, like the postgres query
+func sortOrganizations(orgs []*Organization) {
+	sort.Slice(orgs, func(i, j int) bool {
+		if orgs[i].Name != orgs[j].Name {
+			return orgs[i].Name < orgs[j].Name
+		}
+		return orgs[i].OrgID < orgs[j].OrgID
+	})
+}
+
+func (s *MemoryRepo) MoveDevice(ctx context.Context, deviceID string, orgID string) (*Device, error) {
+	s.mu.Lock()
+	if _, ok := s.orgs[orgID]; !ok {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("org %v: %w", orgID, ErrNotFound)
+	}
+	existing, ok := s.devices[deviceID]
+	if !ok {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("device %v: %w", deviceID, ErrNotFound)
+	}
+	from := existing.OrgID
+	now := time.Now()
+	existing.OrgID = orgID
+	existing.Updated = &now
+	copied := existing.Copy()
+	s.mu.Unlock()
+
+	// the old org's streams drop the device, and the new org's pick it up
+	s.hub.Publish(from, &DeviceGeolocation{DeviceID: deviceID})
+	s.hub.Publish(orgID, &DeviceGeolocation{DeviceID: deviceID})
+	return copied, nil
+}
+
+// paginate pages through items sorted descending by key, the same way the postgres queries do
+func paginate[T any](items []T, paging filters.PageOptions, key func(T) string) ([]T, string, error) {
+	offset := paging.Offset()
+	after, err := filters.DecodeDeviceCursor(paging.Cursor)
+	if err != nil {
+		return nil, "", fmt.Errorf("repo: %v", err)
+	}
+	if after != nil {
+		offset = sort.Search(len(items), func(i int) bool {
+			return key(items[i]) < *after
+		})
+	}
+	if offset >= len(items) {
+		return []T{}, "", nil
+	}
+	end := offset + paging.PageSize
+	if end > len(items) {
+		end = len(items)
+	}
+	page := items[offset:end]
+	return page, paging.NextCursor(len(page), key(page[len(page)-1])), nil
+}
+
+func ValidateGeolocation(geolocation *DeviceGeolocation) error {
+	if geolocation.Latitude < -90 || geolocation.Latitude > 90 {
+		return fmt.Errorf("latitude out of range: %v", geolocation.Latitude)
+	}
+	if geolocation.Longitude < -180 || geolocation.Longitude > 180 {
+		return fmt.Errorf("longitude out of range: %v", geolocation.Longitude)
+	}
+	return geolocation.Telemetry.Validate()
+}
+
+// findGeolocation returns the index where a geolocation with the given event time is, or should be inserted
+func findGeolocation(history []*DeviceGeolocation, eventTime time.Time) (int, bool) {
+	i := sort.Search(len(history), func(i int) bool {
+		return !history[i].EventTime.Before(eventTime)
+	})
+	return i, i < len(history) && history[i].EventTime.Equal(eventTime)
+}
+
+// checkGeolocations enforces the same constraints as device.geolocation, other than its primary key. caller must hold the lock
+func (s *MemoryRepo) checkGeolocations(orgID string, geolocations []*DeviceGeolocation) error {
+	for _, geolocation := range geolocations {
+		if _, ok := s.orgDevice(orgID, geolocation.DeviceID); !ok {
+			return fmt.Errorf("device %v: %w", geolocation.DeviceID, ErrNotFound)
+		}
+		if err := ValidateGeolocation(geolocation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withoutStored drops geolocations whose device and event time are already stored, or earlier in the batch,
+// like ON CONFLICT DO NOTHING. caller must hold the lock
+func (s *MemoryRepo) withoutStored(geolocations []*DeviceGeolocation) []*DeviceGeolocation {
+	fresh := make([]*DeviceGeolocation, 0, len(geolocations))
+	seen := map[geolocationKey]bool{}
+	for _, geolocation := range geolocations {
+		key := keyOf(geolocation)
+		if _, found := findGeolocation(s.geolocations[geolocation.DeviceID], geolocation.EventTime); found || seen[key] {
+			continue
+		}
+		seen[key] = true
+		fresh = append(fresh, geolocation)
+	}
+	return fresh
+}
+
+// storeGeolocations assumes the geolocations were checked. caller must hold the lock
+func (s *MemoryRepo) storeGeolocations(geolocations []*DeviceGeolocation) {
+	now := time.Now()
+	for _, geolocation := range geolocations {
+		copied := *geolocation
+		copied.Telemetry = geolocation.Telemetry.Copy()
+		copied.Created = now
+		copied.Updated = &now
+		copied.Deleted = nil
+
+		history := s.geolocations[copied.DeviceID]
+		i, _ := findGeolocation(history, copied.EventTime)
+		history = append(history, nil)
+		copy(history[i+1:], history[i:])
+		history[i] = &copied
+		s.geolocations[copied.DeviceID] = history
+
+		if latest, ok := s.latest[copied.DeviceID]; !ok || copied.EventTime.After(latest.EventTime) {
+			s.latest[copied.DeviceID] = &copied
+		}
+	}
+}
+
+func (s *MemoryRepo) InsertGeolocation(ctx context.Context, orgID string, geolocation *DeviceGeolocation) error {
+	err := s.InsertMultiGeolocation(ctx, orgID, []*DeviceGeolocation{geolocation})
+	if err != nil {
+		return fmt.Errorf("failed to insert geolocation: %w", err)
+	}
+	return nil
+}
+
+func (s *MemoryRepo) InsertMultiGeolocation(ctx context.Context, orgID string, geolocations []*DeviceGeolocation) error {
+	_, err := s.insertMulti(orgID, geolocations)
+	return err
+}
+
+// insertMulti skips geolocations that are already stored, and returns how many it inserted
+func (s *MemoryRepo) insertMulti(orgID string, geolocations []*DeviceGeolocation) (int, error) {
+	// all or nothing, like the transaction in the postgres implementation
+	s.mu.Lock()
+	err := s.checkGeolocations(orgID, geolocations)
+	if err != nil {
+		s.mu.Unlock()
+		return 0, fmt.Errorf("failed to insert multi geolocation: %w", err)
+	}
+	fresh := s.withoutStored(geolocations)
+	s.storeGeolocations(fresh)
+	// like the insert trigger, deleted devices don't notify
+	notifications := make([]*DeviceGeolocation, 0, len(fresh))
+	for _, geolocation := range fresh {
+		if s.devices[geolocation.DeviceID].Deleted != nil {
+			continue
+		}
+		notifications = append(notifications, NotificationFor(geolocation))
+	}
+	s.mu.Unlock()
+
+	for _, notification := range notifications {
+		s.hub.Publish(orgID, notification)
+	}
+	return len(fresh), nil
+}
+
+func (s *MemoryRepo) IngestMultiGeolocation(ctx context.Context, orgID string, geolocations []*DeviceGeolocation, policy DuplicatePolicy) ([]*IngestResult, error) {
+	if _, err := ParseDuplicatePolicy(string(policy)); err != nil {
+		return nil, fmt.Errorf("repo: %v", err)
+	}
+
+	s.mu.Lock()
+	// anything other than a duplicate still fails the whole batch, like the constraints in the postgres implementation
+	for _, geolocation := range geolocations {
+		if _, ok := s.orgDevice(orgID, geolocation.DeviceID); !ok {
+			s.mu.Unlock()
+			return nil, fmt.Errorf("failed to ingest multi geolocation: device %v: %w", geolocation.DeviceID, ErrNotFound)
+		}
+		if err := ValidateGeolocation(geolocation); err != nil {
+			s.mu.Unlock()
+			return nil, fmt.Errorf("failed to ingest multi geolocation: %v", err)
+		}
+	}
+	plan := planIngest(geolocations, policy, func(geolocation *DeviceGeolocation) *DeviceGeolocation {
+		history := s.geolocations[geolocation.DeviceID]
+		if i, found := findGeolocation(history, geolocation.EventTime); found {
+			return history[i]
+		}
+		return nil
+	})
+	s.storeGeolocations(plan.inserts)
+	now := time.Now()
+	for _, update := range plan.updates {
+		// latest shares the pointer when this is the device's latest geolocation, so it changes too
+		history := s.geolocations[update.DeviceID]
+		i, _ := findGeolocation(history, update.EventTime)
+		history[i].Latitude = update.Latitude
+		history[i].Longitude = update.Longitude
+		history[i].Telemetry = update.Telemetry.Copy()
+		history[i].Updated = &now
+	}
+	notifications := []*DeviceGeolocation{}
+	for _, geolocation := range append(plan.inserts, plan.updates...) {
+		if s.devices[geolocation.DeviceID].Deleted == nil {
+			notifications = append(notifications, NotificationFor(geolocation))
+		}
+	}
+	s.mu.Unlock()
+
+	for _, notification := range notifications {
+		s.hub.Publish(orgID, notification)
+	}
+	return plan.results, nil
+}
+
+func (s *MemoryRepo) CopyMultiGeolocation(ctx context.Context, orgID string, geolocations []*DeviceGeolocation) (*IngestStats, error) {
+	before := time.Now()
+	inserted, err := s.insertMulti(orgID, geolocations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy multi geolocation: %w", err)
+	}
+	return &IngestStats{
+		Rows:       inserted,
+		Duplicates: len(geolocations) - inserted,
+		Duration:   time.Since(before),
+	}, nil
+}
+
+// latestGeolocations returns the latest geolocation of every active device in the org sorted by device_id descending. caller must hold the lock
+func (s *MemoryRepo) latestGeolocations(orgID string) []*DeviceGeolocation {
+	geolocations := []*DeviceGeolocation{}
+	for deviceID, latest := range s.latest {
+		if device, ok := s.orgDevice(orgID, deviceID); !ok || device.Deleted != nil {
+			continue
+		}
+		copied := *latest
+		geolocations = append(geolocations, &copied)
+	}
+	sort.Slice(geolocations, func(i, j int) bool {
+		return geolocations[i].DeviceID > geolocations[j].DeviceID
+	})
+	return geolocations
+}
+
+func (s *MemoryRepo) ListLatestGeolocations(ctx context.Context, orgID string, paging filters.PageOptions, spatial filters.SpatialFilter, device filters.DeviceFilter) ([]*DeviceGeolocation, string, error) {
+	if err := paging.Validate(); err != nil {
+		return nil, "", fmt.Errorf("repo: %v", err)
+	}
+	if err := spatial.Validate(); err != nil {
+		return nil, "", fmt.Errorf("repo: %v", err)
+	}
+	if err := device.Validate(); err != nil {
+		return nil, "", fmt.Errorf("repo: %v", err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	geolocations := []*DeviceGeolocation{}
+	for _, geolocation := range s.latestGeolocations(orgID) {
+		if spatial.Contains(geolocation.Latitude, geolocation.Longitude) && s.devices[geolocation.DeviceID].Matches(device) {
+			geolocations = append(geolocations, geolocation)
+		}
+	}
+	page, nextCursor, err := paginate(geolocations, paging, func(geolocation *DeviceGeolocation) string {
+		return geolocation.DeviceID
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return page, nextCursor, nil
+}
+
+func (s *MemoryRepo) GetMultiLatestGeolocations(ctx context.Context, orgID string, deviceIDs []string) ([]*DeviceGeolocation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// get multi returns the same order as the input. if a device is not found, it will be nil
+	ptrs := make([]*DeviceGeolocation, len(deviceIDs))
+	for i, deviceID := range deviceIDs {
+		latest, ok := s.latest[deviceID]
+		if !ok {
+			continue
+		}
+		if device, ok := s.orgDevice(orgID, deviceID); !ok || device.Deleted != nil {
+			continue
+		}
+		copied := *latest
+		ptrs[i] = &copied
+	}
+	return ptrs, nil
+}
+
+func (s *MemoryRepo) GetMultiGeolocationsAt(ctx context.Context, orgID string, deviceIDs []string, at time.Time) ([]*DeviceGeolocation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ptrs := make([]*DeviceGeolocation, len(deviceIDs))
+	for i, deviceID := range deviceIDs {
+		if _, ok := s.orgDevice(orgID, deviceID); !ok {
+			continue
+		}
+		history := s.geolocations[deviceID]
+		// the first geolocation after at
+		after, found := findGeolocation(history, at)
+		if found {
+			after++
+		}
+		if after == 0 {
+			continue
+		}
+		copied := *history[after-1]
+		ptrs[i] = &copied
+	}
+	return ptrs, nil
+}
+
+func (s *MemoryRepo) ListGeolocationHistory(ctx context.Context, orgID string, deviceID string, history filters.HistoryOptions, paging filters.PageOptions) ([]*DeviceGeolocation, string, error) {
+	if err := history.Validate(); err != nil {
+		return nil, "", fmt.Errorf("repo: %v", err)
+	}
+	if err := paging.Validate(); err != nil {
+		return nil, "", fmt.Errorf("repo: %v", err)
+	}
+	after, err := filters.DecodeTimeCursor(paging.Cursor)
+	if err != nil {
+		return nil, "", fmt.Errorf("repo: %v", err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	// another org's device has no history, like one that doesn't exist
+	if _, ok := s.orgDevice(orgID, deviceID); !ok {
+		return []*DeviceGeolocation{}, "", nil
+	}
+	all := s.geolocations[deviceID]
+	start, _ := findGeolocation(all, history.StartTime)
+	end, _ := findGeolocation(all, history.EndTime)
+	ranged := all[start:end]
+	// without subsampling, later pages start where the cursor left off instead of from the start of the range
+	if history.MaxPoints == 0 && after != nil {
+		i, found := findGeolocation(ranged, *after)
+		if found {
+			i++
+		}
+		ranged = ranged[i:]
+		after = nil
+	}
+
+	page, nextCursor := pageHistory(ranged, history, paging, after)
+	return page, nextCursor, nil
+}
+
+// pageHistory subsamples one device's history in the requested range, oldest first, then pages through it like the postgres query does
+func pageHistory(ranged []*DeviceGeolocation, history filters.HistoryOptions, paging filters.PageOptions, after *time.Time) ([]*DeviceGeolocation, string) {
+	step := history.SubsampleStep(len(ranged))
+	sampled := ranged
+	if step > 1 || after != nil {
+		sampled = []*DeviceGeolocation{}
+		for i := 0; i < len(ranged); i += step {
+			if after != nil && !ranged[i].EventTime.After(*after) {
+				continue
+			}
+			sampled = append(sampled, ranged[i])
+		}
+	}
+
+	offset := paging.Offset()
+	if offset >= len(sampled) {
+		return []*DeviceGeolocation{}, ""
+	}
+	last := offset + paging.PageSize
+	if last > len(sampled) {
+		last = len(sampled)
+	}
+	page := make([]*DeviceGeolocation, 0, last-offset)
+	for _, geolocation := range sampled[offset:last] {
+		copied := *geolocation
+		page = append(page, &copied)
+	}
+	nextCursor := ""
+	if len(page) == paging.PageSize {
+		nextCursor = filters.EncodeTimeCursor(page[len(page)-1].EventTime)
+	}
+	return page, nextCursor
+}
+
+func (s *MemoryRepo) CompactGeolocations(ctx context.Context, from time.Time, to time.Time, resolution time.Duration) (int64, error) {
+	if resolution <= 0 {
+		return 0, fmt.Errorf("repo: invalid resolution")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var compacted int64
+	for deviceID, history := range s.geolocations {
+		kept := make([]*DeviceGeolocation, 0, len(history))
+		for i, geolocation := range history {
+			inRange := !geolocation.EventTime.Before(from) && geolocation.EventTime.Before(to)
+			// history is sorted, so the last of each bucket is the one followed by a different bucket
+			lastInBucket := i == len(history)-1 ||
+				history[i+1].EventTime.UnixNano()/int64(resolution) != geolocation.EventTime.UnixNano()/int64(resolution) ||
+				!history[i+1].EventTime.Before(to)
+			if inRange && !lastInBucket {
+				compacted++
+				continue
+			}
+			kept = append(kept, geolocation)
+		}
+		s.geolocations[deviceID] = kept
+	}
+	return compacted, nil
+}
+
+func (s *MemoryRepo) DeleteGeolocationsBefore(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var deleted int64
+	for deviceID, history := range s.geolocations {
+		i, _ := findGeolocation(history, before)
+		deleted += int64(i)
+		s.geolocations[deviceID] = history[i:]
+	}
+	return deleted, nil
+}
+
+// EnsureGeolocationPartitions has nothing to do, since memory isn't partitioned
+func (s *MemoryRepo) EnsureGeolocationPartitions(ctx context.Context, from time.Time, to time.Time) (int, error) {
+	return 0, nil
+}
+
+func (s *MemoryRepo) ListenToGeolocationInserted(ctx context.Context, orgID string, handler func(*DeviceGeolocation) error, resync func() error) error {
+	return s.hub.Listen(ctx, orgID, handler, resync)
+}
+
+func (s *MemoryRepo) ListenerStats() ListenerStats {
+	return s.hub.Stats()
+}
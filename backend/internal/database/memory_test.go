@@ -0,0 +1,397 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/filters"
+)
+
+func insertDevices(t *testing.T, repo Repo, orgID string, n int) []string {
+	t.Helper()
+	ids := []string{}
+	for i := 0; i < n; i++ {
+		id, err := repo.InsertDevice(context.Background(), orgID, &Device{Name: fmt.Sprintf("drone %d", i)})
+		if err != nil {
+			t.Fatalf("failed to insert device: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestMemoryListDevicesPaging(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemory()
+	insertDevices(t, repo, DefaultOrgID, 5)
+
+	tests := []struct {
+		name   string
+		paging filters.PageOptions
+		want   int
+	}{
+		{name: "first page", paging: filters.PageOptions{Page: 1, PageSize: 2}, want: 2},
+		{name: "last page", paging: filters.PageOptions{Page: 3, PageSize: 2}, want: 1},
+		{name: "past the end", paging: filters.PageOptions{Page: 4, PageSize: 2}, want: 0},
+		{name: "everything", paging: filters.PageOptions{Page: 1, PageSize: 10}, want: 5},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			devices, _, err := repo.ListDevices(ctx, DefaultOrgID, test.paging, filters.DeviceFilter{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(devices) != test.want {
+				t.Errorf("got %d devices, want %d", len(devices), test.want)
+			}
+		})
+	}
+}
+
+func TestMemoryNotifications(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	repo := NewMemory()
+	otherOrgID, err := repo.InsertOrganization(ctx, &Organization{Name: "other"})
+	if err != nil {
+		t.Fatalf("failed to insert org: %v", err)
+	}
+	deviceID := insertDevices(t, repo, DefaultOrgID, 1)[0]
+	otherDeviceID := insertDevices(t, repo, otherOrgID, 1)[0]
+
+	received := make(chan *DeviceGeolocation, 10)
+	listening := make(chan error, 1)
+	go func() {
+		listening <- repo.ListenToGeolocationInserted(ctx, DefaultOrgID, func(geolocation *DeviceGeolocation) error {
+			received <- geolocation
+			return nil
+		}, nil)
+	}()
+	// wait until subscribed, so the inserts aren't missed
+	for repo.ListenerStats().Subscribers == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	eventTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := repo.InsertGeolocation(ctx, otherOrgID, &DeviceGeolocation{DeviceID: otherDeviceID, EventTime: eventTime}); err != nil {
+		t.Fatalf("failed to insert geolocation: %v", err)
+	}
+	if err := repo.InsertGeolocation(ctx, DefaultOrgID, &DeviceGeolocation{DeviceID: deviceID, EventTime: eventTime, Latitude: 7}); err != nil {
+		t.Fatalf("failed to insert geolocation: %v", err)
+	}
+	// inserting the same point again isn't news
+	if err := repo.InsertGeolocation(ctx, DefaultOrgID, &DeviceGeolocation{DeviceID: deviceID, EventTime: eventTime, Latitude: 7}); err != nil {
+		t.Fatalf("failed to insert geolocation: %v", err)
+	}
+
+	select {
+	case geolocation := <-received:
+		if geolocation.DeviceID != deviceID || geolocation.Latitude != 7 {
+			t.Errorf("got a notification for %v at %v", geolocation.DeviceID, geolocation.Latitude)
+		}
+	case <-ctx.Done():
+		t.Fatalf("no notification")
+	}
+	select {
+	case geolocation := <-received:
+		t.Errorf("unexpected notification for %v", geolocation.DeviceID)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	if err := <-listening; err == nil {
+		t.Errorf("listen returned without an error after its context was cancelled")
+	}
+}
+
+func TestMemoryListDevicesCursor(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemory()
+	ids := insertDevices(t, repo, DefaultOrgID, 5)
+
+	seen := map[string]bool{}
+	cursor := ""
+	pages := 0
+	for {
+		devices, nextCursor, err := repo.ListDevices(ctx, DefaultOrgID, filters.PageOptions{PageSize: 2, Cursor: cursor}, filters.DeviceFilter{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		pages++
+		for _, device := range devices {
+			if seen[device.DeviceID] {
+				t.Errorf("device %v is on more than one page", device.DeviceID)
+			}
+			seen[device.DeviceID] = true
+		}
+		if nextCursor == "" {
+			break
+		}
+		cursor = nextCursor
+	}
+	if pages != 3 {
+		t.Errorf("got %d pages, want 3", pages)
+	}
+	for _, id := range ids {
+		if !seen[id] {
+			t.Errorf("device %v wasn't listed", id)
+		}
+	}
+}
+
+func TestMemoryRejectsInvalidCursors(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemory()
+	deviceID := insertDevices(t, repo, DefaultOrgID, 1)[0]
+	history := filters.HistoryOptions{
+		StartTime: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndTime:   time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+	}
+
+	for _, cursor := range []string{"not base64!", filters.EncodeCursor("abc")} {
+		paging := filters.PageOptions{PageSize: 10, Cursor: cursor}
+		if _, _, err := repo.ListDevices(ctx, DefaultOrgID, paging, filters.DeviceFilter{}); err == nil {
+			t.Errorf("devices: expected an error for cursor %q", cursor)
+		}
+		if _, _, err := repo.ListLatestGeolocations(ctx, DefaultOrgID, paging, filters.SpatialFilter{}, filters.DeviceFilter{}); err == nil {
+			t.Errorf("latest: expected an error for cursor %q", cursor)
+		}
+		if _, _, err := repo.ListGeolocationHistory(ctx, DefaultOrgID, deviceID, history, paging); err == nil {
+			t.Errorf("history: expected an error for cursor %q", cursor)
+		}
+	}
+}
+
+func TestMemoryHistoryCursor(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemory()
+	deviceID := insertDevices(t, repo, DefaultOrgID, 1)[0]
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	geolocations := []*DeviceGeolocation{}
+	for i := 0; i < 25; i++ {
+		geolocations = append(geolocations, &DeviceGeolocation{DeviceID: deviceID, EventTime: start.Add(time.Duration(i) * time.Second)})
+	}
+	if err := repo.InsertMultiGeolocation(ctx, DefaultOrgID, geolocations); err != nil {
+		t.Fatalf("failed to insert geolocations: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		history filters.HistoryOptions
+		want    int
+	}{
+		{name: "everything", history: filters.HistoryOptions{StartTime: start, EndTime: start.Add(time.Hour)}, want: 25},
+		{name: "end is exclusive", history: filters.HistoryOptions{StartTime: start, EndTime: start.Add(10 * time.Second)}, want: 10},
+		{name: "subsampled", history: filters.HistoryOptions{StartTime: start, EndTime: start.Add(time.Hour), MaxPoints: 5}, want: 5},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			played := []*DeviceGeolocation{}
+			cursor := ""
+			for {
+				page, nextCursor, err := repo.ListGeolocationHistory(ctx, DefaultOrgID, deviceID, test.history, filters.PageOptions{PageSize: 4, Cursor: cursor})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				played = append(played, page...)
+				if nextCursor == "" {
+					break
+				}
+				cursor = nextCursor
+			}
+			if len(played) != test.want {
+				t.Fatalf("got %d points, want %d", len(played), test.want)
+			}
+			for i := 1; i < len(played); i++ {
+				if !played[i].EventTime.After(played[i-1].EventTime) {
+					t.Fatalf("points are out of order at %d", i)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryOrgsAreSeparate(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemory()
+	orgID, err := repo.InsertOrganization(ctx, &Organization{Name: "other"})
+	if err != nil {
+		t.Fatalf("failed to insert org: %v", err)
+	}
+	deviceID := insertDevices(t, repo, orgID, 1)[0]
+
+	if _, err := repo.GetDevice(ctx, DefaultOrgID, deviceID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, want not found", err)
+	}
+	devices, _, err := repo.ListDevices(ctx, DefaultOrgID, filters.PageOptions{PageSize: 10}, filters.DeviceFilter{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(devices) != 0 {
+		t.Errorf("got %d devices from another org", len(devices))
+	}
+}
+
+func TestMemoryGetMultiGeolocationsAt(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemory()
+	ids := insertDevices(t, repo, DefaultOrgID, 2)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	err := repo.InsertMultiGeolocation(ctx, DefaultOrgID, []*DeviceGeolocation{
+		{DeviceID: ids[0], EventTime: start, Latitude: 1},
+		{DeviceID: ids[0], EventTime: start.Add(time.Minute), Latitude: 2},
+		{DeviceID: ids[1], EventTime: start.Add(time.Hour), Latitude: 3},
+	})
+	if err != nil {
+		t.Fatalf("failed to insert geolocations: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		// the latitude of each device, or 0 when it hadn't reported yet
+		want []float64
+	}{
+		{name: "before anything", at: start.Add(-time.Second), want: []float64{0, 0}},
+		{name: "exactly at a point", at: start, want: []float64{1, 0}},
+		{name: "between points", at: start.Add(30 * time.Second), want: []float64{1, 0}},
+		{name: "after everything", at: start.Add(2 * time.Hour), want: []float64{2, 3}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			geolocations, err := repo.GetMultiGeolocationsAt(ctx, DefaultOrgID, ids, test.at)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for i, geolocation := range geolocations {
+				latitude := 0.0
+				if geolocation != nil {
+					latitude = geolocation.Latitude
+				}
+				if latitude != test.want[i] {
+					t.Errorf("device %d: got latitude %v, want %v", i, latitude, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryIngestDoesNotKeepCallersTelemetry(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemory()
+	deviceID := insertDevices(t, repo, DefaultOrgID, 1)[0]
+	eventTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// the first point is inserted and the second replaces it, and neither is changed by the caller afterwards
+	for _, value := range []float64{100, 150} {
+		altitude := value
+		geolocation := &DeviceGeolocation{DeviceID: deviceID, EventTime: eventTime, Telemetry: Telemetry{AltitudeMSL: &altitude}}
+		results, err := repo.IngestMultiGeolocation(ctx, DefaultOrgID, []*DeviceGeolocation{geolocation}, LastWriteWins)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if results[0].Status == IngestDuplicate {
+			t.Fatalf("altitude %v was reported as a duplicate", value)
+		}
+		altitude = 200
+	}
+	stored, err := repo.GetMultiGeolocationsAt(ctx, DefaultOrgID, []string{deviceID}, eventTime)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stored[0] == nil || stored[0].AltitudeMSL == nil || *stored[0].AltitudeMSL != 150 {
+		t.Errorf("got stored telemetry %+v", stored[0])
+	}
+}
+
+func TestMemoryCopyMultiGeolocationCountsInserted(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemory()
+	deviceID := insertDevices(t, repo, DefaultOrgID, 1)[0]
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(seconds int) *DeviceGeolocation {
+		return &DeviceGeolocation{DeviceID: deviceID, EventTime: start.Add(time.Duration(seconds) * time.Second)}
+	}
+
+	// in order, since later batches overlap what earlier ones stored
+	tests := []struct {
+		name           string
+		geolocations   []*DeviceGeolocation
+		wantRows       int
+		wantDuplicates int
+	}{
+		{name: "new points", geolocations: []*DeviceGeolocation{at(0), at(1)}, wantRows: 2},
+		{name: "a stored point doesn't stop the rest", geolocations: []*DeviceGeolocation{at(1), at(2)}, wantRows: 1, wantDuplicates: 1},
+		{name: "the same point twice", geolocations: []*DeviceGeolocation{at(3), at(3)}, wantRows: 1, wantDuplicates: 1},
+		{name: "all stored", geolocations: []*DeviceGeolocation{at(0), at(1), at(2), at(3)}, wantDuplicates: 4},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stats, err := repo.CopyMultiGeolocation(ctx, DefaultOrgID, test.geolocations)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if stats.Rows != test.wantRows || stats.Duplicates != test.wantDuplicates {
+				t.Errorf("got %d rows and %d duplicates, want %d and %d", stats.Rows, stats.Duplicates, test.wantRows, test.wantDuplicates)
+			}
+		})
+	}
+}
+
+func TestMemoryListLatestGeolocationsSpatial(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemory()
+	ids := insertDevices(t, repo, DefaultOrgID, 3)
+	eventTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	err := repo.InsertMultiGeolocation(ctx, DefaultOrgID, []*DeviceGeolocation{
+		// downtown edmonton, st. albert, and fiji by the antimeridian
+		{DeviceID: ids[0], EventTime: eventTime, Latitude: 53.54, Longitude: -113.5},
+		{DeviceID: ids[1], EventTime: eventTime, Latitude: 53.6305, Longitude: -113.6256},
+		{DeviceID: ids[2], EventTime: eventTime, Latitude: -17, Longitude: 179.5},
+	})
+	if err != nil {
+		t.Fatalf("failed to insert geolocations: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		spatial filters.SpatialFilter
+		want    []string
+	}{
+		{name: "no filter", spatial: filters.SpatialFilter{}, want: ids},
+		{name: "circle", spatial: filters.SpatialFilter{Circle: &filters.Circle{Latitude: 53.5461, Longitude: -113.4938, RadiusMeters: 10000}}, want: ids[:1]},
+		{
+			name:    "bounding box",
+			spatial: filters.SpatialFilter{BoundingBox: &filters.BoundingBox{MinLatitude: 53.4, MinLongitude: -113.7, MaxLatitude: 53.7, MaxLongitude: -113.3}},
+			want:    ids[:2],
+		},
+		{
+			name:    "bounding box crossing the antimeridian",
+			spatial: filters.SpatialFilter{BoundingBox: &filters.BoundingBox{MinLatitude: -20, MinLongitude: 170, MaxLatitude: -10, MaxLongitude: -170}},
+			want:    ids[2:],
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			geolocations, _, err := repo.ListLatestGeolocations(ctx, DefaultOrgID, filters.PageOptions{PageSize: 10}, test.spatial, filters.DeviceFilter{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := map[string]bool{}
+			for _, geolocation := range geolocations {
+				got[geolocation.DeviceID] = true
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("got %d devices, want %d", len(got), len(test.want))
+			}
+			for _, id := range test.want {
+				if !got[id] {
+					t.Errorf("device %v is missing", id)
+				}
+			}
+		})
+	}
+}
@@ -4,12 +4,29 @@ import (
 	"time"
 )
 
+// DefaultOrgID owns every device created before organizations existed, and the simulator's
+const DefaultOrgID = "00000000-0000-0000-0000-000000000000"
+
+// Organization is a team with its own fleet. devices, their geolocations and streams are only visible to their org
+type Organization struct {
+	OrgID   string    `json:"org_id" db:"org_id"`
+	Name    string    `json:"name" db:"org_name"`
+	Created time.Time `json:"created" db:"created"`
+}
+
 type Device struct {
-	DeviceID string     `json:"device_id" db:"device_id"`
-	Name     string     `json:"name" db:"device_name"`
-	Created  time.Time  `json:"created" db:"created"`
-	Updated  *time.Time `json:"updated" db:"updated"`
-	Deleted  *time.Time `json:"deleted" db:"deleted"`
+	DeviceID string `json:"device_id" db:"device_id"`
+	OrgID    string `json:"org_id" db:"org_id"`
+	Name     string `json:"name" db:"device_name"`
+	// what kind of device this is, e.g. quadcopter, and its make and model
+	DeviceType string   `json:"device_type" db:"device_type"`
+	Model      string   `json:"model" db:"model"`
+	Tags       []string `json:"tags" db:"tags"`
+	// anything else worth knowing, like serial number, operator or payload
+	Attributes map[string]any `json:"attributes" db:"attributes"`
+	Created    time.Time      `json:"created" db:"created"`
+	Updated    *time.Time     `json:"updated" db:"updated"`
+	Deleted    *time.Time     `json:"deleted" db:"deleted"`
 }
 
 type DeviceGeolocation struct {
@@ -20,4 +37,13 @@ type DeviceGeolocation struct {
 	Created   time.Time  `json:"created" db:"created"`
 	Updated   *time.Time `json:"updated" db:"updated"`
 	Deleted   *time.Time `json:"deleted" db:"deleted"`
+	Telemetry
+}
+
+type IngestStats struct {
+	// rows actually inserted
+	Rows int `json:"rows"`
+	// rows skipped because their device already had a geolocation at that event time
+	Duplicates int           `json:"duplicates"`
+	Duration   time.Duration `json:"duration"`
 }
@@ -0,0 +1,192 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// a subscriber that falls this far behind starts losing notifications instead of blocking everyone else
+const listenerBufferSize = 1024
+
+type ListenerStats struct {
+	Subscribers int   `json:"subscribers"`
+	Delivered   int64 `json:"delivered"`
+	Dropped     int64 `json:"dropped"`
+	// only listeners backed by a database connection reconnect
+	Reconnects int64 `json:"reconnects"`
+}
+
+// NotificationHub multicasts insert notifications from a single source to any number of subscribers, each listening to one org.
+// every subscriber gets the same pointer, so handlers must not modify it.
+// a notification without an event time means the device itself changed, e.g. it was deleted or restored,
+// and whatever the subscriber shows for it should be looked up again.
+// subscribers are told to resync when notifications may have been missed, including when their own buffer was full
+type NotificationHub struct {
+	mu          sync.Mutex
+	subscribers map[int]*subscriber
+	nextID      int
+
+	delivered atomic.Int64
+	dropped   atomic.Int64
+}
+
+type subscriber struct {
+	orgID         string
+	notifications chan *DeviceGeolocation
+	// holds a signal while the subscriber needs to resync. it's separate from the notifications,
+	// so it's never lost when they're full, and stays pending until the subscriber gets to it
+	resync chan struct{}
+}
+
+func (s *subscriber) needsResync() {
+	select {
+	case s.resync <- struct{}{}:
+	default:
+		// already pending
+	}
+}
+
+func NewNotificationHub() *NotificationHub {
+	return &NotificationHub{
+		subscribers: map[int]*subscriber{},
+	}
+}
+
+func (h *NotificationHub) subscribe(orgID string) (int, *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	id := h.nextID
+	h.nextID++
+	s := &subscriber{
+		orgID:         orgID,
+		notifications: make(chan *DeviceGeolocation, listenerBufferSize),
+		resync:        make(chan struct{}, 1),
+	}
+	h.subscribers[id] = s
+	return id, s
+}
+
+func (h *NotificationHub) unsubscribe(id int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if subscriber, ok := h.subscribers[id]; ok {
+		delete(h.subscribers, id)
+		close(subscriber.notifications)
+	}
+}
+
+// CloseAll disconnects every subscriber, which makes their listen calls return
+func (h *NotificationHub) CloseAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, subscriber := range h.subscribers {
+		delete(h.subscribers, id)
+		close(subscriber.notifications)
+	}
+}
+
+// Publish tells subscribers of the device's org
+func (h *NotificationHub) Publish(orgID string, geolocation *DeviceGeolocation) {
+	h.send(func(s *subscriber) bool {
+		return s.orgID == orgID
+	}, geolocation)
+}
+
+// PublishToAll is for notifications that don't say which org they belong to.
+// they should only carry a device id, so subscribers look the device up in their own org, and only find it if it's theirs
+func (h *NotificationHub) PublishToAll(geolocation *DeviceGeolocation) {
+	h.send(func(s *subscriber) bool {
+		return true
+	}, geolocation)
+}
+
+// Resync tells every subscriber that notifications may have been missed
+func (h *NotificationHub) Resync() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, subscriber := range h.subscribers {
+		subscriber.needsResync()
+	}
+}
+
+func (h *NotificationHub) send(to func(s *subscriber) bool, geolocation *DeviceGeolocation) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, subscriber := range h.subscribers {
+		if !to(subscriber) {
+			continue
+		}
+		select {
+		case subscriber.notifications <- geolocation:
+			h.delivered.Add(1)
+		default:
+			// the subscriber is behind, so it can't trust what it has anymore
+			h.dropped.Add(1)
+			subscriber.needsResync()
+		}
+	}
+}
+
+func (h *NotificationHub) Stats() ListenerStats {
+	h.mu.Lock()
+	subscribers := len(h.subscribers)
+	h.mu.Unlock()
+	return ListenerStats{
+		Subscribers: subscribers,
+		Delivered:   h.delivered.Load(),
+		Dropped:     h.dropped.Load(),
+	}
+}
+
+// Listen calls the handler for every notification in the org until the context is done, a handler fails, or the hub disconnects us.
+// resync is called instead when notifications may have been missed, and may be nil
+func (h *NotificationHub) Listen(ctx context.Context, orgID string, handler func(*DeviceGeolocation) error, resync func() error) error {
+	id, subscriber := h.subscribe(orgID)
+	defer h.unsubscribe(id)
+	return deliver(ctx, subscriber, handler, resync)
+}
+
+// discardBuffered empties the subscriber's notifications, and returns false if they were closed
+func discardBuffered(subscriber *subscriber) bool {
+	for {
+		select {
+		case _, ok := <-subscriber.notifications:
+			if !ok {
+				return false
+			}
+		default:
+			return true
+		}
+	}
+}
+
+func deliver(ctx context.Context, subscriber *subscriber, handler func(*DeviceGeolocation) error, resync func() error) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to wait for notification: %v", ctx.Err())
+		case <-subscriber.resync:
+			if resync == nil {
+				continue
+			}
+			// what's buffered is older than what the resync will look up, so it would only move devices backwards
+			if !discardBuffered(subscriber) {
+				return fmt.Errorf("failed to wait for notification: listener stopped")
+			}
+			err := resync()
+			if err != nil {
+				return fmt.Errorf("failed to resync: %v", err)
+			}
+		case geolocation, ok := <-subscriber.notifications:
+			if !ok {
+				return fmt.Errorf("failed to wait for notification: listener stopped")
+			}
+			err := handler(geolocation)
+			if err != nil {
+				return fmt.Errorf("failed to handle notification: %v", err)
+			}
+		}
+	}
+}
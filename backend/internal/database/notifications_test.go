@@ -0,0 +1,118 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNotificationHubResyncsDroppedSubscribers(t *testing.T) {
+	hub := NewNotificationHub()
+	id, subscriber := hub.subscribe(DefaultOrgID)
+	defer hub.unsubscribe(id)
+	for i := 0; i < listenerBufferSize+1; i++ {
+		hub.Publish(DefaultOrgID, &DeviceGeolocation{DeviceID: "a"})
+	}
+	if stats := hub.Stats(); stats.Dropped != 1 {
+		t.Fatalf("got %d dropped, want 1", stats.Dropped)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handled := 0
+	resyncs := 0
+	deliver(ctx, subscriber, func(*DeviceGeolocation) error {
+		handled++
+		return nil
+	}, func() error {
+		resyncs++
+		cancel()
+		return nil
+	})
+	if resyncs != 1 {
+		t.Errorf("got %d resyncs, want 1", resyncs)
+	}
+	// some may be handled before the resync is noticed, but the rest are discarded
+	if handled >= listenerBufferSize {
+		t.Errorf("handled all %d buffered notifications, want them discarded", handled)
+	}
+}
+
+func TestNotificationHubResync(t *testing.T) {
+	tests := []struct {
+		name string
+		// run against a hub with one subscriber in the default org
+		run         func(hub *NotificationHub)
+		wantResyncs int
+		wantHandled int
+	}{
+		{name: "nothing happened", run: func(hub *NotificationHub) {}},
+		{name: "a resync", run: func(hub *NotificationHub) { hub.Resync() }, wantResyncs: 1},
+		{
+			name:        "resyncs before the subscriber gets to them are one resync",
+			run:         func(hub *NotificationHub) { hub.Resync(); hub.Resync(); hub.Resync() },
+			wantResyncs: 1,
+		},
+		{
+			name: "other orgs' notifications aren't delivered",
+			run: func(hub *NotificationHub) {
+				hub.Publish("other", &DeviceGeolocation{DeviceID: "a"})
+				hub.Publish(DefaultOrgID, &DeviceGeolocation{DeviceID: "b"})
+			},
+			wantHandled: 1,
+		},
+		{
+			name:        "notifications for every org are delivered",
+			run:         func(hub *NotificationHub) { hub.PublishToAll(&DeviceGeolocation{DeviceID: "a"}) },
+			wantHandled: 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hub := NewNotificationHub()
+			id, subscriber := hub.subscribe(DefaultOrgID)
+			defer hub.unsubscribe(id)
+			test.run(hub)
+
+			// stop once the subscriber has caught up
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			handled := 0
+			resyncs := 0
+			deliver(ctx, subscriber, func(*DeviceGeolocation) error {
+				handled++
+				return nil
+			}, func() error {
+				resyncs++
+				return nil
+			})
+			if resyncs != test.wantResyncs || handled != test.wantHandled {
+				t.Errorf("got %d resyncs and %d handled, want %d and %d", resyncs, handled, test.wantResyncs, test.wantHandled)
+			}
+		})
+	}
+}
+
+func TestNotificationHubCloseAll(t *testing.T) {
+	hub := NewNotificationHub()
+	listening := make(chan error, 1)
+	go func() {
+		listening <- hub.Listen(context.Background(), DefaultOrgID, func(*DeviceGeolocation) error { return nil }, nil)
+	}()
+	for hub.Stats().Subscribers == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	hub.CloseAll()
+
+	select {
+	case err := <-listening:
+		if err == nil {
+			t.Errorf("listen returned without an error after the hub closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("listen didn't return after the hub closed")
+	}
+	if subscribers := hub.Stats().Subscribers; subscribers != 0 {
+		t.Errorf("got %d subscribers after closing", subscribers)
+	}
+}
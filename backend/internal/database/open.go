@@ -0,0 +1,43 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const (
+	memoryScheme = "memory://"
+	boltScheme   = "bolt://"
+)
+
+// Open picks the storage backend from the connection url.
+// "memory://" keeps everything in process, "bolt://path/to/file.db" keeps it in an embedded file,
+// and anything else is treated as a postgres connection url
+func Open(ctx context.Context, connectionURL string) (Repo, error) {
+	switch {
+	case strings.HasPrefix(connectionURL, memoryScheme):
+		fmt.Println("Using in-memory repo")
+		return NewMemory(), nil
+	case strings.HasPrefix(connectionURL, boltScheme):
+		path := strings.TrimPrefix(connectionURL, boltScheme)
+		repo, err := NewBolt(path)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("Using bolt repo at %v\n", path)
+		return repo, nil
+	}
+
+	repo, err := New(ctx, connectionURL)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Connected to postgres")
+	return repo, nil
+}
+
+// IsPostgres is whether the connection url is for postgres, which is the only backend with migrations
+func IsPostgres(connectionURL string) bool {
+	return !strings.HasPrefix(connectionURL, memoryScheme) && !strings.HasPrefix(connectionURL, boltScheme)
+}
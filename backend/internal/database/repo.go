@@ -2,25 +2,80 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
+	"strings"
+	"time"
 
 	"github.com/NinjaPerson24119/MapProject/backend/internal/filters"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-const (
-	deviceGeolocationInsertedNotificationChannel = "geolocation_inserted"
-	insertGeolocationQuery                       = `
-		INSERT INTO device.geolocation (device_id, event_time, latitude ,longitude)
-		VALUES (@device_id, @event_time, @latitude, @longitude);
-	`
-)
+const deviceGeolocationInsertedNotificationChannel = "geolocation_inserted"
+
+// optional telemetry, nullable in both geolocation tables, in the same order as Telemetry.values
+var telemetryColumns = []string{"altitude_msl", "altitude_agl", "heading", "ground_speed", "vertical_speed", "horizontal_accuracy", "battery_percent"}
+
+// columnList is ", a.x, a.y" for appending columns to a select list, or ", x, y" without an alias
+func columnList(alias string, columns []string) string {
+	list := ""
+	for _, column := range columns {
+		if alias != "" {
+			column = alias + "." + column
+		}
+		list += ", " + column
+	}
+	return list
+}
+
+// telemetryArrays is ", @x::float8[], @y::float8[]" for appending telemetry to unnest
+func telemetryArrays() string {
+	list := ""
+	for _, column := range telemetryColumns {
+		list += fmt.Sprintf(", @%s::float8[]", column)
+	}
+	return list
+}
+
+const deviceColumns = "device_id, org_id, device_name, device_type, model, tags, attributes, created, updated, deleted"
+
+// selecting the device inserts nothing when it isn't in the org
+const insertGeolocationQuery = `
+	INSERT INTO device.geolocation (device_id, event_time, latitude, longitude,
+		altitude_msl, altitude_agl, heading, ground_speed, vertical_speed, horizontal_accuracy, battery_percent)
+	SELECT device_id, @event_time, @latitude, @longitude,
+		@altitude_msl, @altitude_agl, @heading, @ground_speed, @vertical_speed, @horizontal_accuracy, @battery_percent
+	FROM device.information
+	WHERE device_id = @device_id AND org_id = @org_id
+	ON CONFLICT (device_id, event_time) DO NOTHING;
+`
+
+// IsTransient reports whether a write may succeed if it's retried, e.g. after a dropped connection or a serialization failure,
+// as opposed to one that fails on what was written and will fail the same way again
+func IsTransient(err error) bool {
+	if pgconn.SafeToRetry(err) || pgconn.Timeout(err) {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// connection exceptions, transaction rollbacks, insufficient resources and operator intervention
+		switch pgErr.Code[:2] {
+		case "08", "40", "53", "57":
+			return true
+		}
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
 
 type RepoImpl struct {
 	// this resource is thread safe
-	pool          *pgxpool.Pool
-	connectionURL string
+	pool     *pgxpool.Pool
+	listener *pgListener
 }
 
 func New(ctx context.Context, connectionURL string) (*RepoImpl, error) {
@@ -36,93 +91,513 @@ func New(ctx context.Context, connectionURL string) (*RepoImpl, error) {
 	}
 
 	return &RepoImpl{
-		pool:          pool,
-		connectionURL: connectionURL,
+		pool:     pool,
+		listener: newPGListener(connectionURL, deviceGeolocationInsertedNotificationChannel),
 	}, nil
 }
 
 func (s *RepoImpl) Close() {
+	s.listener.close()
 	s.pool.Close()
 }
 
-func (s *RepoImpl) InsertDevice(ctx context.Context, device *Device) (string, error) {
+func (s *RepoImpl) InsertDevice(ctx context.Context, orgID string, device *Device) (string, error) {
 	var id string
+	// selecting from the org inserts nothing when it doesn't exist
 	query := `
-		INSERT INTO device.information (device_name)
-		VALUES (@name)
+		INSERT INTO device.information (org_id, device_name, device_type, model, tags, attributes)
+		SELECT org_id, @name, @device_type, @model, @tags::text[], @attributes::jsonb
+		FROM device.organization
+		WHERE org_id = @org_id
 		RETURNING device_id;
 	`
+	// the columns aren't nullable, so missing tags and attributes are stored empty
 	args := pgx.NamedArgs{
-		"name": device.Name,
+		"org_id":      orgID,
+		"name":        device.Name,
+		"device_type": device.DeviceType,
+		"model":       device.Model,
+		"tags":        append([]string{}, device.Tags...),
+		"attributes":  copyAttributes(device.Attributes),
 	}
 	err := s.pool.QueryRow(ctx, query, args).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", fmt.Errorf("org %v: %w", orgID, ErrNotFound)
+	}
 	if err != nil {
 		return "", fmt.Errorf("failed to insert device: %v", err)
 	}
 	return id, nil
 }
 
-func (s *RepoImpl) ListDevices(ctx context.Context, paging filters.PageOptions) ([]*Device, error) {
-	if paging.Page < 1 || paging.PageSize < 1 || paging.PageSize > 1000 {
-		return nil, fmt.Errorf("repo: invalid page or pageSize")
+// pagingNamedArgs covers both paging forms for device listings. queries filter on @after for cursors and skip @offset for pages
+func pagingNamedArgs(paging filters.PageOptions) (pgx.NamedArgs, error) {
+	if err := paging.Validate(); err != nil {
+		return nil, fmt.Errorf("repo: %v", err)
+	}
+	after, err := filters.DecodeDeviceCursor(paging.Cursor)
+	if err != nil {
+		return nil, fmt.Errorf("repo: %v", err)
 	}
+	return pgx.NamedArgs{
+		"after":  after,
+		"offset": paging.Offset(),
+		"limit":  paging.PageSize,
+	}, nil
+}
+
+// deviceConditions are appended to a WHERE clause, on device.information as alias
+func deviceConditions(filter filters.DeviceFilter, alias string, args pgx.NamedArgs) string {
+	conditions := ""
+	if filter.DeviceType != "" {
+		args["device_type"] = filter.DeviceType
+		conditions += " AND " + alias + ".device_type = @device_type"
+	}
+	if filter.Model != "" {
+		args["model"] = filter.Model
+		conditions += " AND " + alias + ".model = @model"
+	}
+	if len(filter.Tags) > 0 {
+		args["tags"] = filter.Tags
+		conditions += " AND " + alias + ".tags @> @tags::text[]"
+	}
+	if len(filter.Attributes) > 0 {
+		args["attributes"] = filter.Attributes
+		conditions += " AND " + alias + ".attributes @> @attributes::jsonb"
+	}
+	return conditions
+}
+
+func (s *RepoImpl) ListDevices(ctx context.Context, orgID string, paging filters.PageOptions, filter filters.DeviceFilter) ([]*Device, string, error) {
+	args, err := pagingNamedArgs(paging)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := filter.Validate(); err != nil {
+		return nil, "", fmt.Errorf("repo: %v", err)
+	}
+	args["org_id"] = orgID
 	query := `
-		SELECT device_id, device_name, created, updated, deleted
-		FROM device.information
-		WHERE deleted IS NULL
+		SELECT ` + deviceColumns + `
+		FROM device.information AS i
+		WHERE org_id = @org_id AND deleted IS NULL AND (@after::uuid IS NULL OR device_id < @after::uuid)` + deviceConditions(filter, "i", args) + `
 		ORDER BY device_id DESC
 		OFFSET @offset
 		LIMIT @limit;
 	`
-	args := pgx.NamedArgs{
-		"offset": (paging.Page - 1) * paging.PageSize,
-		"limit":  paging.PageSize,
-	}
 	rows, err := s.pool.Query(ctx, query, args)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list devices: %v", err)
+		return nil, "", fmt.Errorf("failed to list devices: %v", err)
 	}
 	defer rows.Close()
 
 	devices, err := pgx.CollectRows(rows, pgx.RowToStructByName[Device])
 	if err != nil {
-		return nil, fmt.Errorf("failed to collect devices: %v", err)
+		return nil, "", fmt.Errorf("failed to collect devices: %v", err)
 	}
 
 	ptrs := make([]*Device, len(devices))
 	for i := range devices {
 		ptrs[i] = &devices[i]
 	}
-	return ptrs, nil
+	nextCursor := ""
+	if len(ptrs) > 0 {
+		nextCursor = paging.NextCursor(len(ptrs), ptrs[len(ptrs)-1].DeviceID)
+	}
+	return ptrs, nextCursor, nil
 }
 
-func insertGeolocationNamedArgs(geolocation *DeviceGeolocation) pgx.NamedArgs {
-	return pgx.NamedArgs{
+// GetDevice also returns soft deleted devices, so they can be restored
+func (s *RepoImpl) GetDevice(ctx context.Context, orgID string, deviceID string) (*Device, error) {
+	query := `
+		SELECT ` + deviceColumns + `
+		FROM device.information
+		WHERE device_id = @device_id AND org_id = @org_id;
+	`
+	args := pgx.NamedArgs{
+		"device_id": deviceID,
+		"org_id":    orgID,
+	}
+	rows, err := s.pool.Query(ctx, query, args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device: %v", err)
+	}
+	device, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[Device])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("device %v: %w", deviceID, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect device: %v", err)
+	}
+	return device, nil
+}
+
+func (s *RepoImpl) GetMultiDevices(ctx context.Context, orgID string, deviceIDs []string) ([]*Device, error) {
+	query := `
+		SELECT ` + deviceColumns + `
+		FROM device.information
+		WHERE device_id = ANY(@device_ids::text[]::uuid[]) AND org_id = @org_id;
+	`
+	args := pgx.NamedArgs{
+		"device_ids": deviceIDs,
+		"org_id":     orgID,
+	}
+	rows, err := s.pool.Query(ctx, query, args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get devices: %v", err)
+	}
+	found, err := pgx.CollectRows(rows, pgx.RowToAddrOfStructByName[Device])
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect devices: %v", err)
+	}
+
+	devicesMap := map[string]*Device{}
+	for _, device := range found {
+		devicesMap[device.DeviceID] = device
+	}
+	devices := make([]*Device, len(deviceIDs))
+	for i, deviceID := range deviceIDs {
+		devices[i] = devicesMap[strings.ToLower(deviceID)]
+	}
+	return devices, nil
+}
+
+// notifyDeviceChanged is delivered on commit, like the insert trigger. there's no event time, so listeners look the device up again
+func notifyDeviceChanged(ctx context.Context, tx pgx.Tx, orgID string, deviceID string) error {
+	_, err := tx.Exec(ctx, "SELECT pg_notify(@channel, json_build_object('device_id', @device_id::uuid, 'org_id', @org_id::uuid)::text);", pgx.NamedArgs{
+		"channel":   deviceGeolocationInsertedNotificationChannel,
+		"device_id": deviceID,
+		"org_id":    orgID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to notify device update: %v", err)
+	}
+	return nil
+}
+
+// UpdateDevice changes the fields that are set. deleted devices have to be restored first
+func (s *RepoImpl) UpdateDevice(ctx context.Context, orgID string, update *DeviceUpdate) (*Device, error) {
+	query := `
+		UPDATE device.information
+		SET device_name = COALESCE(@name, device_name),
+			device_type = COALESCE(@device_type, device_type),
+			model = COALESCE(@model, model),
+			tags = COALESCE(@tags::text[], tags),
+			attributes = COALESCE(@attributes::jsonb, attributes),
+			updated = CURRENT_TIMESTAMP
+		WHERE device_id = @device_id AND org_id = @org_id AND deleted IS NULL
+		RETURNING ` + deviceColumns + `;
+	`
+	// fields that aren't set are NULL, so they keep their current value
+	args := pgx.NamedArgs{
+		"device_id":   update.DeviceID,
+		"org_id":      orgID,
+		"name":        update.Name,
+		"device_type": update.DeviceType,
+		"model":       update.Model,
+		"tags":        nil,
+		"attributes":  nil,
+	}
+	if update.Tags != nil {
+		args["tags"] = append([]string{}, *update.Tags...)
+	}
+	if update.Attributes != nil {
+		args["attributes"] = copyAttributes(*update.Attributes)
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, query, args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update device: %v", err)
+	}
+	updated, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[Device])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("device %v: %w", update.DeviceID, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect device: %v", err)
+	}
+
+	// streams filtering on what changed pick up or drop the device
+	if err := notifyDeviceChanged(ctx, tx, orgID, update.DeviceID); err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// setDeviceDeleted soft deletes or restores a device, and notifies listeners so streams drop or pick up the device.
+// deleting a deleted device, or restoring an active one, is a no-op
+func (s *RepoImpl) setDeviceDeleted(ctx context.Context, orgID string, deviceID string, deleted bool) (*Device, error) {
+	query := `
+		UPDATE device.information
+		SET deleted = CASE WHEN @deleted::boolean THEN COALESCE(deleted, CURRENT_TIMESTAMP) ELSE NULL END,
+			updated = CURRENT_TIMESTAMP
+		WHERE device_id = @device_id AND org_id = @org_id
+		RETURNING ` + deviceColumns + `;
+	`
+	args := pgx.NamedArgs{
+		"device_id": deviceID,
+		"org_id":    orgID,
+		"deleted":   deleted,
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, query, args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update device: %v", err)
+	}
+	device, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[Device])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("device %v: %w", deviceID, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect device: %v", err)
+	}
+
+	if err := notifyDeviceChanged(ctx, tx, orgID, deviceID); err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+func (s *RepoImpl) DeleteDevice(ctx context.Context, orgID string, deviceID string) (*Device, error) {
+	return s.setDeviceDeleted(ctx, orgID, deviceID, true)
+}
+
+func (s *RepoImpl) RestoreDevice(ctx context.Context, orgID string, deviceID string) (*Device, error) {
+	return s.setDeviceDeleted(ctx, orgID, deviceID, false)
+}
+
+func (s *RepoImpl) InsertOrganization(ctx context.Context, org *Organization) (string, error) {
+	var id string
+	query := `
+		INSERT INTO device.organization (org_name)
+		VALUES (@name)
+		RETURNING org_id;
+	`
+	args := pgx.NamedArgs{
+		"name": org.Name,
+	}
+	err := s.pool.QueryRow(ctx, query, args).Scan(&id)
+	if err != nil {
+		return "", fmt.Errorf("failed to insert org: %v", err)
+	}
+	return id, nil
+}
+
+func (s *RepoImpl) ListOrganizations(ctx context.Context) ([]*Organization, error) {
+	query := `
+		SELECT org_id, org_name, created
+		FROM device.organization
+		ORDER BY org_name, org_id;
+	`
+	rows, err := s.pool.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list orgs: %v", err)
+	}
+	orgs, err := pgx.CollectRows(rows, pgx.RowToAddrOfStructByName[Organization])
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect orgs: %v", err)
+	}
+	return orgs, nil
+}
+
+func (s *RepoImpl) SetOrganizationAPIKey(ctx context.Context, orgID string, keyHash []byte) error {
+	tag, err := s.pool.Exec(ctx, "UPDATE device.organization SET api_key_hash = @api_key_hash WHERE org_id = @org_id;", pgx.NamedArgs{
+		"org_id":       orgID,
+		"api_key_hash": keyHash,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set org api key: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("org %v: %w", orgID, ErrNotFound)
+	}
+	return nil
+}
+
+func (s *RepoImpl) GetOrganizationByAPIKey(ctx context.Context, keyHash []byte) (*Organization, error) {
+	query := `
+		SELECT org_id, org_name, created
+		FROM device.organization
+		WHERE api_key_hash = @api_key_hash;
+	`
+	rows, err := s.pool.Query(ctx, query, pgx.NamedArgs{
+		"api_key_hash": keyHash,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get org by api key: %v", err)
+	}
+	org, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[Organization])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("api key: %w", ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get org by api key: %v", err)
+	}
+	return org, nil
+}
+
+func (s *RepoImpl) MoveDevice(ctx context.Context, deviceID string, orgID string) (*Device, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var exists bool
+	err = tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM device.organization WHERE org_id = @org_id);", pgx.NamedArgs{
+		"org_id": orgID,
+	}).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get org: %v", err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("org %v: %w", orgID, ErrNotFound)
+	}
+
+	// the row lock keeps the old org stable until we've notified it
+	var from string
+	err = tx.QueryRow(ctx, "SELECT org_id FROM device.information WHERE device_id = @device_id FOR UPDATE;", pgx.NamedArgs{
+		"device_id": deviceID,
+	}).Scan(&from)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("device %v: %w", deviceID, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device: %v", err)
+	}
+
+	query := `
+		UPDATE device.information
+		SET org_id = @org_id,
+			updated = CURRENT_TIMESTAMP
+		WHERE device_id = @device_id
+		RETURNING ` + deviceColumns + `;
+	`
+	rows, err := tx.Query(ctx, query, pgx.NamedArgs{
+		"device_id": deviceID,
+		"org_id":    orgID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to move device: %v", err)
+	}
+	device, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[Device])
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect device: %v", err)
+	}
+
+	// the old org's streams drop the device, and the new org's pick it up
+	if err := notifyDeviceChanged(ctx, tx, from, deviceID); err != nil {
+		return nil, err
+	}
+	if err := notifyDeviceChanged(ctx, tx, orgID, deviceID); err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+func insertGeolocationNamedArgs(orgID string, geolocation *DeviceGeolocation) pgx.NamedArgs {
+	args := pgx.NamedArgs{
+		"org_id":     orgID,
 		"device_id":  geolocation.DeviceID,
 		"event_time": geolocation.EventTime,
 		"latitude":   geolocation.Latitude,
 		"longitude":  geolocation.Longitude,
 	}
+	for i, value := range geolocation.Telemetry.values() {
+		args[telemetryColumns[i]] = value
+	}
+	return args
+}
+
+// queryer is a pool or a transaction
+type queryer interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// checkOrgDevices fails if any of the geolocations are for a device outside the org, before anything is written
+func checkOrgDevices(ctx context.Context, q queryer, orgID string, geolocations []*DeviceGeolocation) error {
+	deviceIDs := make([]string, 0, len(geolocations))
+	for _, geolocation := range geolocations {
+		deviceIDs = append(deviceIDs, geolocation.DeviceID)
+	}
+	query := `
+		SELECT device_id::text
+		FROM device.information
+		WHERE device_id = ANY(@device_ids::text[]::uuid[]) AND org_id = @org_id;
+	`
+	rows, err := q.Query(ctx, query, pgx.NamedArgs{
+		"device_ids": deviceIDs,
+		"org_id":     orgID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to check devices: %v", err)
+	}
+	found, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return fmt.Errorf("failed to collect devices: %v", err)
+	}
+	inOrg := map[string]bool{}
+	for _, deviceID := range found {
+		inOrg[deviceID] = true
+	}
+	for _, deviceID := range deviceIDs {
+		if !inOrg[strings.ToLower(deviceID)] {
+			return fmt.Errorf("device %v: %w", deviceID, ErrNotFound)
+		}
+	}
+	return nil
 }
 
-func (s *RepoImpl) InsertGeolocation(ctx context.Context, geolocation *DeviceGeolocation) error {
-	args := insertGeolocationNamedArgs(geolocation)
-	_, err := s.pool.Exec(ctx, insertGeolocationQuery, args)
+// InsertGeolocation checks the device first, since a duplicate inserts nothing either
+func (s *RepoImpl) InsertGeolocation(ctx context.Context, orgID string, geolocation *DeviceGeolocation) error {
+	err := s.InsertMultiGeolocation(ctx, orgID, []*DeviceGeolocation{geolocation})
 	if err != nil {
-		return fmt.Errorf("failed to insert geolocation: %v", err)
+		return fmt.Errorf("failed to insert geolocation: %w", err)
 	}
 	return nil
 }
 
-func (s *RepoImpl) InsertMultiGeolocation(ctx context.Context, geolocations []*DeviceGeolocation) error {
+func (s *RepoImpl) InsertMultiGeolocation(ctx context.Context, orgID string, geolocations []*DeviceGeolocation) error {
 	tx, err := s.pool.Begin(ctx)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback(ctx)
+
+	if err := checkOrgDevices(ctx, tx, orgID, geolocations); err != nil {
+		return fmt.Errorf("failed to insert multi geolocation: %w", err)
+	}
 
 	batch := &pgx.Batch{}
 	for _, geolocation := range geolocations {
-		args := insertGeolocationNamedArgs(geolocation)
+		args := insertGeolocationNamedArgs(orgID, geolocation)
 		batch.Queue(insertGeolocationQuery, args)
 	}
 	br := tx.SendBatch(ctx, batch)
@@ -140,62 +615,282 @@ func (s *RepoImpl) InsertMultiGeolocation(ctx context.Context, geolocations []*D
 	return nil
 }
 
-func (s *RepoImpl) ListLatestGeolocations(ctx context.Context, paging filters.PageOptions) ([]*DeviceGeolocation, error) {
-	if paging.Page < 1 || paging.PageSize < 1 || paging.PageSize > 1000 {
-		return nil, fmt.Errorf("repo: invalid page or pageSize")
+// ingestArgs passes geolocations as parallel arrays for unnest.
+// positions go as numeric so they are stored exactly, and exact duplicates compare equal
+func ingestArgs(geolocations []*DeviceGeolocation) pgx.NamedArgs {
+	deviceIDs := make([]string, len(geolocations))
+	eventTimes := make([]time.Time, len(geolocations))
+	latitudes := make([]float64, len(geolocations))
+	longitudes := make([]float64, len(geolocations))
+	telemetry := make([][]*float64, len(telemetryColumns))
+	for i, geolocation := range geolocations {
+		deviceIDs[i] = geolocation.DeviceID
+		eventTimes[i] = geolocation.EventTime
+		latitudes[i] = geolocation.Latitude
+		longitudes[i] = geolocation.Longitude
+		for j, value := range geolocation.Telemetry.values() {
+			telemetry[j] = append(telemetry[j], value)
+		}
+	}
+	args := pgx.NamedArgs{
+		"device_ids":  deviceIDs,
+		"event_times": eventTimes,
+		"latitudes":   latitudes,
+		"longitudes":  longitudes,
+	}
+	for j, column := range telemetryColumns {
+		args[column] = telemetry[j]
+	}
+	return args
+}
+
+// IngestMultiGeolocation locks whatever is already stored under the same keys, then decides what to insert and update.
+// the update trigger keeps latest positions and notifications in step with updated rows
+func (s *RepoImpl) IngestMultiGeolocation(ctx context.Context, orgID string, geolocations []*DeviceGeolocation, policy DuplicatePolicy) ([]*IngestResult, error) {
+	if _, err := ParseDuplicatePolicy(string(policy)); err != nil {
+		return nil, fmt.Errorf("repo: %v", err)
+	}
+	// postgres keeps microseconds and lowercase uuids, so compare keys the way they will be stored
+	truncated := make([]*DeviceGeolocation, len(geolocations))
+	for i, geolocation := range geolocations {
+		if err := ValidateGeolocation(geolocation); err != nil {
+			return nil, fmt.Errorf("repo: %v", err)
+		}
+		copied := *geolocation
+		copied.DeviceID = strings.ToLower(geolocation.DeviceID)
+		copied.EventTime = geolocation.EventTime.Truncate(time.Microsecond)
+		truncated[i] = &copied
 	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := checkOrgDevices(ctx, tx, orgID, truncated); err != nil {
+		return nil, fmt.Errorf("repo: %w", err)
+	}
+
 	query := `
-		SELECT d.device_id, d.event_time, d.latitude, d.longitude, d.created, d.updated, d.deleted
-		FROM device.geolocation AS d
-		INNER JOIN (
-			SELECT device_id, MAX(event_time) AS max_event_time
-			FROM device.geolocation
-			WHERE deleted IS NULL
-			GROUP BY device_id
-		) m ON m.max_event_time = d.event_time AND m.device_id = d.device_id
-		WHERE d.deleted IS NULL
-		ORDER BY device_id DESC
+		SELECT g.device_id, g.event_time, g.latitude, g.longitude` + columnList("g", telemetryColumns) + `
+		FROM device.geolocation AS g
+		INNER JOIN unnest(@device_ids::text[]::uuid[], @event_times::timestamptz[]) AS k(device_id, event_time)
+		ON g.device_id = k.device_id AND g.event_time = k.event_time
+		FOR UPDATE OF g;
+	`
+	rows, err := tx.Query(ctx, query, ingestArgs(truncated))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find stored geolocations: %w", err)
+	}
+	storedRows, err := pgx.CollectRows(rows, pgx.RowToAddrOfStructByNameLax[DeviceGeolocation])
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect stored geolocations: %w", err)
+	}
+	stored := map[geolocationKey]*DeviceGeolocation{}
+	for _, geolocation := range storedRows {
+		stored[keyOf(geolocation)] = geolocation
+	}
+
+	plan := planIngest(truncated, policy, func(geolocation *DeviceGeolocation) *DeviceGeolocation {
+		return stored[keyOf(geolocation)]
+	})
+	if len(plan.inserts) > 0 {
+		// a row inserted by someone else since we looked is left alone, and reported as a duplicate below
+		query := `
+			INSERT INTO device.geolocation (device_id, event_time, latitude, longitude` + columnList("", telemetryColumns) + `)
+			SELECT * FROM unnest(@device_ids::text[]::uuid[], @event_times::timestamptz[], @latitudes::numeric[], @longitudes::numeric[]` + telemetryArrays() + `)
+			ON CONFLICT (device_id, event_time) DO NOTHING
+			RETURNING device_id::text, event_time;
+		`
+		rows, err := tx.Query(ctx, query, ingestArgs(plan.inserts))
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert geolocations: %w", err)
+		}
+		inserted := map[geolocationKey]bool{}
+		for rows.Next() {
+			geolocation := &DeviceGeolocation{}
+			if err := rows.Scan(&geolocation.DeviceID, &geolocation.EventTime); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to collect inserted geolocations: %w", err)
+			}
+			inserted[keyOf(geolocation)] = true
+		}
+		rows.Close()
+		if rows.Err() != nil {
+			return nil, fmt.Errorf("failed to insert geolocations: %w", rows.Err())
+		}
+		for i, result := range plan.results {
+			if result.Status == IngestInserted && !inserted[keyOf(truncated[i])] {
+				result.Status = IngestDuplicate
+			}
+		}
+	}
+	if len(plan.updates) > 0 {
+		query := `
+			UPDATE device.geolocation AS g
+			SET (latitude, longitude` + columnList("", telemetryColumns) + `, updated) = (u.latitude, u.longitude` + columnList("u", telemetryColumns) + `, CURRENT_TIMESTAMP)
+			FROM unnest(@device_ids::text[]::uuid[], @event_times::timestamptz[], @latitudes::numeric[], @longitudes::numeric[]` + telemetryArrays() + `)
+				AS u(device_id, event_time, latitude, longitude` + columnList("", telemetryColumns) + `)
+			WHERE g.device_id = u.device_id AND g.event_time = u.event_time;
+		`
+		_, err := tx.Exec(ctx, query, ingestArgs(plan.updates))
+		if err != nil {
+			return nil, fmt.Errorf("failed to update geolocations: %w", err)
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// report the event times that were sent, not the truncated ones
+	for i, result := range plan.results {
+		result.EventTime = geolocations[i].EventTime
+	}
+	return plan.results, nil
+}
+
+// CopyMultiGeolocation streams the geolocations with a single COPY instead of one INSERT per row.
+// COPY fails on any conflict, so it goes to a temporary table first, and rows already stored are skipped on the way in.
+// the insert still fires row triggers, so the geolocation_inserted notifications go out as usual
+func (s *RepoImpl) CopyMultiGeolocation(ctx context.Context, orgID string, geolocations []*DeviceGeolocation) (*IngestStats, error) {
+	before := time.Now()
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// COPY can't filter rows, so devices are checked first
+	if err := checkOrgDevices(ctx, tx, orgID, geolocations); err != nil {
+		return nil, fmt.Errorf("failed to copy multi geolocation: %w", err)
+	}
+	columns := append([]string{"device_id", "event_time", "latitude", "longitude"}, telemetryColumns...)
+	_, err = tx.Exec(ctx, `
+		CREATE TEMPORARY TABLE geolocation_copy ON COMMIT DROP AS
+		SELECT `+strings.Join(columns, ", ")+` FROM device.geolocation WITH NO DATA;
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create copy table: %w", err)
+	}
+	rows := make([][]any, len(geolocations))
+	for i, geolocation := range geolocations {
+		rows[i] = []any{geolocation.DeviceID, geolocation.EventTime, geolocation.Latitude, geolocation.Longitude}
+		for _, value := range geolocation.Telemetry.values() {
+			rows[i] = append(rows[i], value)
+		}
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"geolocation_copy"}, columns, pgx.CopyFromRows(rows))
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy multi geolocation: %w", err)
+	}
+	// the same point twice in one batch is skipped like one that was already stored
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO device.geolocation (`+strings.Join(columns, ", ")+`)
+		SELECT `+strings.Join(columns, ", ")+` FROM geolocation_copy
+		ON CONFLICT (device_id, event_time) DO NOTHING;
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert copied geolocations: %w", err)
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	inserted := int(tag.RowsAffected())
+	return &IngestStats{
+		Rows:       inserted,
+		Duplicates: len(geolocations) - inserted,
+		Duration:   time.Since(before),
+	}, nil
+}
+
+// boxCondition matches latest_geolocation_position_idx. a box across the antimeridian is split in two
+func boxCondition(box filters.BoundingBox, name string, args pgx.NamedArgs) string {
+	args[name+"_min_lat"] = box.MinLatitude
+	args[name+"_max_lat"] = box.MaxLatitude
+	args[name+"_min_lon"] = box.MinLongitude
+	args[name+"_max_lon"] = box.MaxLongitude
+	position := "point(l.longitude::float8, l.latitude::float8)"
+	if !box.CrossesAntimeridian() {
+		return fmt.Sprintf(`%[1]s <@ box(point(@%[2]s_min_lon, @%[2]s_min_lat), point(@%[2]s_max_lon, @%[2]s_max_lat))`, position, name)
+	}
+	return fmt.Sprintf(`(%[1]s <@ box(point(@%[2]s_min_lon, @%[2]s_min_lat), point(180, @%[2]s_max_lat))
+			OR %[1]s <@ box(point(-180, @%[2]s_min_lat), point(@%[2]s_max_lon, @%[2]s_max_lat)))`, position, name)
+}
+
+// spatialConditions returns extra WHERE conditions on latest_geolocation aliased as l
+func spatialConditions(spatial filters.SpatialFilter, args pgx.NamedArgs) string {
+	conditions := ""
+	if spatial.BoundingBox != nil {
+		conditions += " AND " + boxCondition(*spatial.BoundingBox, "bbox", args)
+	}
+	if spatial.Circle != nil {
+		args["circle_lat"] = spatial.Circle.Latitude
+		args["circle_lon"] = spatial.Circle.Longitude
+		args["circle_radius"] = spatial.Circle.RadiusMeters
+		conditions += " AND " + boxCondition(spatial.Circle.Bounds(), "circle", args)
+		conditions += " AND device.haversine_meters(@circle_lat, @circle_lon, l.latitude::float8, l.longitude::float8) <= @circle_radius"
+	}
+	return conditions
+}
+
+func (s *RepoImpl) ListLatestGeolocations(ctx context.Context, orgID string, paging filters.PageOptions, spatial filters.SpatialFilter, device filters.DeviceFilter) ([]*DeviceGeolocation, string, error) {
+	args, err := pagingNamedArgs(paging)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := spatial.Validate(); err != nil {
+		return nil, "", fmt.Errorf("repo: %v", err)
+	}
+	if err := device.Validate(); err != nil {
+		return nil, "", fmt.Errorf("repo: %v", err)
+	}
+	args["org_id"] = orgID
+	query := `
+		SELECT l.device_id, l.event_time, l.latitude, l.longitude, l.created, l.updated, l.deleted` + columnList("l", telemetryColumns) + `
+		FROM device.latest_geolocation AS l
+		INNER JOIN device.information AS i ON i.device_id = l.device_id AND i.org_id = @org_id AND i.deleted IS NULL
+		WHERE l.deleted IS NULL AND (@after::uuid IS NULL OR l.device_id < @after::uuid)` + spatialConditions(spatial, args) + deviceConditions(device, "i", args) + `
+		ORDER BY l.device_id DESC
 		OFFSET @offset
 		LIMIT @limit;
 	`
-	args := pgx.NamedArgs{
-		"offset": (paging.Page - 1) * paging.PageSize,
-		"limit":  paging.PageSize,
-	}
 	rows, err := s.pool.Query(ctx, query, args)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get latest geolocations: %v", err)
+		return nil, "", fmt.Errorf("failed to get latest geolocations: %v", err)
 	}
 	defer rows.Close()
 
 	geolocations, err := pgx.CollectRows(rows, pgx.RowToStructByName[DeviceGeolocation])
 	if err != nil {
-		return nil, fmt.Errorf("failed to collect latest geolocations: %v", err)
+		return nil, "", fmt.Errorf("failed to collect latest geolocations: %v", err)
 	}
 
 	ptrs := make([]*DeviceGeolocation, len(geolocations))
 	for i := range geolocations {
 		ptrs[i] = &geolocations[i]
 	}
-	return ptrs, nil
+	nextCursor := ""
+	if len(ptrs) > 0 {
+		nextCursor = paging.NextCursor(len(ptrs), ptrs[len(ptrs)-1].DeviceID)
+	}
+	return ptrs, nextCursor, nil
 }
 
-func (s *RepoImpl) GetMultiLatestGeolocations(ctx context.Context, deviceIDs []string) ([]*DeviceGeolocation, error) {
+func (s *RepoImpl) GetMultiLatestGeolocations(ctx context.Context, orgID string, deviceIDs []string) ([]*DeviceGeolocation, error) {
 	query := `
-	SELECT d.device_id, d.event_time, d.latitude, d.longitude, d.created, d.updated, d.deleted
-	FROM device.geolocation AS d
-	INNER JOIN (
-		SELECT device_id, MAX(event_time) AS max_event_time
-		FROM device.geolocation
-		WHERE device_id = ANY(@deviceIDs) AND deleted IS NULL
-		GROUP BY device_id
-	) m ON m.max_event_time = d.event_time AND m.device_id = d.device_id
-	WHERE d.device_id = ANY(@deviceIDs) AND d.deleted IS NULL
-	ORDER BY device_id DESC
-	LIMIT @lim;
-`
+		SELECT l.device_id, l.event_time, l.latitude, l.longitude, l.created, l.updated, l.deleted` + columnList("l", telemetryColumns) + `
+		FROM device.latest_geolocation AS l
+		INNER JOIN device.information AS i ON i.device_id = l.device_id AND i.org_id = @org_id AND i.deleted IS NULL
+		WHERE l.device_id = ANY(@deviceIDs) AND l.deleted IS NULL
+		ORDER BY l.device_id DESC
+		LIMIT @lim;
+	`
 	args := pgx.NamedArgs{
 		"deviceIDs": deviceIDs,
+		"org_id":    orgID,
 		"lim":       len(deviceIDs),
 	}
 	rows, err := s.pool.Query(ctx, query, args)
@@ -221,30 +916,207 @@ func (s *RepoImpl) GetMultiLatestGeolocations(ctx context.Context, deviceIDs []s
 	return ptrs, nil
 }
 
-func (s *RepoImpl) ListenToGeolocationInserted(ctx context.Context, handler func(string) error) error {
-	// connect directly without pool to avoid competing with other connections
-	// TODO: this could really be a single connection for the entire app which multicasts to all listeners
-	conn, err := pgx.Connect(ctx, s.connectionURL)
+func (s *RepoImpl) GetMultiGeolocationsAt(ctx context.Context, orgID string, deviceIDs []string, at time.Time) ([]*DeviceGeolocation, error) {
+	query := `
+		SELECT g.device_id, g.event_time, g.latitude, g.longitude, g.created, g.updated, g.deleted` + columnList("g", telemetryColumns) + `
+		FROM device.information AS i
+		CROSS JOIN LATERAL (
+			SELECT *
+			FROM device.geolocation
+			WHERE device_id = i.device_id AND event_time <= @at AND deleted IS NULL
+			ORDER BY event_time DESC
+			LIMIT 1
+		) AS g
+		WHERE i.device_id = ANY(@device_ids::text[]::uuid[]) AND i.org_id = @org_id;
+	`
+	args := pgx.NamedArgs{
+		"device_ids": deviceIDs,
+		"org_id":     orgID,
+		"at":         at,
+	}
+	rows, err := s.pool.Query(ctx, query, args)
 	if err != nil {
-		return fmt.Errorf("failed to acquire connection: %v", err)
+		return nil, fmt.Errorf("failed to get geolocations at %v: %v", at, err)
 	}
-	defer conn.Close(ctx)
+	defer rows.Close()
 
-	_, err = conn.Exec(ctx, fmt.Sprintf("LISTEN %s;", deviceGeolocationInsertedNotificationChannel))
+	geolocations, err := pgx.CollectRows(rows, pgx.RowToStructByName[DeviceGeolocation])
 	if err != nil {
-		return fmt.Errorf("failed to listen: %v", err)
+		return nil, fmt.Errorf("failed to collect geolocations at %v: %v", at, err)
 	}
 
-	for {
-		notification, err := conn.WaitForNotification(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to wait for notification: %v", err)
-		}
+	geolocationsMap := map[string]*DeviceGeolocation{}
+	for i := range geolocations {
+		geolocationsMap[geolocations[i].DeviceID] = &geolocations[i]
+	}
+	ptrs := make([]*DeviceGeolocation, len(deviceIDs))
+	for i := range deviceIDs {
+		ptrs[i] = geolocationsMap[strings.ToLower(deviceIDs[i])]
+	}
+	return ptrs, nil
+}
 
-		deviceID := notification.Payload
-		err = handler(deviceID)
-		if err != nil {
-			return fmt.Errorf("failed to handle notification: %v", err)
-		}
+func (s *RepoImpl) ListGeolocationHistory(ctx context.Context, orgID string, deviceID string, history filters.HistoryOptions, paging filters.PageOptions) ([]*DeviceGeolocation, string, error) {
+	if err := history.Validate(); err != nil {
+		return nil, "", fmt.Errorf("repo: %v", err)
+	}
+	if err := paging.Validate(); err != nil {
+		return nil, "", fmt.Errorf("repo: %v", err)
 	}
+	after, err := filters.DecodeTimeCursor(paging.Cursor)
+	if err != nil {
+		return nil, "", fmt.Errorf("repo: %v", err)
+	}
+	args := pgx.NamedArgs{
+		"after":  after,
+		"offset": paging.Offset(),
+		"limit":  paging.PageSize,
+	}
+	args["device_id"] = deviceID
+	args["org_id"] = orgID
+	args["start_time"] = history.StartTime
+	args["end_time"] = history.EndTime
+	args["max_points"] = history.MaxPoints
+
+	// subsampling is decided over the whole range before paging, so every page comes from the same sample.
+	// that numbers the whole range for every page, but there are at most max_points / page_size pages
+	query := `
+		WITH ranged AS (
+			SELECT device_id, event_time, latitude, longitude, created, updated, deleted` + columnList("", telemetryColumns) + `,
+				ROW_NUMBER() OVER (ORDER BY event_time) AS point_number,
+				COUNT(*) OVER () AS total_points
+			FROM device.geolocation
+			WHERE device_id = @device_id AND event_time >= @start_time AND event_time < @end_time AND deleted IS NULL
+				AND EXISTS (SELECT 1 FROM device.information WHERE device_id = @device_id AND org_id = @org_id)
+		)
+		SELECT device_id, event_time, latitude, longitude, created, updated, deleted` + columnList("", telemetryColumns) + `
+		FROM ranged
+		WHERE (
+				@max_points::int = 0
+				OR total_points <= @max_points::int
+				OR (point_number - 1) % CEIL(total_points::numeric / GREATEST(@max_points::int, 1))::bigint = 0
+			)
+			AND (@after::timestamptz IS NULL OR event_time > @after::timestamptz)
+		ORDER BY event_time
+		OFFSET @offset
+		LIMIT @limit;
+	`
+	if history.MaxPoints == 0 {
+		// without subsampling, pages are read straight off the primary key after the cursor, so long exports don't rescan the range for every page
+		query = `
+			SELECT device_id, event_time, latitude, longitude, created, updated, deleted` + columnList("", telemetryColumns) + `
+			FROM device.geolocation
+			WHERE device_id = @device_id AND event_time >= @start_time AND event_time < @end_time AND deleted IS NULL
+				AND (@after::timestamptz IS NULL OR event_time > @after::timestamptz)
+				AND EXISTS (SELECT 1 FROM device.information WHERE device_id = @device_id AND org_id = @org_id)
+			ORDER BY event_time
+			OFFSET @offset
+			LIMIT @limit;
+		`
+	}
+	rows, err := s.pool.Query(ctx, query, args)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list geolocation history: %v", err)
+	}
+	defer rows.Close()
+
+	geolocations, err := pgx.CollectRows(rows, pgx.RowToStructByName[DeviceGeolocation])
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to collect geolocation history: %v", err)
+	}
+
+	ptrs := make([]*DeviceGeolocation, len(geolocations))
+	for i := range geolocations {
+		ptrs[i] = &geolocations[i]
+	}
+	nextCursor := ""
+	if len(ptrs) == paging.PageSize {
+		nextCursor = filters.EncodeTimeCursor(ptrs[len(ptrs)-1].EventTime)
+	}
+	return ptrs, nextCursor, nil
+}
+
+// CompactGeolocations keeps only the last geolocation per device in every resolution-sized bucket of [from, to).
+// buckets are aligned to the unix epoch, so compacting the same range twice is a no-op
+func (s *RepoImpl) CompactGeolocations(ctx context.Context, from time.Time, to time.Time, resolution time.Duration) (int64, error) {
+	if resolution <= 0 {
+		return 0, fmt.Errorf("repo: invalid resolution")
+	}
+	query := `
+		DELETE FROM device.geolocation AS g
+		USING (
+			SELECT device_id, event_time, ROW_NUMBER() OVER (
+				PARTITION BY device_id, FLOOR(EXTRACT(EPOCH FROM event_time) / @bucket_seconds)
+				ORDER BY event_time DESC
+			) AS bucket_row
+			FROM device.geolocation
+			WHERE event_time >= @from AND event_time < @to
+		) AS r
+		WHERE g.device_id = r.device_id AND g.event_time = r.event_time AND r.bucket_row > 1;
+	`
+	args := pgx.NamedArgs{
+		"bucket_seconds": resolution.Seconds(),
+		"from":           from,
+		"to":             to,
+	}
+	tag, err := s.pool.Exec(ctx, query, args)
+	if err != nil {
+		return 0, fmt.Errorf("failed to compact geolocations: %v", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// DeleteGeolocationsBefore drops whole daily partitions where it can, and only DELETEs from the partition the cutoff falls in.
+// rows in dropped partitions are counted from table statistics, so the total is approximate
+func (s *RepoImpl) DeleteGeolocationsBefore(ctx context.Context, before time.Time) (int64, error) {
+	var dropped int64
+	err := s.pool.QueryRow(ctx, "SELECT device.drop_geolocation_partitions_before(@before);", pgx.NamedArgs{
+		"before": before,
+	}).Scan(&dropped)
+	if err != nil {
+		return 0, fmt.Errorf("failed to drop geolocation partitions: %v", err)
+	}
+
+	query := `
+		DELETE FROM device.geolocation
+		WHERE event_time < @before;
+	`
+	args := pgx.NamedArgs{
+		"before": before,
+	}
+	tag, err := s.pool.Exec(ctx, query, args)
+	if err != nil {
+		return dropped, fmt.Errorf("failed to delete geolocations: %v", err)
+	}
+	return dropped + tag.RowsAffected(), nil
+}
+
+// EnsureGeolocationPartitions creates the daily partitions for every UTC day from one time to another, and returns how many were missing
+func (s *RepoImpl) EnsureGeolocationPartitions(ctx context.Context, from time.Time, to time.Time) (int, error) {
+	query := `
+		SELECT COUNT(*) FILTER (WHERE created)
+		FROM (
+			SELECT device.create_geolocation_partition(day::date) AS created
+			FROM generate_series(@from::date, @to::date, interval '1 day') AS day
+		) AS partitions;
+	`
+	args := pgx.NamedArgs{
+		"from": from.UTC().Format(time.DateOnly),
+		"to":   to.UTC().Format(time.DateOnly),
+	}
+	var created int
+	err := s.pool.QueryRow(ctx, query, args).Scan(&created)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create geolocation partitions: %v", err)
+	}
+	return created, nil
+}
+
+func (s *RepoImpl) ListenToGeolocationInserted(ctx context.Context, orgID string, handler func(*DeviceGeolocation) error, resync func() error) error {
+	// every caller shares the same LISTEN connection
+	return s.listener.listen(ctx, orgID, handler, resync)
+}
+
+func (s *RepoImpl) ListenerStats() ListenerStats {
+	return s.listener.stats()
 }
@@ -0,0 +1,30 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{name: "not found", err: fmt.Errorf("device a: %w", ErrNotFound)},
+		{name: "anything else", err: errors.New("invalid latitude")},
+		{name: "unique violation", err: &pgconn.PgError{Code: "23505"}},
+		{name: "serialization failure", err: fmt.Errorf("failed to copy multi geolocation: %w", &pgconn.PgError{Code: "40001"}), transient: true},
+		{name: "admin shutdown", err: &pgconn.PgError{Code: "57P01"}, transient: true},
+		{name: "connection refused", err: fmt.Errorf("failed to connect: %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")}), transient: true},
+	}
+	for _, test := range tests {
+		if got := IsTransient(test.err); got != test.transient {
+			t.Errorf("%v: got %v, want %v", test.name, got, test.transient)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"fmt"
+	"math"
+)
+
+// Telemetry is optional, so clients that only know about latitude and longitude are unaffected
+type Telemetry struct {
+	// meters above mean sea level
+	AltitudeMSL *float64 `json:"altitude_msl,omitempty" db:"altitude_msl"`
+	// meters above ground level
+	AltitudeAGL *float64 `json:"altitude_agl,omitempty" db:"altitude_agl"`
+	// degrees clockwise from true north, in [0, 360)
+	Heading *float64 `json:"heading,omitempty" db:"heading"`
+	// meters per second
+	GroundSpeed *float64 `json:"ground_speed,omitempty" db:"ground_speed"`
+	// meters per second, positive when climbing
+	VerticalSpeed *float64 `json:"vertical_speed,omitempty" db:"vertical_speed"`
+	// meters
+	HorizontalAccuracy *float64 `json:"horizontal_accuracy,omitempty" db:"horizontal_accuracy"`
+	BatteryPercent     *float64 `json:"battery_percent,omitempty" db:"battery_percent"`
+}
+
+func finite(value float64) bool {
+	return !math.IsNaN(value) && !math.IsInf(value, 0)
+}
+
+func (t Telemetry) Validate() error {
+	for name, value := range t.fields() {
+		if value != nil && !finite(*value) {
+			return fmt.Errorf("%v is not a number: %v", name, *value)
+		}
+	}
+	if t.Heading != nil && (*t.Heading < 0 || *t.Heading >= 360) {
+		return fmt.Errorf("heading out of range: %v", *t.Heading)
+	}
+	if t.GroundSpeed != nil && *t.GroundSpeed < 0 {
+		return fmt.Errorf("ground_speed out of range: %v", *t.GroundSpeed)
+	}
+	if t.HorizontalAccuracy != nil && *t.HorizontalAccuracy < 0 {
+		return fmt.Errorf("horizontal_accuracy out of range: %v", *t.HorizontalAccuracy)
+	}
+	if t.BatteryPercent != nil && (*t.BatteryPercent < 0 || *t.BatteryPercent > 100) {
+		return fmt.Errorf("battery_percent out of range: %v", *t.BatteryPercent)
+	}
+	return nil
+}
+
+func (t Telemetry) fields() map[string]*float64 {
+	return map[string]*float64{
+		"altitude_msl":        t.AltitudeMSL,
+		"altitude_agl":        t.AltitudeAGL,
+		"heading":             t.Heading,
+		"ground_speed":        t.GroundSpeed,
+		"vertical_speed":      t.VerticalSpeed,
+		"horizontal_accuracy": t.HorizontalAccuracy,
+		"battery_percent":     t.BatteryPercent,
+	}
+}
+
+// values are in the same order as telemetryColumns
+func (t Telemetry) values() []*float64 {
+	return []*float64{t.AltitudeMSL, t.AltitudeAGL, t.Heading, t.GroundSpeed, t.VerticalSpeed, t.HorizontalAccuracy, t.BatteryPercent}
+}
+
+func (t Telemetry) Equal(other Telemetry) bool {
+	a := t.values()
+	b := other.values()
+	for i := range a {
+		if (a[i] == nil) != (b[i] == nil) || (a[i] != nil && *a[i] != *b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Copy doesn't share values with t, since callers such as the simulator reuse what they pass in
+func (t Telemetry) Copy() Telemetry {
+	copyValue := func(value *float64) *float64 {
+		if value == nil {
+			return nil
+		}
+		copied := *value
+		return &copied
+	}
+	return Telemetry{
+		AltitudeMSL:        copyValue(t.AltitudeMSL),
+		AltitudeAGL:        copyValue(t.AltitudeAGL),
+		Heading:            copyValue(t.Heading),
+		GroundSpeed:        copyValue(t.GroundSpeed),
+		VerticalSpeed:      copyValue(t.VerticalSpeed),
+		HorizontalAccuracy: copyValue(t.HorizontalAccuracy),
+		BatteryPercent:     copyValue(t.BatteryPercent),
+	}
+}
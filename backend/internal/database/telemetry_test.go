@@ -0,0 +1,59 @@
+package database
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTelemetryValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		telemetry Telemetry
+		valid     bool
+	}{
+		{name: "nothing reported", telemetry: Telemetry{}, valid: true},
+		{
+			name: "everything in range",
+			telemetry: Telemetry{
+				AltitudeMSL:        float(-10),
+				AltitudeAGL:        float(30),
+				Heading:            float(359.9),
+				GroundSpeed:        float(0),
+				VerticalSpeed:      float(-2),
+				HorizontalAccuracy: float(0),
+				BatteryPercent:     float(100),
+			},
+			valid: true,
+		},
+		{name: "heading of 360", telemetry: Telemetry{Heading: float(360)}},
+		{name: "negative heading", telemetry: Telemetry{Heading: float(-1)}},
+		{name: "negative ground speed", telemetry: Telemetry{GroundSpeed: float(-1)}},
+		{name: "negative accuracy", telemetry: Telemetry{HorizontalAccuracy: float(-1)}},
+		{name: "battery over 100", telemetry: Telemetry{BatteryPercent: float(101)}},
+		{name: "not a number", telemetry: Telemetry{AltitudeMSL: float(math.NaN())}},
+		{name: "infinite", telemetry: Telemetry{VerticalSpeed: float(math.Inf(1))}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.telemetry.Validate()
+			if test.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestTelemetryCopy(t *testing.T) {
+	original := Telemetry{Heading: float(90)}
+	copied := original.Copy()
+	*original.Heading = 180
+	if *copied.Heading != 90 {
+		t.Errorf("copy shares values with the original")
+	}
+	if !copied.Equal(Telemetry{Heading: float(90)}) {
+		t.Errorf("copy isn't equal to what was copied")
+	}
+}
@@ -0,0 +1,97 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/database"
+	"github.com/NinjaPerson24119/MapProject/backend/internal/filters"
+)
+
+type Format string
+
+const (
+	// GPX 1.1, for flight review tools
+	GPX Format = "gpx"
+	// KML 2.2 with Google's gx:Track extension, for Google Earth
+	KML Format = "kml"
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch Format(s) {
+	case GPX, KML:
+		return Format(s), nil
+	}
+	return "", fmt.Errorf("unknown export format: %v", s)
+}
+
+func (f Format) ContentType() string {
+	if f == KML {
+		return "application/vnd.google-earth.kml+xml"
+	}
+	return "application/gpx+xml"
+}
+
+func (f Format) Extension() string {
+	return "." + string(f)
+}
+
+// TrackWriter writes one track per device. points for the current track may be written in any number of calls,
+// and are written out before each call returns, so nothing is held back in memory
+type TrackWriter interface {
+	BeginTrack(device *database.Device) error
+	WritePoints(geolocations []*database.DeviceGeolocation) error
+	EndTrack() error
+	// Close ends the document. the underlying writer is left open
+	Close() error
+}
+
+// coordinate is written out in full, since xsd:decimal, which GPX uses, doesn't allow exponents
+func coordinate(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// WriteTracks pages through each device's history in the range, oldest first, and calls flush after every page
+func WriteTracks(ctx context.Context, repo database.Repo, orgID string, devices []*database.Device, history filters.HistoryOptions, w TrackWriter, flush func()) error {
+	for _, device := range devices {
+		if err := w.BeginTrack(device); err != nil {
+			return err
+		}
+		cursor := ""
+		for {
+			geolocations, nextCursor, err := repo.ListGeolocationHistory(ctx, orgID, device.DeviceID, history, filters.PageOptions{
+				PageSize: filters.MaxPageSize,
+				Cursor:   cursor,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to get history for device %v: %v", device.DeviceID, err)
+			}
+			if err := w.WritePoints(geolocations); err != nil {
+				return err
+			}
+			flush()
+			if nextCursor == "" {
+				break
+			}
+			cursor = nextCursor
+		}
+		if err := w.EndTrack(); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	flush()
+	return nil
+}
+
+// NewTrackWriter starts a document in the format
+func NewTrackWriter(format Format, w io.Writer) (TrackWriter, error) {
+	if format == KML {
+		return NewKMLWriter(w)
+	}
+	return NewGPXWriter(w)
+}
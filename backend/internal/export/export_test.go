@@ -0,0 +1,168 @@
+package export
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/database"
+)
+
+func float(value float64) *float64 {
+	return &value
+}
+
+var start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// writeTracks writes a track for each device, with each of its pages written in a separate call
+func writeTracks(t *testing.T, w TrackWriter, tracks map[*database.Device][][]*database.DeviceGeolocation, order []*database.Device) {
+	t.Helper()
+	for _, device := range order {
+		if err := w.BeginTrack(device); err != nil {
+			t.Fatalf("failed to begin track: %v", err)
+		}
+		for _, page := range tracks[device] {
+			if err := w.WritePoints(page); err != nil {
+				t.Fatalf("failed to write points: %v", err)
+			}
+		}
+		if err := w.EndTrack(); err != nil {
+			t.Fatalf("failed to end track: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+}
+
+func TestGPXWriter(t *testing.T) {
+	alpha := &database.Device{DeviceID: "a", Name: "alpha & co"}
+	empty := &database.Device{DeviceID: "b", Name: "empty"}
+	tracks := map[*database.Device][][]*database.DeviceGeolocation{
+		alpha: {
+			{{EventTime: start, Latitude: 53.5, Longitude: -113.5, Telemetry: database.Telemetry{AltitudeMSL: float(650)}}},
+			{{EventTime: start.Add(time.Second), Latitude: 0.0000001, Longitude: 1e-7}},
+		},
+	}
+	buffer := &bytes.Buffer{}
+	w, err := NewGPXWriter(buffer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	writeTracks(t, w, tracks, []*database.Device{alpha, empty})
+
+	var gpx struct {
+		XMLName xml.Name `xml:"http://www.topografix.com/GPX/1/1 gpx"`
+		Version string   `xml:"version,attr"`
+		Tracks  []struct {
+			Name   string `xml:"name"`
+			Desc   string `xml:"desc"`
+			Points []struct {
+				Latitude  string   `xml:"lat,attr"`
+				Longitude string   `xml:"lon,attr"`
+				Elevation *float64 `xml:"ele"`
+				Time      string   `xml:"time"`
+			} `xml:"trkseg>trkpt"`
+		} `xml:"trk"`
+	}
+	if err := xml.Unmarshal(buffer.Bytes(), &gpx); err != nil {
+		t.Fatalf("wrote invalid xml: %v\n%s", err, buffer)
+	}
+	if gpx.Version != "1.1" || len(gpx.Tracks) != 2 {
+		t.Fatalf("got version %q with %d tracks", gpx.Version, len(gpx.Tracks))
+	}
+	track := gpx.Tracks[0]
+	if track.Name != "alpha & co" || track.Desc != "a" || len(track.Points) != 2 {
+		t.Fatalf("got track %q, %q with %d points", track.Name, track.Desc, len(track.Points))
+	}
+	first := track.Points[0]
+	if first.Latitude != "53.5" || first.Longitude != "-113.5" || first.Elevation == nil || *first.Elevation != 650 || first.Time != "2024-01-01T00:00:00Z" {
+		t.Errorf("got point %+v", first)
+	}
+	// xsd:decimal doesn't allow exponents
+	second := track.Points[1]
+	if second.Latitude != "0.0000001" || second.Longitude != "0.0000001" || second.Elevation != nil {
+		t.Errorf("got point %+v", second)
+	}
+	if len(gpx.Tracks[1].Points) != 0 {
+		t.Errorf("a device without history got %d points", len(gpx.Tracks[1].Points))
+	}
+}
+
+func TestKMLWriter(t *testing.T) {
+	alpha := &database.Device{DeviceID: "a", Name: "alpha"}
+	tracks := map[*database.Device][][]*database.DeviceGeolocation{
+		alpha: {
+			{
+				{EventTime: start, Latitude: 53.5, Longitude: -113.5, Telemetry: database.Telemetry{AltitudeMSL: float(650)}},
+				{EventTime: start.Add(time.Second), Latitude: 53.6, Longitude: -113.6, Telemetry: database.Telemetry{AltitudeMSL: float(651)}},
+			},
+			{},
+			// one point without an altitude clamps its page to the ground
+			{
+				{EventTime: start.Add(2 * time.Second), Latitude: 53.7, Longitude: -113.7, Telemetry: database.Telemetry{AltitudeMSL: float(652)}},
+				{EventTime: start.Add(3 * time.Second), Latitude: 53.8, Longitude: -113.8},
+			},
+		},
+	}
+	buffer := &bytes.Buffer{}
+	w, err := NewKMLWriter(buffer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	writeTracks(t, w, tracks, []*database.Device{alpha})
+
+	var kml struct {
+		XMLName    xml.Name `xml:"http://www.opengis.net/kml/2.2 kml"`
+		Placemarks []struct {
+			Name   string `xml:"name"`
+			Tracks []struct {
+				AltitudeMode string   `xml:"altitudeMode"`
+				When         []string `xml:"when"`
+				Coord        []string `xml:"http://www.google.com/kml/ext/2.2 coord"`
+			} `xml:"http://www.google.com/kml/ext/2.2 MultiTrack>Track"`
+		} `xml:"Document>Placemark"`
+	}
+	if err := xml.Unmarshal(buffer.Bytes(), &kml); err != nil {
+		t.Fatalf("wrote invalid xml: %v\n%s", err, buffer)
+	}
+	if len(kml.Placemarks) != 1 || kml.Placemarks[0].Name != "alpha" {
+		t.Fatalf("got placemarks %+v", kml.Placemarks)
+	}
+	// empty pages don't make empty tracks
+	got := kml.Placemarks[0].Tracks
+	if len(got) != 2 {
+		t.Fatalf("got %d tracks, want 2\n%s", len(got), buffer)
+	}
+	tests := []struct {
+		altitudeMode string
+		when         []string
+		coord        []string
+	}{
+		{
+			altitudeMode: "absolute",
+			when:         []string{"2024-01-01T00:00:00Z", "2024-01-01T00:00:01Z"},
+			coord:        []string{"-113.5 53.5 650", "-113.6 53.6 651"},
+		},
+		{
+			altitudeMode: "clampToGround",
+			when:         []string{"2024-01-01T00:00:02Z", "2024-01-01T00:00:03Z"},
+			coord:        []string{"-113.7 53.7 0", "-113.8 53.8 0"},
+		},
+	}
+	for i, test := range tests {
+		track := got[i]
+		if track.AltitudeMode != test.altitudeMode {
+			t.Errorf("track %d: got altitude mode %v, want %v", i, track.AltitudeMode, test.altitudeMode)
+		}
+		if len(track.When) != len(test.when) || len(track.Coord) != len(test.coord) {
+			t.Fatalf("track %d: got %d times and %d coordinates", i, len(track.When), len(track.Coord))
+		}
+		for j := range test.when {
+			if track.When[j] != test.when[j] || track.Coord[j] != test.coord[j] {
+				t.Errorf("track %d point %d: got %v at %v, want %v at %v", i, j, track.Coord[j], track.When[j], test.coord[j], test.when[j])
+			}
+		}
+	}
+}
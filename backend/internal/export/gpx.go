@@ -0,0 +1,82 @@
+package export
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/database"
+)
+
+type gpxPoint struct {
+	Latitude  string `xml:"lat,attr"`
+	Longitude string `xml:"lon,attr"`
+	// meters above mean sea level
+	Elevation *float64 `xml:"ele,omitempty"`
+	Time      string   `xml:"time"`
+}
+
+type gpxWriter struct {
+	encoder *xml.Encoder
+}
+
+func NewGPXWriter(w io.Writer) (TrackWriter, error) {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return nil, err
+	}
+	encoder := xml.NewEncoder(w)
+	err := encoder.EncodeToken(xml.StartElement{
+		Name: xml.Name{Local: "gpx"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "xmlns"}, Value: "http://www.topografix.com/GPX/1/1"},
+			{Name: xml.Name{Local: "version"}, Value: "1.1"},
+			{Name: xml.Name{Local: "creator"}, Value: "MapProject"},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &gpxWriter{encoder: encoder}, nil
+}
+
+func (g *gpxWriter) BeginTrack(device *database.Device) error {
+	if err := g.encoder.EncodeToken(xml.StartElement{Name: xml.Name{Local: "trk"}}); err != nil {
+		return err
+	}
+	if err := g.encoder.EncodeElement(device.Name, xml.StartElement{Name: xml.Name{Local: "name"}}); err != nil {
+		return err
+	}
+	if err := g.encoder.EncodeElement(device.DeviceID, xml.StartElement{Name: xml.Name{Local: "desc"}}); err != nil {
+		return err
+	}
+	return g.encoder.EncodeToken(xml.StartElement{Name: xml.Name{Local: "trkseg"}})
+}
+
+func (g *gpxWriter) WritePoints(geolocations []*database.DeviceGeolocation) error {
+	for _, geolocation := range geolocations {
+		point := gpxPoint{
+			Latitude:  coordinate(geolocation.Latitude),
+			Longitude: coordinate(geolocation.Longitude),
+			Elevation: geolocation.AltitudeMSL,
+			Time:      geolocation.EventTime.UTC().Format(time.RFC3339Nano),
+		}
+		if err := g.encoder.EncodeElement(point, xml.StartElement{Name: xml.Name{Local: "trkpt"}}); err != nil {
+			return err
+		}
+	}
+	return g.encoder.Flush()
+}
+
+func (g *gpxWriter) EndTrack() error {
+	if err := g.encoder.EncodeToken(xml.EndElement{Name: xml.Name{Local: "trkseg"}}); err != nil {
+		return err
+	}
+	return g.encoder.EncodeToken(xml.EndElement{Name: xml.Name{Local: "trk"}})
+}
+
+func (g *gpxWriter) Close() error {
+	if err := g.encoder.EncodeToken(xml.EndElement{Name: xml.Name{Local: "gpx"}}); err != nil {
+		return err
+	}
+	return g.encoder.Flush()
+}
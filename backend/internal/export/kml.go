@@ -0,0 +1,106 @@
+package export
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/database"
+)
+
+// a gx:Track lists every time before every position, so a track can't be streamed point by point.
+// instead each page of points is its own gx:Track, joined up by a gx:MultiTrack
+type kmlTrack struct {
+	AltitudeMode string   `xml:"altitudeMode"`
+	When         []string `xml:"when"`
+	Coord        []string `xml:"gx:coord"`
+}
+
+type kmlWriter struct {
+	encoder *xml.Encoder
+}
+
+func NewKMLWriter(w io.Writer) (TrackWriter, error) {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return nil, err
+	}
+	encoder := xml.NewEncoder(w)
+	err := encoder.EncodeToken(xml.StartElement{
+		Name: xml.Name{Local: "kml"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "xmlns"}, Value: "http://www.opengis.net/kml/2.2"},
+			{Name: xml.Name{Local: "xmlns:gx"}, Value: "http://www.google.com/kml/ext/2.2"},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := encoder.EncodeToken(xml.StartElement{Name: xml.Name{Local: "Document"}}); err != nil {
+		return nil, err
+	}
+	return &kmlWriter{encoder: encoder}, nil
+}
+
+func (k *kmlWriter) BeginTrack(device *database.Device) error {
+	if err := k.encoder.EncodeToken(xml.StartElement{Name: xml.Name{Local: "Placemark"}}); err != nil {
+		return err
+	}
+	if err := k.encoder.EncodeElement(device.Name, xml.StartElement{Name: xml.Name{Local: "name"}}); err != nil {
+		return err
+	}
+	if err := k.encoder.EncodeElement(device.DeviceID, xml.StartElement{Name: xml.Name{Local: "description"}}); err != nil {
+		return err
+	}
+	if err := k.encoder.EncodeToken(xml.StartElement{Name: xml.Name{Local: "gx:MultiTrack"}}); err != nil {
+		return err
+	}
+	// draws the pages as one line instead of leaving gaps between them
+	return k.encoder.EncodeElement(1, xml.StartElement{Name: xml.Name{Local: "gx:interpolate"}})
+}
+
+// WritePoints uses altitudes above sea level when every point has one, and otherwise clamps the page to the ground
+func (k *kmlWriter) WritePoints(geolocations []*database.DeviceGeolocation) error {
+	if len(geolocations) == 0 {
+		return nil
+	}
+	track := kmlTrack{
+		AltitudeMode: "absolute",
+		When:         make([]string, len(geolocations)),
+		Coord:        make([]string, len(geolocations)),
+	}
+	for _, geolocation := range geolocations {
+		if geolocation.AltitudeMSL == nil {
+			track.AltitudeMode = "clampToGround"
+		}
+	}
+	for i, geolocation := range geolocations {
+		altitude := 0.0
+		if track.AltitudeMode == "absolute" {
+			altitude = *geolocation.AltitudeMSL
+		}
+		track.When[i] = geolocation.EventTime.UTC().Format(time.RFC3339Nano)
+		track.Coord[i] = fmt.Sprintf("%s %s %s", coordinate(geolocation.Longitude), coordinate(geolocation.Latitude), coordinate(altitude))
+	}
+	if err := k.encoder.EncodeElement(track, xml.StartElement{Name: xml.Name{Local: "gx:Track"}}); err != nil {
+		return err
+	}
+	return k.encoder.Flush()
+}
+
+func (k *kmlWriter) EndTrack() error {
+	if err := k.encoder.EncodeToken(xml.EndElement{Name: xml.Name{Local: "gx:MultiTrack"}}); err != nil {
+		return err
+	}
+	return k.encoder.EncodeToken(xml.EndElement{Name: xml.Name{Local: "Placemark"}})
+}
+
+func (k *kmlWriter) Close() error {
+	if err := k.encoder.EncodeToken(xml.EndElement{Name: xml.Name{Local: "Document"}}); err != nil {
+		return err
+	}
+	if err := k.encoder.EncodeToken(xml.EndElement{Name: xml.Name{Local: "kml"}}); err != nil {
+		return err
+	}
+	return k.encoder.Flush()
+}
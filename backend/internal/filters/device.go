@@ -0,0 +1,80 @@
+package filters
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// DeviceFilter keeps devices that match everything that is set
+type DeviceFilter struct {
+	DeviceType string `json:"device_type"`
+	Model      string `json:"model"`
+	// devices must have every one of these tags
+	Tags []string `json:"tags"`
+	// devices must have each of these attributes with an equal value, like jsonb containment
+	Attributes map[string]any `json:"attributes"`
+}
+
+func (f DeviceFilter) Validate() error {
+	for _, tag := range f.Tags {
+		if tag == "" {
+			return fmt.Errorf("invalid empty tag")
+		}
+	}
+	for key, value := range f.Attributes {
+		// nested values would match differently here than in postgres, so only scalars can be filtered on
+		switch value.(type) {
+		case string, float64, bool, nil:
+		default:
+			return fmt.Errorf("attribute %v can only be filtered by a string, number, boolean or null", key)
+		}
+	}
+	return nil
+}
+
+func (f DeviceFilter) IsEmpty() bool {
+	return f.DeviceType == "" && f.Model == "" && len(f.Tags) == 0 && len(f.Attributes) == 0
+}
+
+func (f DeviceFilter) Matches(deviceType string, model string, tags []string, attributes map[string]any) bool {
+	if f.DeviceType != "" && f.DeviceType != deviceType {
+		return false
+	}
+	if f.Model != "" && f.Model != model {
+		return false
+	}
+	for _, tag := range f.Tags {
+		if !contains(tags, tag) {
+			return false
+		}
+	}
+	for key, value := range f.Attributes {
+		actual, ok := attributes[key]
+		if !ok || !sameJSON(actual, value) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// sameJSON compares values the way they would be stored, so 1 and 1.0 are equal
+func sameJSON(a any, b any) bool {
+	aJSON, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	bJSON, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return string(aJSON) == string(bJSON)
+}
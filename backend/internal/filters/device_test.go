@@ -0,0 +1,69 @@
+package filters
+
+import "testing"
+
+func TestDeviceFilterMatches(t *testing.T) {
+	deviceType := "quadcopter"
+	model := "m300"
+	tags := []string{"survey", "north"}
+	attributes := map[string]any{"serial": "A1", "payload_kg": 2.0, "armed": true, "operator": nil}
+
+	tests := []struct {
+		name   string
+		filter DeviceFilter
+		want   bool
+	}{
+		{name: "empty filter", filter: DeviceFilter{}, want: true},
+		{name: "type", filter: DeviceFilter{DeviceType: "quadcopter"}, want: true},
+		{name: "other type", filter: DeviceFilter{DeviceType: "fixed_wing"}, want: false},
+		{name: "model", filter: DeviceFilter{Model: "m300"}, want: true},
+		{name: "other model", filter: DeviceFilter{Model: "m30"}, want: false},
+		{name: "one tag", filter: DeviceFilter{Tags: []string{"north"}}, want: true},
+		{name: "every tag", filter: DeviceFilter{Tags: []string{"north", "survey"}}, want: true},
+		{name: "a missing tag", filter: DeviceFilter{Tags: []string{"north", "south"}}, want: false},
+		{name: "string attribute", filter: DeviceFilter{Attributes: map[string]any{"serial": "A1"}}, want: true},
+		{name: "integer and float are the same number", filter: DeviceFilter{Attributes: map[string]any{"payload_kg": 2}}, want: true},
+		{name: "boolean attribute", filter: DeviceFilter{Attributes: map[string]any{"armed": true}}, want: true},
+		{name: "null attribute", filter: DeviceFilter{Attributes: map[string]any{"operator": nil}}, want: true},
+		{name: "different value", filter: DeviceFilter{Attributes: map[string]any{"serial": "B2"}}, want: false},
+		{name: "number isn't a string", filter: DeviceFilter{Attributes: map[string]any{"payload_kg": "2"}}, want: false},
+		{name: "missing attribute", filter: DeviceFilter{Attributes: map[string]any{"color": "red"}}, want: false},
+		{
+			name:   "everything",
+			filter: DeviceFilter{DeviceType: "quadcopter", Model: "m300", Tags: []string{"survey"}, Attributes: map[string]any{"serial": "A1"}},
+			want:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.filter.Matches(deviceType, model, tags, attributes); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDeviceFilterValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter DeviceFilter
+		valid  bool
+	}{
+		{name: "empty filter", filter: DeviceFilter{}, valid: true},
+		{name: "scalar attributes", filter: DeviceFilter{Attributes: map[string]any{"a": "x", "b": 1.0, "c": false, "d": nil}}, valid: true},
+		{name: "empty tag", filter: DeviceFilter{Tags: []string{""}}},
+		{name: "nested attribute", filter: DeviceFilter{Attributes: map[string]any{"a": map[string]any{"b": 1.0}}}},
+		{name: "list attribute", filter: DeviceFilter{Attributes: map[string]any{"a": []any{1.0}}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.filter.Validate()
+			if test.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package filters
+
+import (
+	"fmt"
+	"time"
+)
+
+type HistoryOptions struct {
+	// event times in [StartTime, EndTime)
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	// evenly subsamples the whole range down to at most this many points. zero returns every point
+	MaxPoints int `json:"max_points"`
+}
+
+func (h HistoryOptions) Validate() error {
+	if h.StartTime.IsZero() {
+		return fmt.Errorf("missing start_time")
+	}
+	if h.EndTime.IsZero() {
+		return fmt.Errorf("missing end_time")
+	}
+	if !h.StartTime.Before(h.EndTime) {
+		return fmt.Errorf("start_time must be before end_time")
+	}
+	if h.MaxPoints < 0 {
+		return fmt.Errorf("invalid max_points")
+	}
+	return nil
+}
+
+// SubsampleStep is how many points to advance between kept points, so that at most MaxPoints of total are kept
+func (h HistoryOptions) SubsampleStep(total int) int {
+	if h.MaxPoints == 0 || total <= h.MaxPoints {
+		return 1
+	}
+	return (total + h.MaxPoints - 1) / h.MaxPoints
+}
+
+// history pages are ordered by event time, so their cursors carry one
+func EncodeTimeCursor(t time.Time) string {
+	return EncodeCursor(t.UTC().Format(time.RFC3339Nano))
+}
+
+// DecodeTimeCursor returns nil when paging should start from the beginning
+func DecodeTimeCursor(cursor string) (*time.Time, error) {
+	key, err := DecodeCursor(cursor)
+	if err != nil || key == "" {
+		return nil, err
+	}
+	t, err := time.Parse(time.RFC3339Nano, key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &t, nil
+}
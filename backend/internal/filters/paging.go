@@ -1,6 +1,83 @@
 package filters
 
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+)
+
+const MaxPageSize = 1000
+
+// PageOptions supports two forms of paging.
+// page and page_size is the original OFFSET/LIMIT form, kept for existing clients.
+// cursor and page_size is keyset paging: leave page out, start with no cursor, then pass back each response's next_cursor
 type PageOptions struct {
-	Page     int `json:"page"`
-	PageSize int `json:"page_size"`
+	Page     int    `json:"page"`
+	PageSize int    `json:"page_size"`
+	Cursor   string `json:"cursor"`
+}
+
+func (p PageOptions) UsesCursor() bool {
+	return p.Page == 0
+}
+
+func (p PageOptions) Validate() error {
+	if p.PageSize < 1 || p.PageSize > MaxPageSize {
+		return fmt.Errorf("invalid page_size")
+	}
+	if p.Page < 0 {
+		return fmt.Errorf("invalid page")
+	}
+	if p.Page > 0 && p.Cursor != "" {
+		return fmt.Errorf("page and cursor can't be used together")
+	}
+	if _, err := DecodeCursor(p.Cursor); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Offset is only meaningful for page based paging
+func (p PageOptions) Offset() int {
+	if p.UsesCursor() {
+		return 0
+	}
+	return (p.Page - 1) * p.PageSize
+}
+
+// EncodeCursor wraps the sort key of the last row on a page, so clients don't depend on what it is
+func EncodeCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// DecodeCursor returns the sort key to continue after. an empty cursor means start from the beginning
+func DecodeCursor(cursor string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", fmt.Errorf("invalid cursor")
+	}
+	return string(key), nil
+}
+
+var deviceIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// device listings are ordered by device id, so their cursors carry one.
+// DecodeDeviceCursor returns nil when paging should start from the beginning
+func DecodeDeviceCursor(cursor string) (*string, error) {
+	key, err := DecodeCursor(cursor)
+	if err != nil || key == "" {
+		return nil, err
+	}
+	if !deviceIDPattern.MatchString(key) {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &key, nil
+}
+
+// NextCursor returns the cursor for the page after one that returned count rows, or "" if that was the last page
+func (p PageOptions) NextCursor(count int, lastKey string) string {
+	if count < p.PageSize {
+		return ""
+	}
+	return EncodeCursor(lastKey)
 }
@@ -0,0 +1,83 @@
+package filters
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDecodeDeviceCursor(t *testing.T) {
+	deviceID := "6f1c2b9e-3f4a-4c1d-9a7b-2e5d8c0f1a23"
+	tests := []struct {
+		name   string
+		cursor string
+		want   string
+		valid  bool
+	}{
+		{name: "no cursor", cursor: "", valid: true},
+		{name: "device id", cursor: EncodeCursor(deviceID), want: deviceID, valid: true},
+		{name: "not base64", cursor: "not base64!"},
+		{name: "not a device id", cursor: EncodeCursor("abc")},
+		{name: "time cursor", cursor: EncodeTimeCursor(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, err := DecodeDeviceCursor(test.cursor)
+			if !test.valid {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := ""
+			if key != nil {
+				got = *key
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeTimeCursor(t *testing.T) {
+	eventTime := time.Date(2024, 1, 1, 0, 0, 0, 123456789, time.UTC)
+	decoded, err := DecodeTimeCursor(EncodeTimeCursor(eventTime))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !decoded.Equal(eventTime) {
+		t.Errorf("got %v, want %v", decoded, eventTime)
+	}
+	if _, err := DecodeTimeCursor(EncodeCursor("6f1c2b9e-3f4a-4c1d-9a7b-2e5d8c0f1a23")); err == nil {
+		t.Errorf("expected an error for a device cursor")
+	}
+}
+
+func TestPageOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		paging PageOptions
+		valid  bool
+	}{
+		{name: "page", paging: PageOptions{Page: 1, PageSize: 10}, valid: true},
+		{name: "cursor", paging: PageOptions{PageSize: MaxPageSize, Cursor: EncodeCursor("x")}, valid: true},
+		{name: "no page size", paging: PageOptions{Page: 1}},
+		{name: "page too big", paging: PageOptions{Page: 1, PageSize: MaxPageSize + 1}},
+		{name: "negative page", paging: PageOptions{Page: -1, PageSize: 10}},
+		{name: "page and cursor", paging: PageOptions{Page: 1, PageSize: 10, Cursor: EncodeCursor("x")}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.paging.Validate()
+			if test.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
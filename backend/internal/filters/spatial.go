@@ -0,0 +1,129 @@
+package filters
+
+import (
+	"fmt"
+	"math"
+)
+
+// mean earth radius, which keeps haversine distances within ~0.5% of the ellipsoid
+const EarthRadiusMeters = 6371008.8
+
+type Circle struct {
+	Latitude     float64 `json:"latitude"`
+	Longitude    float64 `json:"longitude"`
+	RadiusMeters float64 `json:"radius_meters"`
+}
+
+// BoundingBox is axis aligned in degrees. a box with MinLongitude > MaxLongitude crosses the antimeridian
+type BoundingBox struct {
+	MinLatitude  float64 `json:"min_latitude"`
+	MinLongitude float64 `json:"min_longitude"`
+	MaxLatitude  float64 `json:"max_latitude"`
+	MaxLongitude float64 `json:"max_longitude"`
+}
+
+// SpatialFilter keeps geolocations inside every shape that is set
+type SpatialFilter struct {
+	Circle      *Circle      `json:"circle"`
+	BoundingBox *BoundingBox `json:"bounding_box"`
+}
+
+func validLatitude(latitude float64) bool {
+	return latitude >= -90 && latitude <= 90
+}
+
+func validLongitude(longitude float64) bool {
+	return longitude >= -180 && longitude <= 180
+}
+
+func (f SpatialFilter) Validate() error {
+	if f.Circle != nil {
+		if !validLatitude(f.Circle.Latitude) || !validLongitude(f.Circle.Longitude) {
+			return fmt.Errorf("invalid circle center")
+		}
+		if f.Circle.RadiusMeters <= 0 || math.IsInf(f.Circle.RadiusMeters, 0) || math.IsNaN(f.Circle.RadiusMeters) {
+			return fmt.Errorf("invalid circle radius_meters")
+		}
+	}
+	if f.BoundingBox != nil {
+		b := f.BoundingBox
+		if !validLatitude(b.MinLatitude) || !validLatitude(b.MaxLatitude) || b.MinLatitude > b.MaxLatitude {
+			return fmt.Errorf("invalid bounding_box latitudes")
+		}
+		if !validLongitude(b.MinLongitude) || !validLongitude(b.MaxLongitude) {
+			return fmt.Errorf("invalid bounding_box longitudes")
+		}
+	}
+	return nil
+}
+
+func (f SpatialFilter) IsEmpty() bool {
+	return f.Circle == nil && f.BoundingBox == nil
+}
+
+func (f SpatialFilter) Contains(latitude float64, longitude float64) bool {
+	if f.BoundingBox != nil && !f.BoundingBox.Contains(latitude, longitude) {
+		return false
+	}
+	if f.Circle != nil && !f.Circle.Contains(latitude, longitude) {
+		return false
+	}
+	return true
+}
+
+func (b BoundingBox) CrossesAntimeridian() bool {
+	return b.MinLongitude > b.MaxLongitude
+}
+
+func (b BoundingBox) Contains(latitude float64, longitude float64) bool {
+	if latitude < b.MinLatitude || latitude > b.MaxLatitude {
+		return false
+	}
+	if b.CrossesAntimeridian() {
+		return longitude >= b.MinLongitude || longitude <= b.MaxLongitude
+	}
+	return longitude >= b.MinLongitude && longitude <= b.MaxLongitude
+}
+
+func (c Circle) Contains(latitude float64, longitude float64) bool {
+	return HaversineMeters(c.Latitude, c.Longitude, latitude, longitude) <= c.RadiusMeters
+}
+
+// Bounds is the smallest bounding box around the circle, which lets an index narrow things down before exact distances.
+// it widens to every longitude when the circle covers a pole
+func (c Circle) Bounds() BoundingBox {
+	angular := c.RadiusMeters / EarthRadiusMeters
+	deltaLatitude := angular * 180 / math.Pi
+	bounds := BoundingBox{
+		MinLatitude:  c.Latitude - deltaLatitude,
+		MaxLatitude:  c.Latitude + deltaLatitude,
+		MinLongitude: -180,
+		MaxLongitude: 180,
+	}
+	if bounds.MinLatitude <= -90 || bounds.MaxLatitude >= 90 || angular >= math.Pi/2 {
+		bounds.MinLatitude = math.Max(bounds.MinLatitude, -90)
+		bounds.MaxLatitude = math.Min(bounds.MaxLatitude, 90)
+		return bounds
+	}
+
+	deltaLongitude := math.Asin(math.Sin(angular)/math.Cos(c.Latitude*math.Pi/180)) * 180 / math.Pi
+	bounds.MinLongitude = c.Longitude - deltaLongitude
+	bounds.MaxLongitude = c.Longitude + deltaLongitude
+	if bounds.MinLongitude < -180 {
+		bounds.MinLongitude += 360
+	}
+	if bounds.MaxLongitude > 180 {
+		bounds.MaxLongitude -= 360
+	}
+	return bounds
+}
+
+// HaversineMeters is the great circle distance between two points
+func HaversineMeters(latitude1 float64, longitude1 float64, latitude2 float64, longitude2 float64) float64 {
+	toRadians := math.Pi / 180
+	deltaLatitude := (latitude2 - latitude1) * toRadians
+	deltaLongitude := (longitude2 - longitude1) * toRadians
+	a := math.Pow(math.Sin(deltaLatitude/2), 2) +
+		math.Cos(latitude1*toRadians)*math.Cos(latitude2*toRadians)*math.Pow(math.Sin(deltaLongitude/2), 2)
+	return 2 * EarthRadiusMeters * math.Asin(math.Min(1, math.Sqrt(a)))
+}
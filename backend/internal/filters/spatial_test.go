@@ -0,0 +1,134 @@
+package filters
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSpatialFilterValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  SpatialFilter
+		wantErr bool
+	}{
+		{name: "empty", filter: SpatialFilter{}},
+		{name: "circle", filter: SpatialFilter{Circle: &Circle{Latitude: 53.5, Longitude: -113.5, RadiusMeters: 5000}}},
+		{name: "circle center off the map", filter: SpatialFilter{Circle: &Circle{Latitude: 91, Longitude: -113.5, RadiusMeters: 5000}}, wantErr: true},
+		{name: "no radius", filter: SpatialFilter{Circle: &Circle{Latitude: 53.5, Longitude: -113.5}}, wantErr: true},
+		{name: "infinite radius", filter: SpatialFilter{Circle: &Circle{Latitude: 53.5, Longitude: -113.5, RadiusMeters: math.Inf(1)}}, wantErr: true},
+		{name: "nan radius", filter: SpatialFilter{Circle: &Circle{Latitude: 53.5, Longitude: -113.5, RadiusMeters: math.NaN()}}, wantErr: true},
+		{name: "bounding box", filter: SpatialFilter{BoundingBox: &BoundingBox{MinLatitude: 53.4, MinLongitude: -113.7, MaxLatitude: 53.7, MaxLongitude: -113.3}}},
+		{name: "crossing the antimeridian", filter: SpatialFilter{BoundingBox: &BoundingBox{MinLatitude: -20, MinLongitude: 170, MaxLatitude: -10, MaxLongitude: -170}}},
+		{name: "latitudes swapped", filter: SpatialFilter{BoundingBox: &BoundingBox{MinLatitude: 53.7, MinLongitude: -113.7, MaxLatitude: 53.4, MaxLongitude: -113.3}}, wantErr: true},
+		{name: "longitude off the map", filter: SpatialFilter{BoundingBox: &BoundingBox{MinLatitude: 53.4, MinLongitude: -181, MaxLatitude: 53.7, MaxLongitude: -113.3}}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.filter.Validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("got error %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestSpatialFilterContains(t *testing.T) {
+	edmonton := &Circle{Latitude: 53.5461, Longitude: -113.4938, RadiusMeters: 10000}
+	downtown := &BoundingBox{MinLatitude: 53.53, MinLongitude: -113.52, MaxLatitude: 53.55, MaxLongitude: -113.48}
+	fiji := &BoundingBox{MinLatitude: -20, MinLongitude: 170, MaxLatitude: -10, MaxLongitude: -170}
+
+	tests := []struct {
+		name      string
+		filter    SpatialFilter
+		latitude  float64
+		longitude float64
+		want      bool
+	}{
+		{name: "no shapes", filter: SpatialFilter{}, latitude: 0, longitude: 0, want: true},
+		{name: "the center", filter: SpatialFilter{Circle: edmonton}, latitude: 53.5461, longitude: -113.4938, want: true},
+		// St. Albert is ~14km away, and Calgary ~250km
+		{name: "outside the radius", filter: SpatialFilter{Circle: edmonton}, latitude: 53.6305, longitude: -113.6256, want: false},
+		{name: "far away", filter: SpatialFilter{Circle: edmonton}, latitude: 51.0447, longitude: -114.0719, want: false},
+		{name: "in the box", filter: SpatialFilter{BoundingBox: downtown}, latitude: 53.54, longitude: -113.5, want: true},
+		{name: "on the edge of the box", filter: SpatialFilter{BoundingBox: downtown}, latitude: 53.53, longitude: -113.52, want: true},
+		{name: "beside the box", filter: SpatialFilter{BoundingBox: downtown}, latitude: 53.54, longitude: -113.47, want: false},
+		{name: "east of the antimeridian", filter: SpatialFilter{BoundingBox: fiji}, latitude: -17, longitude: 178, want: true},
+		{name: "west of the antimeridian", filter: SpatialFilter{BoundingBox: fiji}, latitude: -17, longitude: -179, want: true},
+		{name: "the other way around the world", filter: SpatialFilter{BoundingBox: fiji}, latitude: -17, longitude: 0, want: false},
+		{name: "in both", filter: SpatialFilter{Circle: edmonton, BoundingBox: downtown}, latitude: 53.54, longitude: -113.5, want: true},
+		// inside the circle, but not the box
+		{name: "in only one", filter: SpatialFilter{Circle: edmonton, BoundingBox: downtown}, latitude: 53.5, longitude: -113.45, want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.filter.Contains(test.latitude, test.longitude); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCircleBounds(t *testing.T) {
+	tests := []struct {
+		name   string
+		circle Circle
+		// whether the bounds wrap around the antimeridian
+		wantCrosses bool
+		// whether the bounds widen to every longitude
+		wantWorld bool
+	}{
+		{name: "small", circle: Circle{Latitude: 53.5, Longitude: -113.5, RadiusMeters: 5000}},
+		{name: "by the antimeridian", circle: Circle{Latitude: -17, Longitude: 179.9, RadiusMeters: 50000}, wantCrosses: true},
+		{name: "over the pole", circle: Circle{Latitude: 89, Longitude: 0, RadiusMeters: 200000}, wantWorld: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bounds := test.circle.Bounds()
+			if bounds.CrossesAntimeridian() != test.wantCrosses {
+				t.Errorf("got bounds %+v, want crossing the antimeridian %v", bounds, test.wantCrosses)
+			}
+			world := bounds.MinLongitude == -180 && bounds.MaxLongitude == 180
+			if world != test.wantWorld {
+				t.Errorf("got bounds %+v, want every longitude %v", bounds, test.wantWorld)
+			}
+			// every point on the edge of the circle is in the bounds. it's walked just inside, so rounding doesn't put it outside
+			angular := test.circle.RadiusMeters * (1 - 1e-9) / EarthRadiusMeters
+			latitude1 := test.circle.Latitude * math.Pi / 180
+			longitude1 := test.circle.Longitude * math.Pi / 180
+			for bearing := 0.0; bearing < 2*math.Pi; bearing += math.Pi / 36 {
+				latitude2 := math.Asin(math.Sin(latitude1)*math.Cos(angular) + math.Cos(latitude1)*math.Sin(angular)*math.Cos(bearing))
+				longitude2 := longitude1 + math.Atan2(math.Sin(bearing)*math.Sin(angular)*math.Cos(latitude1), math.Cos(angular)-math.Sin(latitude1)*math.Sin(latitude2))
+				latitude := latitude2 * 180 / math.Pi
+				longitude := math.Mod(longitude2*180/math.Pi+540, 360) - 180
+				if !bounds.Contains(latitude, longitude) {
+					t.Errorf("bounds %+v don't contain %v, %v", bounds, latitude, longitude)
+				}
+			}
+		})
+	}
+}
+
+func TestHaversineMeters(t *testing.T) {
+	tests := []struct {
+		name       string
+		latitude1  float64
+		longitude1 float64
+		latitude2  float64
+		longitude2 float64
+		want       float64
+	}{
+		{name: "same point", latitude1: 53.5, longitude1: -113.5, latitude2: 53.5, longitude2: -113.5, want: 0},
+		// a degree of latitude is the same everywhere on a sphere
+		{name: "one degree north", latitude1: 0, longitude1: 0, latitude2: 1, longitude2: 0, want: EarthRadiusMeters * math.Pi / 180},
+		{name: "across the antimeridian", latitude1: 0, longitude1: 179.5, latitude2: 0, longitude2: -179.5, want: EarthRadiusMeters * math.Pi / 180},
+		{name: "antipodes", latitude1: 0, longitude1: 0, latitude2: 0, longitude2: 180, want: EarthRadiusMeters * math.Pi},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := HaversineMeters(test.latitude1, test.longitude1, test.latitude2, test.longitude2)
+			if math.Abs(got-test.want) > 0.001 {
+				t.Errorf("got %v meters, want %v", got, test.want)
+			}
+		})
+	}
+}
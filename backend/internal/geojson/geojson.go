@@ -0,0 +1,129 @@
+package geojson
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/database"
+)
+
+// ContentType is the media type registered for GeoJSON
+const ContentType = "application/geo+json"
+
+// FeatureCollection is the top level object. paged responses say where the next page starts, as a foreign member
+type FeatureCollection struct {
+	Type       string     `json:"type"`
+	Features   []*Feature `json:"features"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+type Feature struct {
+	Type       string         `json:"type"`
+	ID         string         `json:"id,omitempty"`
+	Geometry   *Geometry      `json:"geometry"`
+	Properties map[string]any `json:"properties"`
+}
+
+// Geometry is a Point or a LineString. positions are longitude then latitude, as GeoJSON requires
+type Geometry struct {
+	Type        string `json:"type"`
+	Coordinates any    `json:"coordinates"`
+}
+
+func newFeatureCollection(features []*Feature, nextCursor string) *FeatureCollection {
+	return &FeatureCollection{
+		Type:       "FeatureCollection",
+		Features:   features,
+		NextCursor: nextCursor,
+	}
+}
+
+func position(geolocation *database.DeviceGeolocation) []float64 {
+	return []float64{geolocation.Longitude, geolocation.Latitude}
+}
+
+// deviceProperties describe the device. a device that couldn't be found only has its id
+func deviceProperties(deviceID string, device *database.Device) map[string]any {
+	properties := map[string]any{
+		"device_id": deviceID,
+	}
+	if device != nil {
+		properties["name"] = device.Name
+		properties["device_type"] = device.DeviceType
+		properties["model"] = device.Model
+		properties["tags"] = device.Tags
+		properties["attributes"] = device.Attributes
+	}
+	return properties
+}
+
+// addTelemetry copies the telemetry that was reported, under the same names as the JSON api
+func addTelemetry(properties map[string]any, telemetry database.Telemetry) {
+	encoded, err := json.Marshal(telemetry)
+	if err != nil {
+		return
+	}
+	values := map[string]any{}
+	if err := json.Unmarshal(encoded, &values); err != nil {
+		return
+	}
+	for name, value := range values {
+		properties[name] = value
+	}
+}
+
+func pointFeature(geolocation *database.DeviceGeolocation, device *database.Device) *Feature {
+	properties := deviceProperties(geolocation.DeviceID, device)
+	properties["event_time"] = geolocation.EventTime
+	addTelemetry(properties, geolocation.Telemetry)
+	return &Feature{
+		Type: "Feature",
+		ID:   geolocation.DeviceID,
+		Geometry: &Geometry{
+			Type:        "Point",
+			Coordinates: position(geolocation),
+		},
+		Properties: properties,
+	}
+}
+
+// LatestPositions is a Point for each device. devices are in the same order as the geolocations, and may be nil
+func LatestPositions(geolocations []*database.DeviceGeolocation, devices []*database.Device, nextCursor string) *FeatureCollection {
+	features := make([]*Feature, 0, len(geolocations))
+	for i, geolocation := range geolocations {
+		features = append(features, pointFeature(geolocation, devices[i]))
+	}
+	return newFeatureCollection(features, nextCursor)
+}
+
+// Track is a device's history as a LineString, with the event time of each position in coordTimes, the way GPX converters do it.
+// a LineString needs two positions, so a single geolocation is a Point instead, and no geolocations is no features
+func Track(deviceID string, device *database.Device, geolocations []*database.DeviceGeolocation, nextCursor string) *FeatureCollection {
+	features := []*Feature{}
+	switch len(geolocations) {
+	case 0:
+	case 1:
+		features = append(features, pointFeature(geolocations[0], device))
+	default:
+		coordinates := make([][]float64, len(geolocations))
+		times := make([]time.Time, len(geolocations))
+		for i, geolocation := range geolocations {
+			coordinates[i] = position(geolocation)
+			times[i] = geolocation.EventTime
+		}
+		properties := deviceProperties(deviceID, device)
+		properties["start_time"] = times[0]
+		properties["end_time"] = times[len(times)-1]
+		properties["coordTimes"] = times
+		features = append(features, &Feature{
+			Type: "Feature",
+			ID:   deviceID,
+			Geometry: &Geometry{
+				Type:        "LineString",
+				Coordinates: coordinates,
+			},
+			Properties: properties,
+		})
+	}
+	return newFeatureCollection(features, nextCursor)
+}
@@ -0,0 +1,157 @@
+package geojson
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/database"
+)
+
+// encode round trips through JSON, so tests see what clients do
+func encode(t *testing.T, collection *FeatureCollection) map[string]any {
+	t.Helper()
+	encoded, err := json.Marshal(collection)
+	if err != nil {
+		t.Fatalf("failed to encode: %v", err)
+	}
+	decoded := map[string]any{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	return decoded
+}
+
+func features(t *testing.T, decoded map[string]any) []map[string]any {
+	t.Helper()
+	if decoded["type"] != "FeatureCollection" {
+		t.Fatalf("got type %v", decoded["type"])
+	}
+	list, ok := decoded["features"].([]any)
+	if !ok {
+		t.Fatalf("got features %v", decoded["features"])
+	}
+	features := []map[string]any{}
+	for _, feature := range list {
+		features = append(features, feature.(map[string]any))
+	}
+	return features
+}
+
+func TestLatestPositions(t *testing.T) {
+	eventTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	altitude := 120.0
+	geolocations := []*database.DeviceGeolocation{
+		{DeviceID: "a", EventTime: eventTime, Latitude: 53.5, Longitude: -113.5, Telemetry: database.Telemetry{AltitudeMSL: &altitude}},
+		{DeviceID: "b", EventTime: eventTime, Latitude: 51, Longitude: -114},
+	}
+	devices := []*database.Device{
+		{DeviceID: "a", Name: "alpha", DeviceType: "quadcopter", Tags: []string{"survey"}},
+		nil,
+	}
+
+	decoded := encode(t, LatestPositions(geolocations, devices, "next"))
+	if decoded["next_cursor"] != "next" {
+		t.Errorf("got next_cursor %v", decoded["next_cursor"])
+	}
+	got := features(t, decoded)
+	if len(got) != 2 {
+		t.Fatalf("got %d features, want 2", len(got))
+	}
+
+	tests := []struct {
+		name         string
+		feature      map[string]any
+		wantID       string
+		wantCoords   []float64
+		wantName     any
+		wantAltitude any
+	}{
+		{name: "with a device and telemetry", feature: got[0], wantID: "a", wantCoords: []float64{-113.5, 53.5}, wantName: "alpha", wantAltitude: 120.0},
+		// only the id is known, and telemetry that wasn't reported is left out
+		{name: "without a device", feature: got[1], wantID: "b", wantCoords: []float64{-114, 51}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.feature["type"] != "Feature" || test.feature["id"] != test.wantID {
+				t.Errorf("got feature %v", test.feature)
+			}
+			geometry := test.feature["geometry"].(map[string]any)
+			coordinates := geometry["coordinates"].([]any)
+			// longitude comes first
+			if geometry["type"] != "Point" || len(coordinates) != 2 || coordinates[0] != test.wantCoords[0] || coordinates[1] != test.wantCoords[1] {
+				t.Errorf("got geometry %v, want a point at %v", geometry, test.wantCoords)
+			}
+			properties := test.feature["properties"].(map[string]any)
+			if properties["device_id"] != test.wantID || properties["name"] != test.wantName || properties["altitude_msl"] != test.wantAltitude {
+				t.Errorf("got properties %v", properties)
+			}
+			if properties["event_time"] != "2024-01-01T00:00:00Z" {
+				t.Errorf("got event_time %v", properties["event_time"])
+			}
+		})
+	}
+}
+
+func TestTrack(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	device := &database.Device{DeviceID: "a", Name: "alpha"}
+	at := func(seconds int, latitude float64) *database.DeviceGeolocation {
+		return &database.DeviceGeolocation{DeviceID: "a", EventTime: start.Add(time.Duration(seconds) * time.Second), Latitude: latitude, Longitude: -113.5}
+	}
+
+	tests := []struct {
+		name         string
+		geolocations []*database.DeviceGeolocation
+		// the geometry type of the only feature, or empty for none
+		wantType      string
+		wantPositions int
+	}{
+		{name: "nothing", geolocations: []*database.DeviceGeolocation{}},
+		{name: "one position", geolocations: []*database.DeviceGeolocation{at(0, 53.5)}, wantType: "Point", wantPositions: 1},
+		{name: "a track", geolocations: []*database.DeviceGeolocation{at(0, 53.5), at(1, 53.6), at(2, 53.7)}, wantType: "LineString", wantPositions: 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := features(t, encode(t, Track("a", device, test.geolocations, "")))
+			if test.wantType == "" {
+				if len(got) != 0 {
+					t.Errorf("got %d features, want none", len(got))
+				}
+				return
+			}
+			if len(got) != 1 {
+				t.Fatalf("got %d features, want 1", len(got))
+			}
+			geometry := got[0]["geometry"].(map[string]any)
+			if geometry["type"] != test.wantType {
+				t.Fatalf("got geometry %v, want %v", geometry["type"], test.wantType)
+			}
+			properties := got[0]["properties"].(map[string]any)
+			if properties["name"] != "alpha" {
+				t.Errorf("got properties %v", properties)
+			}
+			if test.wantType != "LineString" {
+				return
+			}
+
+			coordinates := geometry["coordinates"].([]any)
+			times := properties["coordTimes"].([]any)
+			if len(coordinates) != test.wantPositions || len(times) != test.wantPositions {
+				t.Fatalf("got %d positions and %d times, want %d", len(coordinates), len(times), test.wantPositions)
+			}
+			for i, geolocation := range test.geolocations {
+				position := coordinates[i].([]any)
+				if position[0] != geolocation.Longitude || position[1] != geolocation.Latitude {
+					t.Errorf("position %d: got %v", i, position)
+				}
+				if times[i] != geolocation.EventTime.Format(time.RFC3339) {
+					t.Errorf("time %d: got %v", i, times[i])
+				}
+			}
+			if properties["start_time"] != times[0] || properties["end_time"] != times[len(times)-1] {
+				t.Errorf("got start_time %v and end_time %v", properties["start_time"], properties["end_time"])
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/database"
+)
+
+// ColumnMapping names the CSV columns holding each field. fields left out use the default column names,
+// which are the same as the JSON api's
+type ColumnMapping struct {
+	DeviceName string `json:"device_name"`
+	EventTime  string `json:"event_time"`
+	Latitude   string `json:"latitude"`
+	Longitude  string `json:"longitude"`
+	// telemetry columns are optional, and blank values weren't reported
+	AltitudeMSL        string `json:"altitude_msl"`
+	AltitudeAGL        string `json:"altitude_agl"`
+	Heading            string `json:"heading"`
+	GroundSpeed        string `json:"ground_speed"`
+	VerticalSpeed      string `json:"vertical_speed"`
+	HorizontalAccuracy string `json:"horizontal_accuracy"`
+	BatteryPercent     string `json:"battery_percent"`
+	// how event times are written: rfc3339 (the default), unix or unix_ms for epoch seconds or milliseconds, or a Go time layout
+	TimeFormat string `json:"time_format"`
+}
+
+func DefaultColumnMapping() ColumnMapping {
+	return ColumnMapping{
+		DeviceName:         "device_name",
+		EventTime:          "event_time",
+		Latitude:           "latitude",
+		Longitude:          "longitude",
+		AltitudeMSL:        "altitude_msl",
+		AltitudeAGL:        "altitude_agl",
+		Heading:            "heading",
+		GroundSpeed:        "ground_speed",
+		VerticalSpeed:      "vertical_speed",
+		HorizontalAccuracy: "horizontal_accuracy",
+		BatteryPercent:     "battery_percent",
+		TimeFormat:         "rfc3339",
+	}
+}
+
+func orDefault(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+func (m ColumnMapping) withDefaults() ColumnMapping {
+	d := DefaultColumnMapping()
+	return ColumnMapping{
+		DeviceName:         orDefault(m.DeviceName, d.DeviceName),
+		EventTime:          orDefault(m.EventTime, d.EventTime),
+		Latitude:           orDefault(m.Latitude, d.Latitude),
+		Longitude:          orDefault(m.Longitude, d.Longitude),
+		AltitudeMSL:        orDefault(m.AltitudeMSL, d.AltitudeMSL),
+		AltitudeAGL:        orDefault(m.AltitudeAGL, d.AltitudeAGL),
+		Heading:            orDefault(m.Heading, d.Heading),
+		GroundSpeed:        orDefault(m.GroundSpeed, d.GroundSpeed),
+		VerticalSpeed:      orDefault(m.VerticalSpeed, d.VerticalSpeed),
+		HorizontalAccuracy: orDefault(m.HorizontalAccuracy, d.HorizontalAccuracy),
+		BatteryPercent:     orDefault(m.BatteryPercent, d.BatteryPercent),
+		TimeFormat:         orDefault(m.TimeFormat, d.TimeFormat),
+	}
+}
+
+func parseTime(value string, format string) (time.Time, error) {
+	switch format {
+	case "rfc3339":
+		return time.Parse(time.RFC3339Nano, value)
+	case "unix_ms":
+		milliseconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.UnixMilli(milliseconds).UTC(), nil
+	case "unix":
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err == nil {
+			return time.Unix(seconds, 0).UTC(), nil
+		}
+		// fractional seconds, which floats only keep to about a microsecond
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		seconds = int64(number)
+		return time.Unix(seconds, int64((number-float64(seconds))*float64(time.Second))).UTC(), nil
+	}
+	return time.Parse(format, value)
+}
+
+type csvSource struct {
+	reader     *csv.Reader
+	mapping    ColumnMapping
+	deviceName string
+	// column index of each mapped field, or -1 when the file doesn't have it
+	deviceColumn int
+	timeColumn   int
+	latColumn    int
+	lonColumn    int
+	telemetry    []telemetryColumn
+}
+
+type telemetryColumn struct {
+	name   string
+	column int
+	field  func(t *database.Telemetry) **float64
+}
+
+// NewCSVSource reads the header. the event time, latitude and longitude columns are required,
+// and so is the device name column unless deviceName is given
+func NewCSVSource(r io.Reader, mapping ColumnMapping, deviceName string) (Source, error) {
+	mapping = mapping.withDefaults()
+	reader := csv.NewReader(r)
+	// short rows are rejected on their own, rather than failing the file
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read csv header: %v", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		// spreadsheets often start the file with a byte order mark
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		columns[strings.TrimSpace(name)] = i
+	}
+	column := func(name string) int {
+		if i, ok := columns[name]; ok {
+			return i
+		}
+		return -1
+	}
+
+	s := &csvSource{
+		reader:       reader,
+		mapping:      mapping,
+		deviceName:   deviceName,
+		deviceColumn: column(mapping.DeviceName),
+		timeColumn:   column(mapping.EventTime),
+		latColumn:    column(mapping.Latitude),
+		lonColumn:    column(mapping.Longitude),
+	}
+	for name, i := range map[string]int{mapping.EventTime: s.timeColumn, mapping.Latitude: s.latColumn, mapping.Longitude: s.lonColumn} {
+		if i < 0 {
+			return nil, fmt.Errorf("missing csv column %v", name)
+		}
+	}
+	if s.deviceColumn < 0 && deviceName == "" {
+		return nil, fmt.Errorf("missing csv column %v, and no device name was given", mapping.DeviceName)
+	}
+	for _, t := range []telemetryColumn{
+		{name: mapping.AltitudeMSL, field: func(t *database.Telemetry) **float64 { return &t.AltitudeMSL }},
+		{name: mapping.AltitudeAGL, field: func(t *database.Telemetry) **float64 { return &t.AltitudeAGL }},
+		{name: mapping.Heading, field: func(t *database.Telemetry) **float64 { return &t.Heading }},
+		{name: mapping.GroundSpeed, field: func(t *database.Telemetry) **float64 { return &t.GroundSpeed }},
+		{name: mapping.VerticalSpeed, field: func(t *database.Telemetry) **float64 { return &t.VerticalSpeed }},
+		{name: mapping.HorizontalAccuracy, field: func(t *database.Telemetry) **float64 { return &t.HorizontalAccuracy }},
+		{name: mapping.BatteryPercent, field: func(t *database.Telemetry) **float64 { return &t.BatteryPercent }},
+	} {
+		t.column = column(t.name)
+		if t.column >= 0 {
+			s.telemetry = append(s.telemetry, t)
+		}
+	}
+	return s, nil
+}
+
+func (s *csvSource) Next() (*Row, error) {
+	record, err := s.reader.Read()
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return &Row{Line: parseErr.Line, Err: parseErr.Err}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	line, _ := s.reader.FieldPos(0)
+	row := &Row{
+		Line:        line,
+		DeviceName:  s.deviceName,
+		Geolocation: &database.DeviceGeolocation{},
+	}
+	field := func(column int) string {
+		if column < 0 || column >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[column])
+	}
+	if name := field(s.deviceColumn); name != "" {
+		row.DeviceName = name
+	}
+	row.Err = s.parse(row.Geolocation, field)
+	return row, nil
+}
+
+func (s *csvSource) parse(geolocation *database.DeviceGeolocation, field func(column int) string) error {
+	if value := field(s.timeColumn); value != "" {
+		eventTime, err := parseTime(value, s.mapping.TimeFormat)
+		if err != nil {
+			return fmt.Errorf("invalid %v: %v", s.mapping.EventTime, value)
+		}
+		geolocation.EventTime = eventTime
+	}
+	for _, c := range []struct {
+		name   string
+		column int
+		value  *float64
+	}{
+		{s.mapping.Latitude, s.latColumn, &geolocation.Latitude},
+		{s.mapping.Longitude, s.lonColumn, &geolocation.Longitude},
+	} {
+		value := field(c.column)
+		if value == "" {
+			return fmt.Errorf("missing %v", c.name)
+		}
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %v: %v", c.name, value)
+		}
+		*c.value = number
+	}
+	for _, t := range s.telemetry {
+		value := field(t.column)
+		if value == "" {
+			continue
+		}
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %v: %v", t.name, value)
+		}
+		*t.field(&geolocation.Telemetry) = &number
+	}
+	return nil
+}
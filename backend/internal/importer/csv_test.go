@@ -0,0 +1,144 @@
+package importer
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readAll returns every row the source reads, failing the test if the source itself fails
+func readAll(t *testing.T, source Source) []*Row {
+	t.Helper()
+	rows := []*Row{}
+	for {
+		row, err := source.Next()
+		if errors.Is(err, io.EOF) {
+			return rows
+		}
+		if err != nil {
+			t.Fatalf("failed to read: %v", err)
+		}
+		rows = append(rows, row)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		format string
+		want   time.Time
+		valid  bool
+	}{
+		{name: "rfc3339", value: "2024-01-02T03:04:05.5Z", format: "rfc3339", want: time.Date(2024, 1, 2, 3, 4, 5, 500000000, time.UTC), valid: true},
+		{name: "unix", value: "1704164645", format: "unix", want: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), valid: true},
+		{name: "fractional unix", value: "1704164645.25", format: "unix", want: time.Date(2024, 1, 2, 3, 4, 5, 250000000, time.UTC), valid: true},
+		{name: "unix_ms keeps every millisecond", value: "1704164645123", format: "unix_ms", want: time.Date(2024, 1, 2, 3, 4, 5, 123000000, time.UTC), valid: true},
+		{name: "unix_ms before 1970", value: "-1", format: "unix_ms", want: time.Date(1969, 12, 31, 23, 59, 59, 999000000, time.UTC), valid: true},
+		{name: "go layout", value: "2024-01-02 03:04:05", format: "2006-01-02 15:04:05", want: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), valid: true},
+		{name: "fractional unix_ms", value: "1704164645123.5", format: "unix_ms"},
+		{name: "not a number", value: "yesterday", format: "unix"},
+		{name: "not rfc3339", value: "2024-01-02", format: "rfc3339"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseTime(test.value, test.format)
+			if !test.valid {
+				if err == nil {
+					t.Errorf("expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !got.Equal(test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewCSVSourceChecksHeader(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     string
+		mapping    ColumnMapping
+		deviceName string
+		valid      bool
+	}{
+		{name: "default columns", header: "device_name,event_time,latitude,longitude", valid: true},
+		{name: "byte order mark", header: "\ufeffdevice_name,event_time,latitude,longitude", valid: true},
+		{name: "device name given instead", header: "event_time,latitude,longitude", deviceName: "drone", valid: true},
+		{
+			name:    "mapped columns",
+			header:  "id,t,lat,lon",
+			mapping: ColumnMapping{DeviceName: "id", EventTime: "t", Latitude: "lat", Longitude: "lon"},
+			valid:   true,
+		},
+		{name: "no device name", header: "event_time,latitude,longitude"},
+		{name: "no latitude", header: "device_name,event_time,longitude"},
+		{name: "no event time", header: "device_name,latitude,longitude"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewCSVSource(strings.NewReader(test.header+"\n"), test.mapping, test.deviceName)
+			if test.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestCSVSourceRows(t *testing.T) {
+	file := strings.Join([]string{
+		"device_name,event_time,latitude,longitude,heading,battery_percent",
+		"alpha,2024-01-01T00:00:00Z,53.5,-113.5,90,",
+		",2024-01-01T00:00:01Z,53.6,-113.6,,50",
+		"alpha,2024-01-01T00:00:02Z,north,-113.5,,",
+		"alpha,2024-01-01T00:00:03Z,53.5",
+		"alpha,2024-01-01T00:00:04Z,53.5,-113.5,fast,",
+		`alpha,"2024-01-01T00:00:05Z,53.5,-113.5,,`,
+	}, "\n")
+	source, err := NewCSVSource(strings.NewReader(file), ColumnMapping{}, "fallback")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rows := readAll(t, source)
+	if len(rows) != 6 {
+		t.Fatalf("got %d rows, want 6", len(rows))
+	}
+
+	first := rows[0]
+	if first.Err != nil {
+		t.Fatalf("unexpected error: %v", first.Err)
+	}
+	if first.Line != 2 || first.DeviceName != "alpha" || first.Geolocation.Latitude != 53.5 || first.Geolocation.Longitude != -113.5 {
+		t.Errorf("got line %d for %v at %v, %v", first.Line, first.DeviceName, first.Geolocation.Latitude, first.Geolocation.Longitude)
+	}
+	if first.Geolocation.Heading == nil || *first.Geolocation.Heading != 90 {
+		t.Errorf("heading wasn't read")
+	}
+	if first.Geolocation.BatteryPercent != nil {
+		t.Errorf("a blank battery_percent was read as %v", *first.Geolocation.BatteryPercent)
+	}
+
+	if rows[1].Err != nil || rows[1].DeviceName != "fallback" {
+		t.Errorf("a row without a device name got %q, %v", rows[1].DeviceName, rows[1].Err)
+	}
+	// bad values, short rows and broken quoting are rejected on their own
+	for i, want := range []string{"invalid latitude: north", "missing longitude", "invalid heading: fast"} {
+		row := rows[i+2]
+		if row.Err == nil || row.Err.Error() != want {
+			t.Errorf("line %d: got %v, want %v", row.Line, row.Err, want)
+		}
+	}
+	if rows[5].Err == nil {
+		t.Errorf("line %d: expected a parse error", rows[5].Line)
+	}
+}
@@ -0,0 +1,108 @@
+package importer
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/database"
+)
+
+type gpxPoint struct {
+	Latitude  string  `xml:"lat,attr"`
+	Longitude string  `xml:"lon,attr"`
+	Elevation *string `xml:"ele"`
+	Time      string  `xml:"time"`
+}
+
+// gpxSource reads the points of every track, one by one. waypoints and routes aren't tracks, and are skipped
+type gpxSource struct {
+	decoder    *xml.Decoder
+	deviceName string
+	inTrack    bool
+	trackName  string
+}
+
+// NewGPXSource names each track's device after the track, or deviceName for tracks without a name.
+// elevations are taken to be above mean sea level
+func NewGPXSource(r io.Reader, deviceName string) Source {
+	return &gpxSource{
+		decoder:    xml.NewDecoder(r),
+		deviceName: deviceName,
+	}
+}
+
+func (s *gpxSource) Next() (*Row, error) {
+	for {
+		token, err := s.decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch {
+			case t.Name.Local == "trk":
+				s.inTrack = true
+				s.trackName = ""
+			case t.Name.Local == "name" && s.inTrack:
+				if err := s.decoder.DecodeElement(&s.trackName, &t); err != nil {
+					return nil, err
+				}
+			case t.Name.Local == "trkpt":
+				line, _ := s.decoder.InputPos()
+				point := gpxPoint{}
+				if err := s.decoder.DecodeElement(&point, &t); err != nil {
+					return nil, err
+				}
+				return s.row(line, point), nil
+			}
+		case xml.EndElement:
+			if t.Name.Local == "trk" {
+				s.inTrack = false
+			}
+		}
+	}
+}
+
+func (s *gpxSource) row(line int, point gpxPoint) *Row {
+	row := &Row{
+		Line:        line,
+		DeviceName:  s.deviceName,
+		Geolocation: &database.DeviceGeolocation{},
+	}
+	if s.trackName != "" {
+		row.DeviceName = s.trackName
+	}
+	latitude, err := strconv.ParseFloat(point.Latitude, 64)
+	if err != nil {
+		row.Err = fmt.Errorf("invalid lat: %q", point.Latitude)
+		return row
+	}
+	longitude, err := strconv.ParseFloat(point.Longitude, 64)
+	if err != nil {
+		row.Err = fmt.Errorf("invalid lon: %q", point.Longitude)
+		return row
+	}
+	row.Geolocation.Latitude = latitude
+	row.Geolocation.Longitude = longitude
+	if point.Elevation != nil {
+		elevation, err := strconv.ParseFloat(strings.TrimSpace(*point.Elevation), 64)
+		if err != nil {
+			row.Err = fmt.Errorf("invalid ele: %q", *point.Elevation)
+			return row
+		}
+		row.Geolocation.AltitudeMSL = &elevation
+	}
+	if point.Time != "" {
+		eventTime, err := time.Parse(time.RFC3339Nano, point.Time)
+		if err != nil {
+			row.Err = fmt.Errorf("invalid time: %q", point.Time)
+			return row
+		}
+		row.Geolocation.EventTime = eventTime
+	}
+	return row
+}
@@ -0,0 +1,70 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGPXSource(t *testing.T) {
+	file := `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+  <wpt lat="1" lon="1"><name>not a track</name></wpt>
+  <trk>
+    <name>alpha</name>
+    <trkseg>
+      <trkpt lat="53.5" lon="-113.5"><ele>650.5</ele><time>2024-01-01T00:00:00Z</time></trkpt>
+      <trkpt lat="53.6" lon="-113.6"><time>2024-01-01T00:00:01Z</time></trkpt>
+      <trkpt lat="53.7" lon="-113.7"><ele>high</ele><time>2024-01-01T00:00:02Z</time></trkpt>
+      <trkpt lat="north" lon="-113.7"><time>2024-01-01T00:00:03Z</time></trkpt>
+      <trkpt lat="53.7" lon="-113.7"><time>yesterday</time></trkpt>
+    </trkseg>
+  </trk>
+  <trk>
+    <trkseg>
+      <trkpt lat="1" lon="2"><time>2024-01-01T00:00:04Z</time></trkpt>
+    </trkseg>
+  </trk>
+</gpx>`
+	rows := readAll(t, NewGPXSource(strings.NewReader(file), "fallback"))
+	if len(rows) != 6 {
+		t.Fatalf("got %d rows, want 6", len(rows))
+	}
+
+	first := rows[0]
+	if first.Err != nil {
+		t.Fatalf("unexpected error: %v", first.Err)
+	}
+	if first.DeviceName != "alpha" || first.Geolocation.Latitude != 53.5 || first.Geolocation.Longitude != -113.5 {
+		t.Errorf("got %v at %v, %v", first.DeviceName, first.Geolocation.Latitude, first.Geolocation.Longitude)
+	}
+	if first.Geolocation.AltitudeMSL == nil || *first.Geolocation.AltitudeMSL != 650.5 {
+		t.Errorf("elevation wasn't read")
+	}
+	if !first.Geolocation.EventTime.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("got time %v", first.Geolocation.EventTime)
+	}
+	if rows[1].Err != nil || rows[1].Geolocation.AltitudeMSL != nil {
+		t.Errorf("a point without an elevation got %v, %v", rows[1].Geolocation.AltitudeMSL, rows[1].Err)
+	}
+	// bad points are rejected on their own, rather than failing the file
+	for i, want := range []string{`invalid ele: "high"`, `invalid lat: "north"`, `invalid time: "yesterday"`} {
+		row := rows[i+2]
+		if row.Err == nil || row.Err.Error() != want {
+			t.Errorf("point %d: got %v, want %v", i+2, row.Err, want)
+		}
+	}
+	if rows[5].DeviceName != "fallback" {
+		t.Errorf("a track without a name got device %q", rows[5].DeviceName)
+	}
+}
+
+func TestGPXSourceFailsOnBrokenXML(t *testing.T) {
+	source := NewGPXSource(strings.NewReader(`<gpx><trk><trkseg><trkpt lat="1" lon="2">`), "")
+	for i := 0; i < 10; i++ {
+		if _, err := source.Next(); err != nil {
+			return
+		}
+	}
+	t.Errorf("expected an error")
+}
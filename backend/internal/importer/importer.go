@@ -0,0 +1,243 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/database"
+	"github.com/NinjaPerson24119/MapProject/backend/internal/filters"
+)
+
+// points are written in batches this big
+const batchSize = 1000
+
+// the report lists this many rejected rows, and only counts the rest
+const maxReportedRejections = 1000
+
+type Format string
+
+const (
+	CSV Format = "csv"
+	GPX Format = "gpx"
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch Format(s) {
+	case CSV, GPX:
+		return Format(s), nil
+	}
+	return "", fmt.Errorf("unknown import format: %v", s)
+}
+
+// FormatFromFilename goes by the file's extension
+func FormatFromFilename(filename string) (Format, error) {
+	return ParseFormat(strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), "."))
+}
+
+// Row is one point read from a file. rows that couldn't be read have an Err, and are rejected
+type Row struct {
+	Line        int
+	DeviceName  string
+	Geolocation *database.DeviceGeolocation
+	Err         error
+}
+
+// Source reads rows from a file, and returns io.EOF after the last one
+type Source interface {
+	Next() (*Row, error)
+}
+
+// NewSource checks what it can about the file up front, such as the CSV header, so a file that can't be imported fails before anything is written.
+// deviceName is used for rows that don't name their device
+func NewSource(format Format, r io.Reader, mapping ColumnMapping, deviceName string) (Source, error) {
+	if format == GPX {
+		return NewGPXSource(r, deviceName), nil
+	}
+	return NewCSVSource(r, mapping, deviceName)
+}
+
+type CreatedDevice struct {
+	DeviceID string `json:"device_id"`
+	Name     string `json:"name"`
+}
+
+type RejectedRow struct {
+	Line       int    `json:"line"`
+	DeviceName string `json:"device_name,omitempty"`
+	Error      string `json:"error"`
+}
+
+type Report struct {
+	Rows int `json:"rows"`
+	// new points, and points that replaced a stored one under the last_write_wins policy
+	Imported int `json:"imported"`
+	// points that were already stored, or kept under the keep_first policy
+	Duplicates     int              `json:"duplicates"`
+	CreatedDevices []*CreatedDevice `json:"created_devices"`
+	RejectedRows   int              `json:"rejected_rows"`
+	// the first rejected rows, in the order they were read
+	Rejected []*RejectedRow `json:"rejected"`
+}
+
+type importer struct {
+	repo   database.Repo
+	orgID  string
+	policy database.DuplicatePolicy
+	report *Report
+
+	// device ids by name. names shared by several devices map to "", since rows can't say which one they mean
+	devices map[string]string
+
+	batch     []*database.DeviceGeolocation
+	batchRows []*Row
+}
+
+// Import writes every valid row to the org, creating devices that don't exist yet by name.
+// when the repo fails, the report covers what was written before it did
+func Import(ctx context.Context, repo database.Repo, orgID string, source Source, policy database.DuplicatePolicy) (*Report, error) {
+	i := &importer{
+		repo:   repo,
+		orgID:  orgID,
+		policy: policy,
+		report: &Report{
+			CreatedDevices: []*CreatedDevice{},
+			Rejected:       []*RejectedRow{},
+		},
+	}
+	if err := i.loadDevices(ctx); err != nil {
+		return i.report, err
+	}
+	for {
+		row, err := source.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return i.report, fmt.Errorf("failed to read file: %v", err)
+		}
+		if err := i.add(ctx, row); err != nil {
+			return i.report, err
+		}
+	}
+	return i.report, i.flush(ctx)
+}
+
+// loadDevices pages through the org's devices. deleted devices can't be named, so rows for them create a new device
+func (i *importer) loadDevices(ctx context.Context) error {
+	i.devices = map[string]string{}
+	cursor := ""
+	for {
+		devices, nextCursor, err := i.repo.ListDevices(ctx, i.orgID, filters.PageOptions{
+			PageSize: filters.MaxPageSize,
+			Cursor:   cursor,
+		}, filters.DeviceFilter{})
+		if err != nil {
+			return fmt.Errorf("failed to list devices: %v", err)
+		}
+		for _, device := range devices {
+			if _, ok := i.devices[device.Name]; ok {
+				i.devices[device.Name] = ""
+				continue
+			}
+			i.devices[device.Name] = device.DeviceID
+		}
+		if nextCursor == "" {
+			return nil
+		}
+		cursor = nextCursor
+	}
+}
+
+func (i *importer) reject(row *Row, err error) {
+	i.report.RejectedRows++
+	if len(i.report.Rejected) < maxReportedRejections {
+		i.report.Rejected = append(i.report.Rejected, &RejectedRow{
+			Line:       row.Line,
+			DeviceName: row.DeviceName,
+			Error:      err.Error(),
+		})
+	}
+}
+
+func validate(row *Row) error {
+	if row.Err != nil {
+		return row.Err
+	}
+	if row.DeviceName == "" {
+		return fmt.Errorf("missing device name")
+	}
+	if row.Geolocation.EventTime.IsZero() {
+		return fmt.Errorf("missing event time")
+	}
+	return database.ValidateGeolocation(row.Geolocation)
+}
+
+// deviceID finds the named device, or creates it. ok is false when more than one device has the name
+func (i *importer) deviceID(ctx context.Context, name string) (id string, ok bool, err error) {
+	id, found := i.devices[name]
+	if found {
+		return id, id != "", nil
+	}
+	id, err = i.repo.InsertDevice(ctx, i.orgID, &database.Device{Name: name})
+	if err != nil {
+		return "", false, err
+	}
+	i.devices[name] = id
+	i.report.CreatedDevices = append(i.report.CreatedDevices, &CreatedDevice{
+		DeviceID: id,
+		Name:     name,
+	})
+	return id, true, nil
+}
+
+// add only creates a device for a row that is otherwise valid, so a file of bad rows doesn't leave devices behind
+func (i *importer) add(ctx context.Context, row *Row) error {
+	i.report.Rows++
+	if err := validate(row); err != nil {
+		i.reject(row, err)
+		return nil
+	}
+	id, ok, err := i.deviceID(ctx, row.DeviceName)
+	if err != nil {
+		return fmt.Errorf("failed to create device %v: %v", row.DeviceName, err)
+	}
+	if !ok {
+		i.reject(row, fmt.Errorf("more than one device is named %v", row.DeviceName))
+		return nil
+	}
+	row.Geolocation.DeviceID = id
+	i.batch = append(i.batch, row.Geolocation)
+	i.batchRows = append(i.batchRows, row)
+	if len(i.batch) >= batchSize {
+		return i.flush(ctx)
+	}
+	return nil
+}
+
+func (i *importer) flush(ctx context.Context) error {
+	if len(i.batch) == 0 {
+		return nil
+	}
+	results, err := i.repo.IngestMultiGeolocation(ctx, i.orgID, i.batch, i.policy)
+	if err != nil {
+		return fmt.Errorf("failed to import geolocations: %v", err)
+	}
+	for j, result := range results {
+		switch result.Status {
+		// in relay mode rows are queued, and duplicates are only found once they're persisted
+		case database.IngestInserted, database.IngestUpdated, database.IngestQueued:
+			i.report.Imported++
+		case database.IngestDuplicate, database.IngestKeptExisting:
+			i.report.Duplicates++
+		default:
+			i.reject(i.batchRows[j], fmt.Errorf("%v", result.Error))
+		}
+	}
+	i.batch = nil
+	i.batchRows = nil
+	return nil
+}
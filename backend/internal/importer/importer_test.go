@@ -0,0 +1,51 @@
+package importer
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/database"
+)
+
+func TestImport(t *testing.T) {
+	ctx := context.Background()
+	repo := database.NewMemory()
+	// two devices share a name, so rows can't say which one they mean
+	for _, name := range []string{"existing", "twin", "twin"} {
+		if _, err := repo.InsertDevice(ctx, database.DefaultOrgID, &database.Device{Name: name}); err != nil {
+			t.Fatalf("failed to insert device: %v", err)
+		}
+	}
+	file := strings.Join([]string{
+		"device_name,event_time,latitude,longitude",
+		"existing,2024-01-01T00:00:00Z,53.5,-113.5",
+		"existing,2024-01-01T00:00:00Z,53.5,-113.5",
+		"new,2024-01-01T00:00:00Z,53.5,-113.5",
+		"twin,2024-01-01T00:00:00Z,53.5,-113.5",
+		"bad,2024-01-01T00:00:00Z,100,-113.5",
+		"existing,,53.5,-113.5",
+	}, "\n")
+	source, err := NewCSVSource(strings.NewReader(file), ColumnMapping{}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	report, err := Import(ctx, repo, database.DefaultOrgID, source, database.RejectDuplicates)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.Rows != 6 || report.Imported != 2 || report.Duplicates != 1 || report.RejectedRows != 3 {
+		t.Errorf("got %d rows, %d imported, %d duplicates and %d rejected", report.Rows, report.Imported, report.Duplicates, report.RejectedRows)
+	}
+	// only valid rows create devices
+	if len(report.CreatedDevices) != 1 || report.CreatedDevices[0].Name != "new" {
+		t.Errorf("got created devices %+v", report.CreatedDevices)
+	}
+	wantLines := []int{5, 6, 7}
+	for i, rejected := range report.Rejected {
+		if rejected.Line != wantLines[i] {
+			t.Errorf("rejected row %d is line %d, want %d", i, rejected.Line, wantLines[i])
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package metrics
+
+// bucket upper bounds. anything larger lands in a final bucket without one
+var (
+	latencyBucketsMilliseconds = []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000}
+	batchSizeBuckets           = []float64{1, 10, 100, 1000, 10000}
+)
+
+type HistogramBucket struct {
+	// observations up to and including this, and above the previous bucket. omitted for the last bucket
+	UpTo  float64 `json:"up_to,omitempty"`
+	Count int64   `json:"count"`
+}
+
+// histogram counts observations into fixed buckets. it isn't safe for concurrent use on its own
+type histogram struct {
+	bounds []float64
+	counts []int64
+	sum    float64
+	total  int64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]int64, len(bounds)+1),
+	}
+}
+
+func (h *histogram) observe(value float64) {
+	i := 0
+	for i < len(h.bounds) && value > h.bounds[i] {
+		i++
+	}
+	h.counts[i]++
+	h.sum += value
+	h.total++
+}
+
+func (h *histogram) buckets() []HistogramBucket {
+	buckets := make([]HistogramBucket, len(h.counts))
+	for i, count := range h.counts {
+		buckets[i].Count = count
+		if i < len(h.bounds) {
+			buckets[i].UpTo = h.bounds[i]
+		}
+	}
+	return buckets
+}
+
+func (h *histogram) mean() float64 {
+	if h.total == 0 {
+		return 0
+	}
+	return h.sum / float64(h.total)
+}
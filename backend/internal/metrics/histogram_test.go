@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	tests := []struct {
+		name     string
+		observed []float64
+		want     []int64
+		wantMean float64
+	}{
+		{name: "nothing", observed: []float64{}, want: []int64{0, 0, 0}, wantMean: 0},
+		// bounds are inclusive, so an observation on one lands in its bucket
+		{name: "on the bounds", observed: []float64{1, 10}, want: []int64{1, 1, 0}, wantMean: 5.5},
+		{name: "between the bounds", observed: []float64{0.5, 2, 9}, want: []int64{1, 2, 0}, wantMean: 11.5 / 3},
+		{name: "past the last bound", observed: []float64{11, 1000}, want: []int64{0, 0, 2}, wantMean: 505.5},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newHistogram([]float64{1, 10})
+			for _, value := range test.observed {
+				h.observe(value)
+			}
+			buckets := h.buckets()
+			counts := []int64{}
+			for _, bucket := range buckets {
+				counts = append(counts, bucket.Count)
+			}
+			if !slices.Equal(counts, test.want) {
+				t.Errorf("got counts %v, want %v", counts, test.want)
+			}
+			if buckets[0].UpTo != 1 || buckets[1].UpTo != 10 || buckets[2].UpTo != 0 {
+				t.Errorf("got buckets %+v", buckets)
+			}
+			if h.mean() != test.wantMean {
+				t.Errorf("got mean %v, want %v", h.mean(), test.wantMean)
+			}
+		})
+	}
+}
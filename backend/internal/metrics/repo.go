@@ -0,0 +1,310 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/database"
+	"github.com/NinjaPerson24119/MapProject/backend/internal/filters"
+)
+
+type MethodStats struct {
+	Calls  int64 `json:"calls"`
+	Errors int64 `json:"errors"`
+	// for ListenToGeolocationInserted this is how long listeners stayed subscribed
+	MeanLatencyMilliseconds float64           `json:"mean_latency_ms"`
+	MaxLatencyMilliseconds  float64           `json:"max_latency_ms"`
+	Latency                 []HistogramBucket `json:"latency_ms"`
+	// rows written or returned, for methods that deal in more than one
+	MeanBatchSize float64           `json:"mean_batch_size,omitempty"`
+	BatchSize     []HistogramBucket `json:"batch_size,omitempty"`
+}
+
+type Stats struct {
+	Since   time.Time              `json:"since"`
+	Methods map[string]MethodStats `json:"methods"`
+}
+
+type methodMetrics struct {
+	calls      int64
+	errors     int64
+	maxLatency time.Duration
+	latency    *histogram
+	batchSize  *histogram
+}
+
+// InstrumentedRepo is a decorator for another Repo that records call counts, errors,
+// latency and batch sizes per method
+type InstrumentedRepo struct {
+	repo  database.Repo
+	since time.Time
+
+	mu      sync.Mutex
+	methods map[string]*methodMetrics
+}
+
+func New(repo database.Repo) *InstrumentedRepo {
+	return &InstrumentedRepo{
+		repo:    repo,
+		since:   time.Now(),
+		methods: map[string]*methodMetrics{},
+	}
+}
+
+func (r *InstrumentedRepo) record(method string, started time.Time, err error) {
+	r.recordBatch(method, started, -1, err)
+}
+
+// recordBatch records a call that wrote or returned size rows. a negative size isn't recorded
+func (r *InstrumentedRepo) recordBatch(method string, started time.Time, size int, err error) {
+	latency := time.Since(started)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.methods[method]
+	if !ok {
+		m = &methodMetrics{
+			latency: newHistogram(latencyBucketsMilliseconds),
+		}
+		r.methods[method] = m
+	}
+	m.calls++
+	if err != nil {
+		m.errors++
+	}
+	if latency > m.maxLatency {
+		m.maxLatency = latency
+	}
+	m.latency.observe(float64(latency) / float64(time.Millisecond))
+	if size >= 0 {
+		if m.batchSize == nil {
+			m.batchSize = newHistogram(batchSizeBuckets)
+		}
+		m.batchSize.observe(float64(size))
+	}
+}
+
+func (r *InstrumentedRepo) Stats() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := Stats{
+		Since:   r.since,
+		Methods: make(map[string]MethodStats, len(r.methods)),
+	}
+	for method, m := range r.methods {
+		methodStats := MethodStats{
+			Calls:                   m.calls,
+			Errors:                  m.errors,
+			MeanLatencyMilliseconds: m.latency.mean(),
+			MaxLatencyMilliseconds:  float64(m.maxLatency) / float64(time.Millisecond),
+			Latency:                 m.latency.buckets(),
+		}
+		if m.batchSize != nil {
+			methodStats.MeanBatchSize = m.batchSize.mean()
+			methodStats.BatchSize = m.batchSize.buckets()
+		}
+		stats.Methods[method] = methodStats
+	}
+	return stats
+}
+
+func (r *InstrumentedRepo) Close() {
+	r.repo.Close()
+}
+
+func (r *InstrumentedRepo) InsertDevice(ctx context.Context, orgID string, device *database.Device) (string, error) {
+	started := time.Now()
+	deviceID, err := r.repo.InsertDevice(ctx, orgID, device)
+	r.record("InsertDevice", started, err)
+	return deviceID, err
+}
+
+func (r *InstrumentedRepo) ListDevices(ctx context.Context, orgID string, paging filters.PageOptions, filter filters.DeviceFilter) ([]*database.Device, string, error) {
+	started := time.Now()
+	devices, nextCursor, err := r.repo.ListDevices(ctx, orgID, paging, filter)
+	r.recordBatch("ListDevices", started, len(devices), err)
+	return devices, nextCursor, err
+}
+
+func (r *InstrumentedRepo) GetDevice(ctx context.Context, orgID string, deviceID string) (*database.Device, error) {
+	started := time.Now()
+	device, err := r.repo.GetDevice(ctx, orgID, deviceID)
+	r.record("GetDevice", started, err)
+	return device, err
+}
+
+func (r *InstrumentedRepo) GetMultiDevices(ctx context.Context, orgID string, deviceIDs []string) ([]*database.Device, error) {
+	started := time.Now()
+	devices, err := r.repo.GetMultiDevices(ctx, orgID, deviceIDs)
+	r.recordBatch("GetMultiDevices", started, len(deviceIDs), err)
+	return devices, err
+}
+
+func (r *InstrumentedRepo) UpdateDevice(ctx context.Context, orgID string, update *database.DeviceUpdate) (*database.Device, error) {
+	started := time.Now()
+	updated, err := r.repo.UpdateDevice(ctx, orgID, update)
+	r.record("UpdateDevice", started, err)
+	return updated, err
+}
+
+func (r *InstrumentedRepo) DeleteDevice(ctx context.Context, orgID string, deviceID string) (*database.Device, error) {
+	started := time.Now()
+	device, err := r.repo.DeleteDevice(ctx, orgID, deviceID)
+	r.record("DeleteDevice", started, err)
+	return device, err
+}
+
+func (r *InstrumentedRepo) RestoreDevice(ctx context.Context, orgID string, deviceID string) (*database.Device, error) {
+	started := time.Now()
+	device, err := r.repo.RestoreDevice(ctx, orgID, deviceID)
+	r.record("RestoreDevice", started, err)
+	return device, err
+}
+
+func (r *InstrumentedRepo) InsertGeolocation(ctx context.Context, orgID string, geolocation *database.DeviceGeolocation) error {
+	started := time.Now()
+	err := r.repo.InsertGeolocation(ctx, orgID, geolocation)
+	r.record("InsertGeolocation", started, err)
+	return err
+}
+
+func (r *InstrumentedRepo) InsertMultiGeolocation(ctx context.Context, orgID string, geolocations []*database.DeviceGeolocation) error {
+	started := time.Now()
+	err := r.repo.InsertMultiGeolocation(ctx, orgID, geolocations)
+	r.recordBatch("InsertMultiGeolocation", started, len(geolocations), err)
+	return err
+}
+
+func (r *InstrumentedRepo) CopyMultiGeolocation(ctx context.Context, orgID string, geolocations []*database.DeviceGeolocation) (*database.IngestStats, error) {
+	started := time.Now()
+	stats, err := r.repo.CopyMultiGeolocation(ctx, orgID, geolocations)
+	r.recordBatch("CopyMultiGeolocation", started, len(geolocations), err)
+	return stats, err
+}
+
+func (r *InstrumentedRepo) IngestMultiGeolocation(ctx context.Context, orgID string, geolocations []*database.DeviceGeolocation, policy database.DuplicatePolicy) ([]*database.IngestResult, error) {
+	started := time.Now()
+	results, err := r.repo.IngestMultiGeolocation(ctx, orgID, geolocations, policy)
+	r.recordBatch("IngestMultiGeolocation", started, len(geolocations), err)
+	return results, err
+}
+
+func (r *InstrumentedRepo) ListLatestGeolocations(ctx context.Context, orgID string, paging filters.PageOptions, spatial filters.SpatialFilter, device filters.DeviceFilter) ([]*database.DeviceGeolocation, string, error) {
+	started := time.Now()
+	geolocations, nextCursor, err := r.repo.ListLatestGeolocations(ctx, orgID, paging, spatial, device)
+	r.recordBatch("ListLatestGeolocations", started, len(geolocations), err)
+	return geolocations, nextCursor, err
+}
+
+func (r *InstrumentedRepo) GetMultiLatestGeolocations(ctx context.Context, orgID string, deviceIDs []string) ([]*database.DeviceGeolocation, error) {
+	started := time.Now()
+	geolocations, err := r.repo.GetMultiLatestGeolocations(ctx, orgID, deviceIDs)
+	r.recordBatch("GetMultiLatestGeolocations", started, len(deviceIDs), err)
+	return geolocations, err
+}
+
+func (r *InstrumentedRepo) GetMultiGeolocationsAt(ctx context.Context, orgID string, deviceIDs []string, at time.Time) ([]*database.DeviceGeolocation, error) {
+	started := time.Now()
+	geolocations, err := r.repo.GetMultiGeolocationsAt(ctx, orgID, deviceIDs, at)
+	r.recordBatch("GetMultiGeolocationsAt", started, len(deviceIDs), err)
+	return geolocations, err
+}
+
+func (r *InstrumentedRepo) ListGeolocationHistory(ctx context.Context, orgID string, deviceID string, history filters.HistoryOptions, paging filters.PageOptions) ([]*database.DeviceGeolocation, string, error) {
+	started := time.Now()
+	geolocations, nextCursor, err := r.repo.ListGeolocationHistory(ctx, orgID, deviceID, history, paging)
+	r.recordBatch("ListGeolocationHistory", started, len(geolocations), err)
+	return geolocations, nextCursor, err
+}
+
+func (r *InstrumentedRepo) ListenToGeolocationInserted(ctx context.Context, orgID string, handler func(*database.DeviceGeolocation) error, resync func() error) error {
+	started := time.Now()
+	// listening only ends with an error, so it's only counted as one when the listener failed,
+	// rather than the caller going away or its handler deciding to stop
+	stoppedByHandler := false
+	instrumentedHandler := func(geolocation *database.DeviceGeolocation) error {
+		err := handler(geolocation)
+		if err != nil {
+			stoppedByHandler = true
+		}
+		return err
+	}
+	var instrumentedResync func() error
+	if resync != nil {
+		instrumentedResync = func() error {
+			err := resync()
+			if err != nil {
+				stoppedByHandler = true
+			}
+			return err
+		}
+	}
+	err := r.repo.ListenToGeolocationInserted(ctx, orgID, instrumentedHandler, instrumentedResync)
+	failed := err
+	if stoppedByHandler || ctx.Err() != nil {
+		failed = nil
+	}
+	r.record("ListenToGeolocationInserted", started, failed)
+	return err
+}
+
+func (r *InstrumentedRepo) ListenerStats() database.ListenerStats {
+	return r.repo.ListenerStats()
+}
+
+func (r *InstrumentedRepo) CompactGeolocations(ctx context.Context, from time.Time, to time.Time, resolution time.Duration) (int64, error) {
+	started := time.Now()
+	compacted, err := r.repo.CompactGeolocations(ctx, from, to, resolution)
+	r.recordBatch("CompactGeolocations", started, int(compacted), err)
+	return compacted, err
+}
+
+func (r *InstrumentedRepo) DeleteGeolocationsBefore(ctx context.Context, before time.Time) (int64, error) {
+	started := time.Now()
+	deleted, err := r.repo.DeleteGeolocationsBefore(ctx, before)
+	r.recordBatch("DeleteGeolocationsBefore", started, int(deleted), err)
+	return deleted, err
+}
+
+func (r *InstrumentedRepo) EnsureGeolocationPartitions(ctx context.Context, from time.Time, to time.Time) (int, error) {
+	started := time.Now()
+	created, err := r.repo.EnsureGeolocationPartitions(ctx, from, to)
+	r.record("EnsureGeolocationPartitions", started, err)
+	return created, err
+}
+
+func (r *InstrumentedRepo) InsertOrganization(ctx context.Context, org *database.Organization) (string, error) {
+	started := time.Now()
+	orgID, err := r.repo.InsertOrganization(ctx, org)
+	r.record("InsertOrganization", started, err)
+	return orgID, err
+}
+
+func (r *InstrumentedRepo) ListOrganizations(ctx context.Context) ([]*database.Organization, error) {
+	started := time.Now()
+	orgs, err := r.repo.ListOrganizations(ctx)
+	r.recordBatch("ListOrganizations", started, len(orgs), err)
+	return orgs, err
+}
+
+func (r *InstrumentedRepo) SetOrganizationAPIKey(ctx context.Context, orgID string, keyHash []byte) error {
+	started := time.Now()
+	err := r.repo.SetOrganizationAPIKey(ctx, orgID, keyHash)
+	r.record("SetOrganizationAPIKey", started, err)
+	return err
+}
+
+func (r *InstrumentedRepo) GetOrganizationByAPIKey(ctx context.Context, keyHash []byte) (*database.Organization, error) {
+	started := time.Now()
+	org, err := r.repo.GetOrganizationByAPIKey(ctx, keyHash)
+	r.record("GetOrganizationByAPIKey", started, err)
+	return org, err
+}
+
+func (r *InstrumentedRepo) MoveDevice(ctx context.Context, deviceID string, orgID string) (*database.Device, error) {
+	started := time.Now()
+	device, err := r.repo.MoveDevice(ctx, deviceID, orgID)
+	r.record("MoveDevice", started, err)
+	return device, err
+}
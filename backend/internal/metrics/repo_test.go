@@ -0,0 +1,127 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/database"
+	"github.com/NinjaPerson24119/MapProject/backend/internal/filters"
+)
+
+func TestInstrumentedRepoRecordsCalls(t *testing.T) {
+	ctx := context.Background()
+	repo := New(database.NewMemory())
+	deviceID, err := repo.InsertDevice(ctx, database.DefaultOrgID, &database.Device{Name: "alpha"})
+	if err != nil {
+		t.Fatalf("failed to insert device: %v", err)
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	geolocations := []*database.DeviceGeolocation{}
+	for i := 0; i < 20; i++ {
+		geolocations = append(geolocations, &database.DeviceGeolocation{DeviceID: deviceID, EventTime: start.Add(time.Duration(i) * time.Second)})
+	}
+	if err := repo.InsertMultiGeolocation(ctx, database.DefaultOrgID, geolocations[:5]); err != nil {
+		t.Fatalf("failed to insert geolocations: %v", err)
+	}
+	if _, err := repo.CopyMultiGeolocation(ctx, database.DefaultOrgID, geolocations[5:]); err != nil {
+		t.Fatalf("failed to copy geolocations: %v", err)
+	}
+	if _, err := repo.GetDevice(ctx, database.DefaultOrgID, "6f1c2b9e-3f4a-4c1d-9a7b-2e5d8c0f1a23"); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("got %v, want not found", err)
+	}
+	if _, _, err := repo.ListLatestGeolocations(ctx, database.DefaultOrgID, filters.PageOptions{PageSize: 10}, filters.SpatialFilter{}, filters.DeviceFilter{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stats := repo.Stats()
+	tests := []struct {
+		method     string
+		wantCalls  int64
+		wantErrors int64
+		// the counts in each batch size bucket, or nil when the method doesn't record them
+		wantBatchSize []int64
+	}{
+		{method: "InsertDevice", wantCalls: 1},
+		{method: "InsertMultiGeolocation", wantCalls: 1, wantBatchSize: []int64{0, 1, 0, 0, 0, 0}},
+		{method: "CopyMultiGeolocation", wantCalls: 1, wantBatchSize: []int64{0, 0, 1, 0, 0, 0}},
+		{method: "GetDevice", wantCalls: 1, wantErrors: 1},
+		// one device's latest position was returned
+		{method: "ListLatestGeolocations", wantCalls: 1, wantBatchSize: []int64{1, 0, 0, 0, 0, 0}},
+	}
+	for _, test := range tests {
+		t.Run(test.method, func(t *testing.T) {
+			method, ok := stats.Methods[test.method]
+			if !ok {
+				t.Fatalf("%v wasn't recorded", test.method)
+			}
+			if method.Calls != test.wantCalls || method.Errors != test.wantErrors {
+				t.Errorf("got %d calls and %d errors, want %d and %d", method.Calls, method.Errors, test.wantCalls, test.wantErrors)
+			}
+			latencyCount := int64(0)
+			for _, bucket := range method.Latency {
+				latencyCount += bucket.Count
+			}
+			if latencyCount != test.wantCalls {
+				t.Errorf("got %d latencies, want %d", latencyCount, test.wantCalls)
+			}
+			if len(method.BatchSize) != len(test.wantBatchSize) {
+				t.Fatalf("got batch sizes %+v, want %v", method.BatchSize, test.wantBatchSize)
+			}
+			for i, bucket := range method.BatchSize {
+				if bucket.Count != test.wantBatchSize[i] {
+					t.Errorf("got batch sizes %+v, want %v", method.BatchSize, test.wantBatchSize)
+					break
+				}
+			}
+		})
+	}
+	if len(stats.Methods) != len(tests) {
+		t.Errorf("got %d methods, want %d", len(stats.Methods), len(tests))
+	}
+}
+
+func TestInstrumentedRepoListenerErrors(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	repo := New(database.NewMemory())
+	deviceID, err := repo.InsertDevice(ctx, database.DefaultOrgID, &database.Device{Name: "alpha"})
+	if err != nil {
+		t.Fatalf("failed to insert device: %v", err)
+	}
+
+	// the caller going away isn't an error
+	listenCtx, stopListening := context.WithCancel(ctx)
+	stopListening()
+	repo.ListenToGeolocationInserted(listenCtx, database.DefaultOrgID, func(*database.DeviceGeolocation) error { return nil }, nil)
+
+	// neither is the handler deciding to stop
+	stopped := make(chan error)
+	go func() {
+		stopped <- repo.ListenToGeolocationInserted(ctx, database.DefaultOrgID, func(*database.DeviceGeolocation) error {
+			return errors.New("done")
+		}, nil)
+	}()
+	for {
+		geolocation := &database.DeviceGeolocation{DeviceID: deviceID, EventTime: time.Now()}
+		if err := repo.InsertGeolocation(ctx, database.DefaultOrgID, geolocation); err != nil {
+			t.Fatalf("failed to insert geolocation: %v", err)
+		}
+		select {
+		case err := <-stopped:
+			if err == nil {
+				t.Fatalf("expected the handler's error")
+			}
+		case <-time.After(10 * time.Millisecond):
+			// the listener may not have subscribed yet
+			continue
+		}
+		break
+	}
+
+	method := repo.Stats().Methods["ListenToGeolocationInserted"]
+	if method.Calls != 2 || method.Errors != 0 {
+		t.Errorf("got %d calls and %d errors, want 2 and none", method.Calls, method.Errors)
+	}
+}
@@ -0,0 +1,213 @@
+package migrations
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// files are named NNNN_description.sql and applied in order of NNNN
+//
+//go:embed sql/*.sql
+var files embed.FS
+
+// arbitrary, but fixed, so that servers starting at the same time take turns migrating
+const advisoryLockID = 24119
+
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+type Status struct {
+	Version int        `json:"version"`
+	Name    string     `json:"name"`
+	Applied *time.Time `json:"applied"`
+}
+
+func Load() ([]Migration, error) {
+	entries, err := files.ReadDir("sql")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %v", err)
+	}
+
+	migrations := []Migration{}
+	seen := map[int]string{}
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		prefix, _, found := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if !found || err != nil {
+			return nil, fmt.Errorf("migration file name should look like NNNN_description.sql: %v", entry.Name())
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %v and %v have the same version", other, name)
+		}
+		seen[version] = name
+
+		contents, err := files.ReadFile(path.Join("sql", entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %v: %v", name, err)
+		}
+		migrations = append(migrations, Migration{
+			Version: version,
+			Name:    name,
+			SQL:     string(contents),
+		})
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+func ensureVersionTable(ctx context.Context, conn *pgx.Conn) error {
+	_, err := conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS public.schema_migrations (
+			version INT PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %v", err)
+	}
+	return nil
+}
+
+func appliedVersions(ctx context.Context, conn *pgx.Conn) (map[int]time.Time, error) {
+	rows, err := conn.Query(ctx, "SELECT version, applied FROM public.schema_migrations;")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %v", err)
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, fmt.Errorf("failed to read schema_migrations: %v", err)
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+func status(ctx context.Context, conn *pgx.Conn) ([]Status, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureVersionTable(ctx, conn); err != nil {
+		return nil, err
+	}
+	applied, err := appliedVersions(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, len(migrations))
+	for i, migration := range migrations {
+		statuses[i] = Status{
+			Version: migration.Version,
+			Name:    migration.Name,
+		}
+		if at, ok := applied[migration.Version]; ok {
+			statuses[i].Applied = &at
+		}
+	}
+	return statuses, nil
+}
+
+// GetStatus lists every embedded migration and when it was applied, if it was
+func GetStatus(ctx context.Context, connectionURL string) ([]Status, error) {
+	conn, err := pgx.Connect(ctx, connectionURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to postgres: %v", err)
+	}
+	defer conn.Close(ctx)
+	return status(ctx, conn)
+}
+
+// Pending lists migrations that haven't been applied yet
+func Pending(ctx context.Context, connectionURL string) ([]Status, error) {
+	statuses, err := GetStatus(ctx, connectionURL)
+	if err != nil {
+		return nil, err
+	}
+	pending := []Status{}
+	for _, s := range statuses {
+		if s.Applied == nil {
+			pending = append(pending, s)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies every pending migration in order, each in its own transaction, and returns the ones it applied
+func Up(ctx context.Context, connectionURL string) ([]Status, error) {
+	conn, err := pgx.Connect(ctx, connectionURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to postgres: %v", err)
+	}
+	defer conn.Close(ctx)
+
+	_, err = conn.Exec(ctx, "SELECT pg_advisory_lock($1);", advisoryLockID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock migrations: %v", err)
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1);", advisoryLockID)
+
+	// read status under the lock, so we don't apply what another server just applied
+	statuses, err := status(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+
+	applied := []Status{}
+	for i, migration := range migrations {
+		if statuses[i].Applied != nil {
+			continue
+		}
+
+		fmt.Printf("applying migration %v\n", migration.Name)
+		tx, err := conn.Begin(ctx)
+		if err != nil {
+			return applied, err
+		}
+		_, err = tx.Exec(ctx, migration.SQL)
+		if err != nil {
+			tx.Rollback(ctx)
+			return applied, fmt.Errorf("failed to apply migration %v: %v", migration.Name, err)
+		}
+		var at time.Time
+		err = tx.QueryRow(ctx, "INSERT INTO public.schema_migrations (version, name) VALUES ($1, $2) RETURNING applied;", migration.Version, migration.Name).Scan(&at)
+		if err != nil {
+			tx.Rollback(ctx)
+			return applied, fmt.Errorf("failed to record migration %v: %v", migration.Name, err)
+		}
+		err = tx.Commit(ctx)
+		if err != nil {
+			return applied, fmt.Errorf("failed to commit migration %v: %v", migration.Name, err)
+		}
+		applied = append(applied, Status{
+			Version: migration.Version,
+			Name:    migration.Name,
+			Applied: &at,
+		})
+	}
+	return applied, nil
+}
@@ -1,6 +1,7 @@
+-- databases set up by hand from the old database/schema.sql already have all of this
 CREATE extension IF NOT EXISTS "uuid-ossp";
 
-CREATE SCHEMA device;
+CREATE SCHEMA IF NOT EXISTS device;
 
 CREATE TABLE IF NOT EXISTS device.information (
     device_id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
@@ -5,7 +5,7 @@ BEGIN
 END;
 $$ LANGUAGE plpgsql;
 
-CREATE TRIGGER notify_after_insert_geolocation
+CREATE OR REPLACE TRIGGER notify_after_insert_geolocation
 AFTER INSERT ON device.geolocation
 FOR EACH ROW
 EXECUTE FUNCTION notify_on_insert_geolocation();
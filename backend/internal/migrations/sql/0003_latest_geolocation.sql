@@ -0,0 +1,41 @@
+-- one row per device, kept up to date by a trigger on device.geolocation
+-- so reading the latest positions doesn't scan the whole history
+CREATE TABLE IF NOT EXISTS device.latest_geolocation (
+    device_id uuid PRIMARY KEY REFERENCES device.information,
+    event_time TIMESTAMPTZ NOT NULL,
+    latitude DECIMAL NOT NULL CHECK(latitude >= -90 AND latitude <= 90),
+    longitude DECIMAL NOT NULL CHECK(longitude >= -180 AND longitude <= 180),
+    created TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    deleted TIMESTAMPTZ
+);
+
+-- out of order inserts don't move a device backwards
+CREATE OR REPLACE FUNCTION upsert_latest_geolocation() RETURNS TRIGGER AS $$
+BEGIN
+  INSERT INTO device.latest_geolocation AS l (device_id, event_time, latitude, longitude, created, updated, deleted)
+  VALUES (NEW.device_id, NEW.event_time, NEW.latitude, NEW.longitude, NEW.created, NEW.updated, NEW.deleted)
+  ON CONFLICT (device_id) DO UPDATE
+  SET event_time = EXCLUDED.event_time,
+      latitude = EXCLUDED.latitude,
+      longitude = EXCLUDED.longitude,
+      created = EXCLUDED.created,
+      updated = EXCLUDED.updated,
+      deleted = EXCLUDED.deleted
+  WHERE EXCLUDED.event_time > l.event_time;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER upsert_latest_after_insert_geolocation
+AFTER INSERT ON device.geolocation
+FOR EACH ROW
+EXECUTE FUNCTION upsert_latest_geolocation();
+
+-- backfill for databases that already have history
+INSERT INTO device.latest_geolocation (device_id, event_time, latitude, longitude, created, updated, deleted)
+SELECT DISTINCT ON (device_id) device_id, event_time, latitude, longitude, created, updated, deleted
+FROM device.geolocation
+WHERE deleted IS NULL
+ORDER BY device_id, event_time DESC
+ON CONFLICT (device_id) DO NOTHING;
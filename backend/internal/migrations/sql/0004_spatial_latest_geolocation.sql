@@ -0,0 +1,12 @@
+-- great circle distance on a sphere of mean earth radius, matching filters.HaversineMeters
+CREATE OR REPLACE FUNCTION device.haversine_meters(latitude1 float8, longitude1 float8, latitude2 float8, longitude2 float8) RETURNS float8 AS $$
+  SELECT 2 * 6371008.8 * asin(least(1, sqrt(
+    power(sin(radians(latitude2 - latitude1) / 2), 2) +
+    cos(radians(latitude1)) * cos(radians(latitude2)) * power(sin(radians(longitude2 - longitude1) / 2), 2)
+  )));
+$$ LANGUAGE sql IMMUTABLE PARALLEL SAFE;
+
+-- bounding boxes, and the boxes around circles, are matched with <@ against this index before exact distances are checked
+CREATE INDEX IF NOT EXISTS latest_geolocation_position_idx
+ON device.latest_geolocation
+USING gist (point(longitude::float8, latitude::float8));
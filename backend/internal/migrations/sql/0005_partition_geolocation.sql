@@ -0,0 +1,96 @@
+-- rebuild device.geolocation as a table partitioned by UTC day of event_time.
+-- existing history is copied across in this transaction, so expect it to take a while on a big table
+
+ALTER TABLE device.geolocation RENAME TO geolocation_unpartitioned;
+ALTER TABLE device.geolocation_unpartitioned RENAME CONSTRAINT geolocation_pkey TO geolocation_unpartitioned_pkey;
+
+CREATE TABLE device.geolocation (
+    device_id uuid REFERENCES device.information NOT NULL,
+    event_time TIMESTAMPTZ NOT NULL,
+    latitude DECIMAL NOT NULL CHECK(latitude >= -90 AND latitude <= 90),
+    longitude DECIMAL NOT NULL CHECK(longitude >= -180 AND longitude <= 180),
+    created TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    deleted TIMESTAMPTZ,
+    PRIMARY KEY (device_id, event_time)
+) PARTITION BY RANGE (event_time);
+
+-- catches anything that arrives for a day without a partition yet, until that day's partition is created
+CREATE TABLE device.geolocation_default PARTITION OF device.geolocation DEFAULT;
+
+-- creates the partition for one UTC day, named geolocation_YYYYMMDD, and returns whether it had to.
+-- rows for that day that already landed in the default partition are moved into it
+CREATE OR REPLACE FUNCTION device.create_geolocation_partition(partition_day date) RETURNS boolean AS $$
+DECLARE
+  partition_name text := 'geolocation_' || to_char(partition_day, 'YYYYMMDD');
+  range_start timestamptz := partition_day::timestamp AT TIME ZONE 'UTC';
+  range_end timestamptz := (partition_day + 1)::timestamp AT TIME ZONE 'UTC';
+BEGIN
+  IF to_regclass('device.' || partition_name) IS NOT NULL THEN
+    RETURN false;
+  END IF;
+
+  -- built detached, so moving rows doesn't fire the insert triggers a second time
+  EXECUTE format('CREATE TABLE device.%I (LIKE device.geolocation INCLUDING DEFAULTS INCLUDING CONSTRAINTS)', partition_name);
+  EXECUTE format(
+    'WITH moved AS (DELETE FROM device.geolocation_default WHERE event_time >= %L AND event_time < %L RETURNING *)
+     INSERT INTO device.%I SELECT * FROM moved',
+    range_start, range_end, partition_name
+  );
+  EXECUTE format(
+    'ALTER TABLE device.geolocation ATTACH PARTITION device.%I FOR VALUES FROM (%L) TO (%L)',
+    partition_name, range_start, range_end
+  );
+  RETURN true;
+END;
+$$ LANGUAGE plpgsql;
+
+-- drops every daily partition that ends at or before the cutoff, and returns roughly how many rows went with them
+CREATE OR REPLACE FUNCTION device.drop_geolocation_partitions_before(cutoff timestamptz) RETURNS bigint AS $$
+DECLARE
+  expired record;
+  dropped bigint := 0;
+BEGIN
+  FOR expired IN
+    SELECT c.relname, greatest(c.reltuples, 0)::bigint AS estimated_rows
+    FROM pg_inherits AS i
+    INNER JOIN pg_class AS c ON c.oid = i.inhrelid
+    WHERE i.inhparent = 'device.geolocation'::regclass
+      AND c.relname ~ '^geolocation_[0-9]{8}$'
+      AND ((to_date(substring(c.relname FROM 13), 'YYYYMMDD') + 1)::timestamp AT TIME ZONE 'UTC') <= cutoff
+  LOOP
+    EXECUTE format('DROP TABLE device.%I', expired.relname);
+    dropped := dropped + expired.estimated_rows;
+  END LOOP;
+  RETURN dropped;
+END;
+$$ LANGUAGE plpgsql;
+
+-- one partition per day of existing history, plus the week ahead
+DO $$
+DECLARE
+  partition_day date;
+BEGIN
+  FOR partition_day IN
+    SELECT DISTINCT (event_time AT TIME ZONE 'UTC')::date FROM device.geolocation_unpartitioned
+    UNION
+    SELECT (now() AT TIME ZONE 'UTC')::date + offset_days FROM generate_series(0, 7) AS offset_days
+  LOOP
+    PERFORM device.create_geolocation_partition(partition_day);
+  END LOOP;
+END;
+$$;
+
+INSERT INTO device.geolocation SELECT * FROM device.geolocation_unpartitioned;
+DROP TABLE device.geolocation_unpartitioned;
+
+-- the old triggers went with the old table. they're created after the copy so it doesn't notify every historic row
+CREATE OR REPLACE TRIGGER notify_after_insert_geolocation
+AFTER INSERT ON device.geolocation
+FOR EACH ROW
+EXECUTE FUNCTION notify_on_insert_geolocation();
+
+CREATE OR REPLACE TRIGGER upsert_latest_after_insert_geolocation
+AFTER INSERT ON device.geolocation
+FOR EACH ROW
+EXECUTE FUNCTION upsert_latest_geolocation();
@@ -0,0 +1,16 @@
+-- send the whole row as compact JSON, so listeners don't have to query for what was just inserted.
+-- devices that were deleted are skipped, like they are when reading latest positions.
+-- notifications are limited to 8000 bytes, which this stays well under
+CREATE OR REPLACE FUNCTION notify_on_insert_geolocation() RETURNS TRIGGER AS $$
+BEGIN
+  IF EXISTS (SELECT 1 FROM device.information WHERE device_id = NEW.device_id AND deleted IS NULL) THEN
+    PERFORM pg_notify('geolocation_inserted', json_build_object(
+      'device_id', NEW.device_id,
+      'event_time', NEW.event_time,
+      'latitude', NEW.latitude,
+      'longitude', NEW.longitude
+    )::text);
+  END IF;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,24 @@
+-- ingesting with last_write_wins updates positions in place, so updates need the same side effects as inserts
+
+-- only the latest geolocation itself changes. an update to older history doesn't move the device
+CREATE OR REPLACE FUNCTION update_latest_geolocation() RETURNS TRIGGER AS $$
+BEGIN
+  UPDATE device.latest_geolocation
+  SET latitude = NEW.latitude,
+      longitude = NEW.longitude,
+      updated = NEW.updated
+  WHERE device_id = NEW.device_id AND event_time = NEW.event_time;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER update_latest_after_update_geolocation
+AFTER UPDATE OF latitude, longitude ON device.geolocation
+FOR EACH ROW
+EXECUTE FUNCTION update_latest_geolocation();
+
+-- listeners hear about the new position the same way as an insert
+CREATE OR REPLACE TRIGGER notify_after_update_geolocation
+AFTER UPDATE OF latitude, longitude ON device.geolocation
+FOR EACH ROW
+EXECUTE FUNCTION notify_on_insert_geolocation();
@@ -0,0 +1,95 @@
+-- optional drone telemetry. every column is nullable so devices that only report a position are unaffected.
+-- adding columns to the partitioned table adds them to every partition
+ALTER TABLE device.geolocation
+  ADD COLUMN IF NOT EXISTS altitude_msl DOUBLE PRECISION,
+  ADD COLUMN IF NOT EXISTS altitude_agl DOUBLE PRECISION,
+  ADD COLUMN IF NOT EXISTS heading DOUBLE PRECISION CHECK(heading >= 0 AND heading < 360),
+  ADD COLUMN IF NOT EXISTS ground_speed DOUBLE PRECISION CHECK(ground_speed >= 0),
+  ADD COLUMN IF NOT EXISTS vertical_speed DOUBLE PRECISION,
+  ADD COLUMN IF NOT EXISTS horizontal_accuracy DOUBLE PRECISION CHECK(horizontal_accuracy >= 0),
+  ADD COLUMN IF NOT EXISTS battery_percent DOUBLE PRECISION CHECK(battery_percent >= 0 AND battery_percent <= 100);
+
+ALTER TABLE device.latest_geolocation
+  ADD COLUMN IF NOT EXISTS altitude_msl DOUBLE PRECISION,
+  ADD COLUMN IF NOT EXISTS altitude_agl DOUBLE PRECISION,
+  ADD COLUMN IF NOT EXISTS heading DOUBLE PRECISION,
+  ADD COLUMN IF NOT EXISTS ground_speed DOUBLE PRECISION,
+  ADD COLUMN IF NOT EXISTS vertical_speed DOUBLE PRECISION,
+  ADD COLUMN IF NOT EXISTS horizontal_accuracy DOUBLE PRECISION,
+  ADD COLUMN IF NOT EXISTS battery_percent DOUBLE PRECISION;
+
+CREATE OR REPLACE FUNCTION upsert_latest_geolocation() RETURNS TRIGGER AS $$
+BEGIN
+  INSERT INTO device.latest_geolocation AS l (device_id, event_time, latitude, longitude, created, updated, deleted,
+    altitude_msl, altitude_agl, heading, ground_speed, vertical_speed, horizontal_accuracy, battery_percent)
+  VALUES (NEW.device_id, NEW.event_time, NEW.latitude, NEW.longitude, NEW.created, NEW.updated, NEW.deleted,
+    NEW.altitude_msl, NEW.altitude_agl, NEW.heading, NEW.ground_speed, NEW.vertical_speed, NEW.horizontal_accuracy, NEW.battery_percent)
+  ON CONFLICT (device_id) DO UPDATE
+  SET event_time = EXCLUDED.event_time,
+      latitude = EXCLUDED.latitude,
+      longitude = EXCLUDED.longitude,
+      created = EXCLUDED.created,
+      updated = EXCLUDED.updated,
+      deleted = EXCLUDED.deleted,
+      altitude_msl = EXCLUDED.altitude_msl,
+      altitude_agl = EXCLUDED.altitude_agl,
+      heading = EXCLUDED.heading,
+      ground_speed = EXCLUDED.ground_speed,
+      vertical_speed = EXCLUDED.vertical_speed,
+      horizontal_accuracy = EXCLUDED.horizontal_accuracy,
+      battery_percent = EXCLUDED.battery_percent
+  WHERE EXCLUDED.event_time > l.event_time;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION update_latest_geolocation() RETURNS TRIGGER AS $$
+BEGIN
+  UPDATE device.latest_geolocation
+  SET latitude = NEW.latitude,
+      longitude = NEW.longitude,
+      altitude_msl = NEW.altitude_msl,
+      altitude_agl = NEW.altitude_agl,
+      heading = NEW.heading,
+      ground_speed = NEW.ground_speed,
+      vertical_speed = NEW.vertical_speed,
+      horizontal_accuracy = NEW.horizontal_accuracy,
+      battery_percent = NEW.battery_percent,
+      updated = NEW.updated
+  WHERE device_id = NEW.device_id AND event_time = NEW.event_time;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- missing telemetry is left out of the payload rather than sent as null, which keeps it well under 8000 bytes
+CREATE OR REPLACE FUNCTION notify_on_insert_geolocation() RETURNS TRIGGER AS $$
+BEGIN
+  IF EXISTS (SELECT 1 FROM device.information WHERE device_id = NEW.device_id AND deleted IS NULL) THEN
+    PERFORM pg_notify('geolocation_inserted', json_strip_nulls(json_build_object(
+      'device_id', NEW.device_id,
+      'event_time', NEW.event_time,
+      'latitude', NEW.latitude,
+      'longitude', NEW.longitude,
+      'altitude_msl', NEW.altitude_msl,
+      'altitude_agl', NEW.altitude_agl,
+      'heading', NEW.heading,
+      'ground_speed', NEW.ground_speed,
+      'vertical_speed', NEW.vertical_speed,
+      'horizontal_accuracy', NEW.horizontal_accuracy,
+      'battery_percent', NEW.battery_percent
+    ))::text);
+  END IF;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- telemetry corrected in place is an update like a corrected position
+CREATE OR REPLACE TRIGGER update_latest_after_update_geolocation
+AFTER UPDATE OF latitude, longitude, altitude_msl, altitude_agl, heading, ground_speed, vertical_speed, horizontal_accuracy, battery_percent ON device.geolocation
+FOR EACH ROW
+EXECUTE FUNCTION update_latest_geolocation();
+
+CREATE OR REPLACE TRIGGER notify_after_update_geolocation
+AFTER UPDATE OF latitude, longitude, altitude_msl, altitude_agl, heading, ground_speed, vertical_speed, horizontal_accuracy, battery_percent ON device.geolocation
+FOR EACH ROW
+EXECUTE FUNCTION notify_on_insert_geolocation();
@@ -0,0 +1,13 @@
+-- what kind of device this is, and free-form metadata to filter devices by.
+-- the defaults keep rows from before this migration readable without nulls
+ALTER TABLE device.information
+  ADD COLUMN IF NOT EXISTS device_type TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS model TEXT NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}',
+  ADD COLUMN IF NOT EXISTS attributes JSONB NOT NULL DEFAULT '{}'::jsonb;
+
+CREATE INDEX IF NOT EXISTS information_device_type_idx ON device.information (device_type);
+
+-- tag and attribute filters use containment, which GIN indexes serve
+CREATE INDEX IF NOT EXISTS information_tags_idx ON device.information USING GIN (tags);
+CREATE INDEX IF NOT EXISTS information_attributes_idx ON device.information USING GIN (attributes jsonb_path_ops);
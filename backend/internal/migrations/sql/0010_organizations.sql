@@ -0,0 +1,42 @@
+-- teams sharing a deployment each have their own fleet
+CREATE TABLE IF NOT EXISTS device.organization (
+    org_id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org_name TEXT NOT NULL,
+    created TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+-- devices from before organizations belong to the default org, which requests without an org use
+INSERT INTO device.organization (org_id, org_name)
+VALUES ('00000000-0000-0000-0000-000000000000', 'default')
+ON CONFLICT (org_id) DO NOTHING;
+
+ALTER TABLE device.information
+  ADD COLUMN IF NOT EXISTS org_id uuid NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000' REFERENCES device.organization (org_id);
+
+CREATE INDEX IF NOT EXISTS information_org_id_idx ON device.information (org_id, device_id);
+
+-- listeners only pass notifications on to streams of the device's org
+CREATE OR REPLACE FUNCTION notify_on_insert_geolocation() RETURNS TRIGGER AS $$
+DECLARE
+  device_org_id uuid;
+BEGIN
+  SELECT org_id INTO device_org_id FROM device.information WHERE device_id = NEW.device_id AND deleted IS NULL;
+  IF FOUND THEN
+    PERFORM pg_notify('geolocation_inserted', json_strip_nulls(json_build_object(
+      'org_id', device_org_id,
+      'device_id', NEW.device_id,
+      'event_time', NEW.event_time,
+      'latitude', NEW.latitude,
+      'longitude', NEW.longitude,
+      'altitude_msl', NEW.altitude_msl,
+      'altitude_agl', NEW.altitude_agl,
+      'heading', NEW.heading,
+      'ground_speed', NEW.ground_speed,
+      'vertical_speed', NEW.vertical_speed,
+      'horizontal_accuracy', NEW.horizontal_accuracy,
+      'battery_percent', NEW.battery_percent
+    ))::text);
+  END IF;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
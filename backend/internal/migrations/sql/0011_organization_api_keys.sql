@@ -0,0 +1,4 @@
+-- requests authenticate as an org with its api key. only a sha256 of the key is stored,
+-- and orgs without one can't be used until an admin issues one
+ALTER TABLE device.organization
+  ADD COLUMN IF NOT EXISTS api_key_hash bytea UNIQUE;
@@ -0,0 +1,28 @@
+-- servers create partitions at startup and then daily, so two of them can try to create the same day at once.
+-- checking for the partition and creating it are now done under a lock, so the second one waits and then finds it there
+CREATE OR REPLACE FUNCTION device.create_geolocation_partition(partition_day date) RETURNS boolean AS $$
+DECLARE
+  partition_name text := 'geolocation_' || to_char(partition_day, 'YYYYMMDD');
+  range_start timestamptz := partition_day::timestamp AT TIME ZONE 'UTC';
+  range_end timestamptz := (partition_day + 1)::timestamp AT TIME ZONE 'UTC';
+BEGIN
+  -- held until the caller's transaction ends, so the partition is attached by the time anyone else checks
+  PERFORM pg_advisory_xact_lock(hashtext('device.create_geolocation_partition'));
+  IF to_regclass('device.' || partition_name) IS NOT NULL THEN
+    RETURN false;
+  END IF;
+
+  -- built detached, so moving rows doesn't fire the insert triggers a second time
+  EXECUTE format('CREATE TABLE device.%I (LIKE device.geolocation INCLUDING DEFAULTS INCLUDING CONSTRAINTS)', partition_name);
+  EXECUTE format(
+    'WITH moved AS (DELETE FROM device.geolocation_default WHERE event_time >= %L AND event_time < %L RETURNING *)
+     INSERT INTO device.%I SELECT * FROM moved',
+    range_start, range_end, partition_name
+  );
+  EXECUTE format(
+    'ALTER TABLE device.geolocation ATTACH PARTITION device.%I FOR VALUES FROM (%L) TO (%L)',
+    partition_name, range_start, range_end
+  );
+  RETURN true;
+END;
+$$ LANGUAGE plpgsql;
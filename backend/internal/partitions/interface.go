@@ -0,0 +1,10 @@
+package partitions
+
+import (
+	"context"
+)
+
+type Partitions interface {
+	Run(ctx context.Context) error
+	Stats() Stats
+}
@@ -0,0 +1,92 @@
+package partitions
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/database"
+)
+
+type RunStats struct {
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+	Created  int           `json:"created"`
+	Error    string        `json:"error,omitempty"`
+}
+
+type Stats struct {
+	DaysAhead int       `json:"days_ahead"`
+	Runs      int64     `json:"runs"`
+	LastRun   *RunStats `json:"last_run"`
+}
+
+// PartitionsImpl keeps daily geolocation partitions created ahead of time,
+// so inserts land in their own day's partition instead of the default one.
+// dropping expired partitions is left to retention, through DeleteGeolocationsBefore
+type PartitionsImpl struct {
+	repo      database.Repo
+	daysAhead int
+	interval  time.Duration
+
+	mu      sync.Mutex
+	runs    int64
+	lastRun *RunStats
+}
+
+func New(repo database.Repo, daysAhead int, interval time.Duration) *PartitionsImpl {
+	return &PartitionsImpl{
+		repo:      repo,
+		daysAhead: daysAhead,
+		interval:  interval,
+	}
+}
+
+func (p *PartitionsImpl) Run(ctx context.Context) error {
+	for {
+		stats := p.runOnce(ctx)
+		if stats.Error != "" {
+			fmt.Printf("partition maintenance failed: %v\n", stats.Error)
+		} else if stats.Created > 0 {
+			fmt.Printf("created %d geolocation partitions in %v\n", stats.Created, stats.Duration)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(p.interval):
+		}
+	}
+}
+
+func (p *PartitionsImpl) runOnce(ctx context.Context) *RunStats {
+	stats := &RunStats{
+		Started: time.Now(),
+	}
+	// yesterday too, in case the server was down over midnight
+	from := stats.Started.AddDate(0, 0, -1)
+	to := stats.Started.AddDate(0, 0, p.daysAhead)
+	created, err := p.repo.EnsureGeolocationPartitions(ctx, from, to)
+	if err != nil {
+		stats.Error = err.Error()
+	}
+	stats.Created = created
+	stats.Duration = time.Since(stats.Started)
+
+	p.mu.Lock()
+	p.runs++
+	p.lastRun = stats
+	p.mu.Unlock()
+	return stats
+}
+
+func (p *PartitionsImpl) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return Stats{
+		DaysAhead: p.daysAhead,
+		Runs:      p.runs,
+		LastRun:   p.lastRun,
+	}
+}
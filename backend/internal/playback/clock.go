@@ -0,0 +1,75 @@
+package playback
+
+import (
+	"fmt"
+	"time"
+)
+
+// the fastest replay, an hour a second
+const MaxSpeed = 3600
+
+func ValidateSpeed(speed float64) error {
+	if !(speed > 0 && speed <= MaxSpeed) {
+		return fmt.Errorf("speed must be above 0 and at most %v", MaxSpeed)
+	}
+	return nil
+}
+
+// Clock is the playback time, which runs at speed times wall time unless paused
+type Clock struct {
+	// the playback time at anchor
+	position time.Time
+	anchor   time.Time
+	speed    float64
+	paused   bool
+}
+
+func NewClock(start time.Time, speed float64) *Clock {
+	return &Clock{
+		position: start,
+		anchor:   time.Now(),
+		speed:    speed,
+	}
+}
+
+func (c *Clock) Now() time.Time {
+	if c.paused {
+		return c.position
+	}
+	elapsed := time.Duration(float64(time.Since(c.anchor)) * c.speed)
+	return c.position.Add(elapsed)
+}
+
+// restart re-anchors at the current playback time, before the speed or pause changes
+func (c *Clock) restart() {
+	c.position = c.Now()
+	c.anchor = time.Now()
+}
+
+func (c *Clock) Pause() {
+	c.restart()
+	c.paused = true
+}
+
+func (c *Clock) Play() {
+	c.restart()
+	c.paused = false
+}
+
+func (c *Clock) Seek(position time.Time) {
+	c.position = position
+	c.anchor = time.Now()
+}
+
+func (c *Clock) SetSpeed(speed float64) {
+	c.restart()
+	c.speed = speed
+}
+
+func (c *Clock) Speed() float64 {
+	return c.speed
+}
+
+func (c *Clock) Paused() bool {
+	return c.paused
+}
@@ -0,0 +1,106 @@
+package playback
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateSpeed(t *testing.T) {
+	tests := []struct {
+		speed float64
+		valid bool
+	}{
+		{speed: 1, valid: true},
+		{speed: 0.25, valid: true},
+		{speed: MaxSpeed, valid: true},
+		{speed: 0},
+		{speed: -1},
+		{speed: MaxSpeed + 1},
+	}
+	for _, test := range tests {
+		err := ValidateSpeed(test.speed)
+		if test.valid && err != nil {
+			t.Errorf("speed %v: unexpected error: %v", test.speed, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("speed %v: expected an error", test.speed)
+		}
+	}
+}
+
+func TestClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// wall time between steps, which is generous so slow machines don't fail
+	wait := 20 * time.Millisecond
+
+	tests := []struct {
+		name string
+		// run against the clock, which starts at start and speed 1000
+		run func(c *Clock)
+		// the earliest the clock may read afterwards, as an offset from start
+		atLeast time.Duration
+		// how far past atLeast the clock may be, or zero when it's paused and must read exactly atLeast
+		slack time.Duration
+	}{
+		{
+			name:    "runs at speed",
+			run:     func(c *Clock) { time.Sleep(wait) },
+			atLeast: 1000 * wait,
+			slack:   time.Hour,
+		},
+		{
+			name:    "paused at the start",
+			run:     func(c *Clock) { c.Pause(); c.Seek(start); time.Sleep(wait) },
+			atLeast: 0,
+		},
+		{
+			name: "stays paused while seeking",
+			run: func(c *Clock) {
+				c.Pause()
+				c.Seek(start.Add(time.Hour))
+				time.Sleep(wait)
+			},
+			atLeast: time.Hour,
+		},
+		{
+			name: "plays from where it was paused",
+			run: func(c *Clock) {
+				c.Pause()
+				c.Seek(start.Add(time.Hour))
+				time.Sleep(wait)
+				c.Play()
+				time.Sleep(wait)
+			},
+			atLeast: time.Hour + 1000*wait,
+			slack:   time.Hour,
+		},
+		{
+			name: "speed changes keep the time so far",
+			run: func(c *Clock) {
+				c.Pause()
+				c.Seek(start.Add(time.Hour))
+				c.SetSpeed(1)
+				c.Play()
+				time.Sleep(wait)
+			},
+			atLeast: time.Hour + wait,
+			slack:   time.Second,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := NewClock(start, 1000)
+			test.run(c)
+			elapsed := c.Now().Sub(start)
+			if test.slack == 0 {
+				if elapsed != test.atLeast {
+					t.Errorf("got %v, want exactly %v", elapsed, test.atLeast)
+				}
+				return
+			}
+			if elapsed < test.atLeast || elapsed > test.atLeast+test.slack {
+				t.Errorf("got %v, want between %v and %v", elapsed, test.atLeast, test.atLeast+test.slack)
+			}
+		})
+	}
+}
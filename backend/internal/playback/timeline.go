@@ -0,0 +1,100 @@
+package playback
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/database"
+	"github.com/NinjaPerson24119/MapProject/backend/internal/filters"
+)
+
+// deviceHistory pages through one device's history
+type deviceHistory struct {
+	deviceID string
+	page     []*database.DeviceGeolocation
+	cursor   string
+	// no more pages after this one
+	lastPage bool
+}
+
+// Timeline merges the stored history of several devices into one sequence ordered by event time.
+// it only holds a page of history per device, so long windows don't have to fit in memory
+type Timeline struct {
+	repo    database.Repo
+	orgID   string
+	history filters.HistoryOptions
+	devices []*deviceHistory
+}
+
+// NewTimeline replays event times in [start, end)
+func NewTimeline(repo database.Repo, orgID string, deviceIDs []string, start time.Time, end time.Time) *Timeline {
+	t := &Timeline{
+		repo:  repo,
+		orgID: orgID,
+		history: filters.HistoryOptions{
+			StartTime: start,
+			EndTime:   end,
+		},
+	}
+	for _, deviceID := range deviceIDs {
+		t.devices = append(t.devices, &deviceHistory{deviceID: deviceID})
+	}
+	return t
+}
+
+// fill fetches the device's next page once it has used up the current one
+func (t *Timeline) fill(ctx context.Context, d *deviceHistory) error {
+	for len(d.page) == 0 && !d.lastPage {
+		page, nextCursor, err := t.repo.ListGeolocationHistory(ctx, t.orgID, d.deviceID, t.history, filters.PageOptions{
+			PageSize: filters.MaxPageSize,
+			Cursor:   d.cursor,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get history for device %v: %v", d.deviceID, err)
+		}
+		d.page = page
+		d.cursor = nextCursor
+		d.lastPage = nextCursor == ""
+	}
+	return nil
+}
+
+// next is the device whose next geolocation is earliest, or nil when every device is done
+func (t *Timeline) next(ctx context.Context) (*deviceHistory, error) {
+	var earliest *deviceHistory
+	for _, d := range t.devices {
+		if err := t.fill(ctx, d); err != nil {
+			return nil, err
+		}
+		if len(d.page) == 0 {
+			continue
+		}
+		if earliest == nil || d.page[0].EventTime.Before(earliest.page[0].EventTime) {
+			earliest = d
+		}
+	}
+	return earliest, nil
+}
+
+// Until takes every geolocation with an event time up to and including until, in order
+func (t *Timeline) Until(ctx context.Context, until time.Time) ([]*database.DeviceGeolocation, error) {
+	geolocations := []*database.DeviceGeolocation{}
+	for {
+		d, err := t.next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if d == nil || d.page[0].EventTime.After(until) {
+			return geolocations, nil
+		}
+		geolocations = append(geolocations, d.page[0])
+		d.page = d.page[1:]
+	}
+}
+
+// Done is true once every geolocation has been taken
+func (t *Timeline) Done(ctx context.Context) (bool, error) {
+	d, err := t.next(ctx)
+	return d == nil, err
+}
@@ -0,0 +1,80 @@
+package playback
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/database"
+)
+
+func TestTimeline(t *testing.T) {
+	ctx := context.Background()
+	repo := database.NewMemory()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	deviceIDs := []string{}
+	for _, name := range []string{"alpha", "bravo", "silent"} {
+		id, err := repo.InsertDevice(ctx, database.DefaultOrgID, &database.Device{Name: name})
+		if err != nil {
+			t.Fatalf("failed to insert device: %v", err)
+		}
+		deviceIDs = append(deviceIDs, id)
+	}
+	// alpha reports every second for more than a page of 1000, and bravo every ten seconds between them
+	points := 1500
+	geolocations := []*database.DeviceGeolocation{}
+	for i := 0; i < points; i++ {
+		geolocations = append(geolocations, &database.DeviceGeolocation{DeviceID: deviceIDs[0], EventTime: start.Add(time.Duration(i) * time.Second)})
+		if i%10 == 0 {
+			geolocations = append(geolocations, &database.DeviceGeolocation{DeviceID: deviceIDs[1], EventTime: start.Add(time.Duration(i)*time.Second + time.Millisecond)})
+		}
+	}
+	// before the window, so never played
+	geolocations = append(geolocations, &database.DeviceGeolocation{DeviceID: deviceIDs[1], EventTime: start.Add(-time.Second)})
+	if err := repo.InsertMultiGeolocation(ctx, database.DefaultOrgID, geolocations); err != nil {
+		t.Fatalf("failed to insert geolocations: %v", err)
+	}
+	end := start.Add(time.Duration(points) * time.Second)
+	timeline := NewTimeline(repo, database.DefaultOrgID, deviceIDs, start, end)
+
+	tests := []struct {
+		name  string
+		until time.Time
+		want  int
+		done  bool
+	}{
+		{name: "nothing yet", until: start.Add(-time.Millisecond), want: 0},
+		{name: "exactly the first point", until: start, want: 1},
+		{name: "a second in", until: start.Add(time.Second), want: 2},
+		{name: "again, with nothing new", until: start.Add(time.Second), want: 0},
+		// alpha's points up to 1009s and bravo's up to 1000s, less the three already played
+		{name: "past the first page", until: start.Add(1009 * time.Second), want: 1010 + 101 - 3},
+		// alpha's from 1010s to 1499s and bravo's from 1010s to 1490s
+		{name: "the rest", until: end, want: 490 + 49, done: true},
+	}
+	var last time.Time
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			played, err := timeline.Until(ctx, test.until)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(played) != test.want {
+				t.Errorf("played %d, want %d", len(played), test.want)
+			}
+			for _, geolocation := range played {
+				if geolocation.EventTime.Before(last) || geolocation.EventTime.After(test.until) {
+					t.Fatalf("played %v out of order", geolocation.EventTime)
+				}
+				last = geolocation.EventTime
+			}
+			done, err := timeline.Done(ctx)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if done != test.done {
+				t.Errorf("got done %v, want %v", done, test.done)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package relay
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/database"
+)
+
+type OverflowPolicy string
+
+const (
+	// DropOldest discards the oldest queued geolocation to make room, favouring fresh data
+	DropOldest OverflowPolicy = "drop_oldest"
+	// DropNewest discards the incoming geolocation, favouring whatever is already queued
+	DropNewest OverflowPolicy = "drop_newest"
+	// Block makes the producer wait for room, which pushes backpressure onto the simulator or http handler
+	Block OverflowPolicy = "block"
+)
+
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch OverflowPolicy(s) {
+	case DropOldest, DropNewest, Block:
+		return OverflowPolicy(s), nil
+	}
+	return "", fmt.Errorf("unknown overflow policy: %v", s)
+}
+
+type queuedGeolocation struct {
+	orgID string
+	// how duplicates of what is stored are handled when persisting. empty for plain inserts, which are copied
+	duplicatePolicy database.DuplicatePolicy
+	geolocation     *database.DeviceGeolocation
+	enqueued        time.Time
+}
+
+// persistQueue is a bounded FIFO between the relay and the background persister
+type persistQueue struct {
+	mu       sync.Mutex
+	notFull  *sync.Cond
+	items    []queuedGeolocation
+	capacity int
+	policy   OverflowPolicy
+	closed   bool
+	// signalled whenever items are added, so the persister doesn't poll
+	wake chan struct{}
+
+	enqueued      int64
+	dropped       int64
+	highWatermark int
+}
+
+func newPersistQueue(capacity int, policy OverflowPolicy) *persistQueue {
+	q := &persistQueue{
+		capacity: capacity,
+		policy:   policy,
+		wake:     make(chan struct{}, 1),
+	}
+	q.notFull = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *persistQueue) push(orgID string, duplicatePolicy database.DuplicatePolicy, geolocations []*database.DeviceGeolocation) {
+	now := time.Now()
+	q.mu.Lock()
+	for _, geolocation := range geolocations {
+		for len(q.items) >= q.capacity && q.policy == Block && !q.closed {
+			q.notFull.Wait()
+		}
+		if len(q.items) >= q.capacity {
+			q.dropped++
+			// drop newest, or block after close where there is nobody left to wait for
+			if q.policy != DropOldest {
+				continue
+			}
+			q.items = q.items[1:]
+		}
+		// callers such as the simulator reuse their structs between steps, so keep our own copy
+		copied := *geolocation
+		copied.Telemetry = geolocation.Telemetry.Copy()
+		q.items = append(q.items, queuedGeolocation{orgID: orgID, duplicatePolicy: duplicatePolicy, geolocation: &copied, enqueued: now})
+		q.enqueued++
+		if len(q.items) > q.highWatermark {
+			q.highWatermark = len(q.items)
+		}
+	}
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *persistQueue) pop(max int) []queuedGeolocation {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := len(q.items)
+	if n > max {
+		n = max
+	}
+	batch := make([]queuedGeolocation, n)
+	copy(batch, q.items[:n])
+	q.items = q.items[n:]
+	q.notFull.Broadcast()
+	return batch
+}
+
+func (q *persistQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+func (q *persistQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.notFull.Broadcast()
+	q.mu.Unlock()
+}
+
+func (q *persistQueue) fillStats(stats *Stats) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	stats.Queued = len(q.items)
+	stats.QueueCapacity = q.capacity
+	stats.HighWatermark = q.highWatermark
+	stats.Enqueued = q.enqueued
+	stats.Dropped = q.dropped
+	if len(q.items) > 0 {
+		stats.OldestQueuedAge = time.Since(q.items[0].enqueued)
+	}
+}
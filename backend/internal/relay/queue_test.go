@@ -0,0 +1,156 @@
+package relay
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/database"
+)
+
+// at is a geolocation told apart from others by its latitude
+func at(latitude float64) *database.DeviceGeolocation {
+	return &database.DeviceGeolocation{DeviceID: "a", Latitude: latitude}
+}
+
+func latitudes(batch []queuedGeolocation) []float64 {
+	got := []float64{}
+	for _, queued := range batch {
+		got = append(got, queued.geolocation.Latitude)
+	}
+	return got
+}
+
+func TestPersistQueueOverflow(t *testing.T) {
+	tests := []struct {
+		policy       OverflowPolicy
+		want         []float64
+		wantEnqueued int64
+		wantDropped  int64
+	}{
+		// the incoming geolocation is enqueued in place of the one dropped
+		{policy: DropOldest, want: []float64{2, 3}, wantEnqueued: 3, wantDropped: 1},
+		{policy: DropNewest, want: []float64{1, 2}, wantEnqueued: 2, wantDropped: 1},
+	}
+	for _, test := range tests {
+		t.Run(string(test.policy), func(t *testing.T) {
+			q := newPersistQueue(2, test.policy)
+			q.push(database.DefaultOrgID, "", []*database.DeviceGeolocation{at(1), at(2), at(3)})
+
+			stats := Stats{}
+			q.fillStats(&stats)
+			if stats.Enqueued != test.wantEnqueued || stats.Dropped != test.wantDropped || stats.HighWatermark != 2 {
+				t.Errorf("got %d enqueued, %d dropped and a high watermark of %d", stats.Enqueued, stats.Dropped, stats.HighWatermark)
+			}
+			if got := latitudes(q.pop(10)); !slices.Equal(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPersistQueuePop(t *testing.T) {
+	q := newPersistQueue(10, DropOldest)
+	q.push(database.DefaultOrgID, "", []*database.DeviceGeolocation{at(1), at(2), at(3)})
+
+	tests := []struct {
+		max  int
+		want []float64
+	}{
+		{max: 2, want: []float64{1, 2}},
+		{max: 2, want: []float64{3}},
+		{max: 2, want: []float64{}},
+	}
+	for _, test := range tests {
+		if got := latitudes(q.pop(test.max)); !slices.Equal(got, test.want) {
+			t.Errorf("got %v, want %v", got, test.want)
+		}
+	}
+}
+
+func TestPersistQueueCopies(t *testing.T) {
+	q := newPersistQueue(10, DropOldest)
+	altitude := 100.0
+	geolocation := at(1)
+	geolocation.AltitudeMSL = &altitude
+	q.push(database.DefaultOrgID, database.KeepFirst, []*database.DeviceGeolocation{geolocation})
+	// like the simulator does between steps
+	geolocation.Latitude = 2
+	altitude = 200
+
+	queued := q.pop(1)[0]
+	if queued.orgID != database.DefaultOrgID || queued.duplicatePolicy != database.KeepFirst {
+		t.Errorf("got org %v and policy %v", queued.orgID, queued.duplicatePolicy)
+	}
+	if queued.geolocation.Latitude != 1 || *queued.geolocation.AltitudeMSL != 100 {
+		t.Errorf("got latitude %v and altitude %v", queued.geolocation.Latitude, *queued.geolocation.AltitudeMSL)
+	}
+}
+
+// pushInBackground returns a channel that's closed once the push returns
+func pushInBackground(q *persistQueue, geolocations ...*database.DeviceGeolocation) chan struct{} {
+	pushed := make(chan struct{})
+	go func() {
+		defer close(pushed)
+		q.push(database.DefaultOrgID, "", geolocations)
+	}()
+	return pushed
+}
+
+func returned(pushed chan struct{}) bool {
+	select {
+	case <-pushed:
+		return true
+	case <-time.After(50 * time.Millisecond):
+		return false
+	}
+}
+
+func TestPersistQueueBlock(t *testing.T) {
+	q := newPersistQueue(2, Block)
+	pushed := pushInBackground(q, at(1), at(2), at(3))
+	if returned(pushed) {
+		t.Fatalf("push returned while the queue was full")
+	}
+	if got := latitudes(q.pop(1)); !slices.Equal(got, []float64{1}) {
+		t.Errorf("got %v, want [1]", got)
+	}
+	if !returned(pushed) {
+		t.Fatalf("push didn't return once there was room")
+	}
+	if got := latitudes(q.pop(10)); !slices.Equal(got, []float64{2, 3}) {
+		t.Errorf("got %v, want [2 3]", got)
+	}
+	stats := Stats{}
+	q.fillStats(&stats)
+	if stats.Dropped != 0 {
+		t.Errorf("got %d dropped, want none", stats.Dropped)
+	}
+}
+
+func TestPersistQueueBlockAfterClose(t *testing.T) {
+	q := newPersistQueue(1, Block)
+	q.push(database.DefaultOrgID, "", []*database.DeviceGeolocation{at(1)})
+	waiting := pushInBackground(q, at(2))
+	if returned(waiting) {
+		t.Fatalf("push returned while the queue was full")
+	}
+
+	// closing releases producers that were waiting, and later ones don't wait, since nothing will make room
+	q.close()
+	if !returned(waiting) {
+		t.Fatalf("a waiting push didn't return after close")
+	}
+	if !returned(pushInBackground(q, at(3))) {
+		t.Fatalf("push waited after close")
+	}
+	stats := Stats{}
+	q.fillStats(&stats)
+	if stats.Dropped != 2 {
+		t.Errorf("got %d dropped, want 2", stats.Dropped)
+	}
+	// what was queued before closing is still there to be drained
+	if got := latitudes(q.pop(10)); !slices.Equal(got, []float64{1}) {
+		t.Errorf("got %v, want [1]", got)
+	}
+}
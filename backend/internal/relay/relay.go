@@ -0,0 +1,550 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/database"
+	"github.com/NinjaPerson24119/MapProject/backend/internal/filters"
+)
+
+type Config struct {
+	// maximum number of geolocations waiting to be persisted
+	QueueSize int
+	// maximum number of geolocations written per COPY
+	BatchSize int
+	// a partial batch is written after this long
+	FlushPeriod time.Duration
+	// what happens when the queue is full, because the database fell behind
+	Overflow OverflowPolicy
+	// a batch that fails on a transient error, like a dropped connection, is retried this many times.
+	// plain inserts that fail any other way fall back to row by row, so one bad row doesn't lose the rest
+	MaxRetries int
+	RetryDelay time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		QueueSize:   100000,
+		BatchSize:   1000,
+		FlushPeriod: time.Second / 2,
+		Overflow:    DropOldest,
+		MaxRetries:  3,
+		RetryDelay:  100 * time.Millisecond,
+	}
+}
+
+type Stats struct {
+	Queued          int           `json:"queued"`
+	QueueCapacity   int           `json:"queue_capacity"`
+	HighWatermark   int           `json:"high_watermark"`
+	OldestQueuedAge time.Duration `json:"oldest_queued_age"`
+	Enqueued        int64         `json:"enqueued"`
+	Dropped         int64         `json:"dropped"`
+	Persisted       int64         `json:"persisted"`
+	// geolocations that turned out to be stored already when persisting, and ingested ones the duplicate policy rejected
+	Duplicates    int64         `json:"duplicates"`
+	Rejected      int64         `json:"rejected"`
+	FailedRows    int64         `json:"failed_rows"`
+	Batches       int64         `json:"batches"`
+	LastBatchRows int           `json:"last_batch_rows"`
+	LastBatchTime time.Duration `json:"last_batch_time"`
+	LastError     string        `json:"last_error,omitempty"`
+}
+
+// RelayRepo is a write-behind decorator for another Repo.
+// inserted geolocations are streamed to listeners straight away and persisted in the background in batches,
+// so the database is no longer on the hot path between devices and websockets.
+//
+// if the database falls behind, the bounded queue fills up and the overflow policy decides what gets dropped.
+// a batch that fails on something like a lost connection is retried, and one that fails any other way is inserted row by row
+// so a single bad row doesn't sink the others.
+// reads go to the wrapped repo, with the latest positions overlaid from what has been relayed but maybe not persisted yet.
+// a device shows up in listings of latest positions once its first geolocation is persisted, and straight away when asked for by id
+//
+// relayed geolocations are only accepted for devices in the caller's org. which org a device is in is looked up once and remembered,
+// so a device moved through another server keeps streaming to its old org here until restart
+type RelayRepo struct {
+	database.Repo
+
+	config Config
+	hub    *database.NotificationHub
+	queue  *persistQueue
+
+	muLatest sync.RWMutex
+	latest   map[string]*database.DeviceGeolocation
+	// devices deleted through this relay, which stop streaming like they would from the insert trigger.
+	// devices deleted before it started, or through another server, aren't known here and keep streaming
+	deleted map[string]bool
+	// the org of every device relayed so far
+	orgs map[string]string
+
+	muStats       sync.Mutex
+	persisted     int64
+	duplicates    int64
+	rejected      int64
+	failedRows    int64
+	batches       int64
+	lastBatchRows int
+	lastBatchTime time.Duration
+	lastError     string
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func New(repo database.Repo, config Config) *RelayRepo {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &RelayRepo{
+		Repo:    repo,
+		config:  config,
+		hub:     database.NewNotificationHub(),
+		queue:   newPersistQueue(config.QueueSize, config.Overflow),
+		latest:  map[string]*database.DeviceGeolocation{},
+		deleted: map[string]bool{},
+		orgs:    map[string]string{},
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	go r.persist(ctx)
+	return r
+}
+
+// Close writes out whatever is still queued before closing the wrapped repo
+func (r *RelayRepo) Close() {
+	r.cancel()
+	<-r.done
+	r.Repo.Close()
+	r.hub.CloseAll()
+}
+
+// relay streams geolocations and queues them to be persisted. policy is empty for plain inserts
+func (r *RelayRepo) relay(ctx context.Context, orgID string, geolocations []*database.DeviceGeolocation, policy database.DuplicatePolicy) error {
+	for _, geolocation := range geolocations {
+		if geolocation.DeviceID == "" {
+			return fmt.Errorf("missing device_id")
+		}
+		if err := database.ValidateGeolocation(geolocation); err != nil {
+			return err
+		}
+		if err := r.checkOrg(ctx, orgID, geolocation.DeviceID); err != nil {
+			return err
+		}
+	}
+
+	// only last write wins moves a device to a corrected position with the same event time, like it will when stored
+	r.publish(orgID, geolocations, policy == database.LastWriteWins)
+	r.queue.push(orgID, policy, geolocations)
+	return nil
+}
+
+// checkOrg fails for devices outside the org, like the wrapped repo would when persisting
+func (r *RelayRepo) checkOrg(ctx context.Context, orgID string, deviceID string) error {
+	r.muLatest.RLock()
+	known, ok := r.orgs[deviceID]
+	r.muLatest.RUnlock()
+	if !ok {
+		_, err := r.Repo.GetDevice(ctx, orgID, deviceID)
+		if errors.Is(err, database.ErrNotFound) {
+			return fmt.Errorf("device %v: %w", deviceID, database.ErrNotFound)
+		}
+		if err != nil {
+			return err
+		}
+		r.muLatest.Lock()
+		r.orgs[deviceID] = orgID
+		r.muLatest.Unlock()
+		known = orgID
+	}
+	if known != orgID {
+		return fmt.Errorf("device %v: %w", deviceID, database.ErrNotFound)
+	}
+	return nil
+}
+
+// publish updates the latest positions and tells listeners.
+// replace is for positions that were changed in place, which have the same event time as what they replace
+func (r *RelayRepo) publish(orgID string, geolocations []*database.DeviceGeolocation, replace bool) {
+	notifications := make([]*database.DeviceGeolocation, 0, len(geolocations))
+	r.muLatest.Lock()
+	for _, geolocation := range geolocations {
+		if !r.deleted[geolocation.DeviceID] {
+			notifications = append(notifications, database.NotificationFor(geolocation))
+		}
+		current, ok := r.latest[geolocation.DeviceID]
+		if ok && geolocation.EventTime.Before(current.EventTime) {
+			continue
+		}
+		if ok && geolocation.EventTime.Equal(current.EventTime) && !replace {
+			continue
+		}
+		copied := *geolocation
+		copied.Telemetry = geolocation.Telemetry.Copy()
+		r.latest[geolocation.DeviceID] = &copied
+	}
+	r.muLatest.Unlock()
+
+	for _, notification := range notifications {
+		r.hub.Publish(orgID, notification)
+	}
+}
+
+func (r *RelayRepo) InsertGeolocation(ctx context.Context, orgID string, geolocation *database.DeviceGeolocation) error {
+	err := r.relay(ctx, orgID, []*database.DeviceGeolocation{geolocation}, "")
+	if err != nil {
+		return fmt.Errorf("failed to relay geolocation: %w", err)
+	}
+	return nil
+}
+
+func (r *RelayRepo) InsertMultiGeolocation(ctx context.Context, orgID string, geolocations []*database.DeviceGeolocation) error {
+	err := r.relay(ctx, orgID, geolocations, "")
+	if err != nil {
+		return fmt.Errorf("failed to relay multi geolocation: %w", err)
+	}
+	return nil
+}
+
+// CopyMultiGeolocation reports the time taken to relay, since persisting happens later
+func (r *RelayRepo) CopyMultiGeolocation(ctx context.Context, orgID string, geolocations []*database.DeviceGeolocation) (*database.IngestStats, error) {
+	before := time.Now()
+	err := r.relay(ctx, orgID, geolocations, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to relay multi geolocation: %w", err)
+	}
+	return &database.IngestStats{
+		Rows:     len(geolocations),
+		Duration: time.Since(before),
+	}, nil
+}
+
+// IngestMultiGeolocation is relayed like the other inserts, so streams stay ahead of the database.
+// whether a geolocation duplicates what is stored isn't known until it's persisted, so each one is reported as queued
+// and the policy is applied then. one the policy rejects may already have been streamed
+func (r *RelayRepo) IngestMultiGeolocation(ctx context.Context, orgID string, geolocations []*database.DeviceGeolocation, policy database.DuplicatePolicy) ([]*database.IngestResult, error) {
+	if _, err := database.ParseDuplicatePolicy(string(policy)); err != nil {
+		return nil, fmt.Errorf("relay: %v", err)
+	}
+	err := r.relay(ctx, orgID, geolocations, policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to relay multi geolocation: %w", err)
+	}
+	results := make([]*database.IngestResult, len(geolocations))
+	for i, geolocation := range geolocations {
+		results[i] = &database.IngestResult{
+			DeviceID:  geolocation.DeviceID,
+			EventTime: geolocation.EventTime,
+			Status:    database.IngestQueued,
+		}
+	}
+	return results, nil
+}
+
+// overlay replaces geolocations with relayed ones that are newer.
+// nil entries are filled in for devices whose only positions are relayed and not persisted yet,
+// unless they were deleted through this relay or are in another org
+func (r *RelayRepo) overlay(orgID string, deviceIDs []string, geolocations []*database.DeviceGeolocation) {
+	r.muLatest.RLock()
+	defer r.muLatest.RUnlock()
+	for i, deviceID := range deviceIDs {
+		relayed, ok := r.latest[deviceID]
+		if !ok {
+			continue
+		}
+		if geolocations[i] == nil && (r.deleted[deviceID] || r.orgs[deviceID] != orgID) {
+			continue
+		}
+		if geolocations[i] == nil || relayed.EventTime.After(geolocations[i].EventTime) {
+			copied := *relayed
+			copied.Telemetry = relayed.Telemetry.Copy()
+			geolocations[i] = &copied
+		}
+	}
+}
+
+func (r *RelayRepo) ListLatestGeolocations(ctx context.Context, orgID string, paging filters.PageOptions, spatial filters.SpatialFilter, device filters.DeviceFilter) ([]*database.DeviceGeolocation, string, error) {
+	geolocations, nextCursor, err := r.Repo.ListLatestGeolocations(ctx, orgID, paging, spatial, device)
+	if err != nil {
+		return nil, "", err
+	}
+	deviceIDs := make([]string, len(geolocations))
+	for i, geolocation := range geolocations {
+		deviceIDs[i] = geolocation.DeviceID
+	}
+	r.overlay(orgID, deviceIDs, geolocations)
+	// the database matched its own rows, and a newer relayed position may have left the area since
+	matching := geolocations[:0]
+	for _, geolocation := range geolocations {
+		if spatial.Contains(geolocation.Latitude, geolocation.Longitude) {
+			matching = append(matching, geolocation)
+		}
+	}
+	return matching, nextCursor, nil
+}
+
+func (r *RelayRepo) GetMultiLatestGeolocations(ctx context.Context, orgID string, deviceIDs []string) ([]*database.DeviceGeolocation, error) {
+	geolocations, err := r.Repo.GetMultiLatestGeolocations(ctx, orgID, deviceIDs)
+	if err != nil {
+		return nil, err
+	}
+	r.overlay(orgID, deviceIDs, geolocations)
+	return geolocations, nil
+}
+
+func (r *RelayRepo) setDeleted(orgID string, deviceID string, deleted bool) {
+	r.muLatest.Lock()
+	if deleted {
+		r.deleted[deviceID] = true
+	} else {
+		delete(r.deleted, deviceID)
+	}
+	r.muLatest.Unlock()
+	r.hub.Publish(orgID, &database.DeviceGeolocation{DeviceID: deviceID})
+}
+
+// UpdateDevice tells our own listeners, since streams may filter on what changed
+func (r *RelayRepo) UpdateDevice(ctx context.Context, orgID string, update *database.DeviceUpdate) (*database.Device, error) {
+	device, err := r.Repo.UpdateDevice(ctx, orgID, update)
+	if err != nil {
+		return nil, err
+	}
+	r.hub.Publish(orgID, &database.DeviceGeolocation{DeviceID: update.DeviceID})
+	return device, nil
+}
+
+// DeleteDevice tells our own listeners, since they no longer hear from the wrapped repo
+func (r *RelayRepo) DeleteDevice(ctx context.Context, orgID string, deviceID string) (*database.Device, error) {
+	device, err := r.Repo.DeleteDevice(ctx, orgID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	r.setDeleted(orgID, deviceID, true)
+	return device, nil
+}
+
+func (r *RelayRepo) RestoreDevice(ctx context.Context, orgID string, deviceID string) (*database.Device, error) {
+	device, err := r.Repo.RestoreDevice(ctx, orgID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	r.setDeleted(orgID, deviceID, false)
+	return device, nil
+}
+
+// MoveDevice tells the listeners of both orgs, and relays the device's geolocations to its new org from now on
+func (r *RelayRepo) MoveDevice(ctx context.Context, deviceID string, orgID string) (*database.Device, error) {
+	r.muLatest.RLock()
+	from, known := r.orgs[deviceID]
+	r.muLatest.RUnlock()
+	device, err := r.Repo.MoveDevice(ctx, deviceID, orgID)
+	if err != nil {
+		return nil, err
+	}
+	r.muLatest.Lock()
+	r.orgs[deviceID] = orgID
+	r.muLatest.Unlock()
+	if known && from != orgID {
+		r.hub.Publish(from, &database.DeviceGeolocation{DeviceID: deviceID})
+	}
+	r.hub.Publish(orgID, &database.DeviceGeolocation{DeviceID: deviceID})
+	return device, nil
+}
+
+// ListenToGeolocationInserted hears about geolocations as soon as they are relayed, not when they are persisted
+func (r *RelayRepo) ListenToGeolocationInserted(ctx context.Context, orgID string, handler func(*database.DeviceGeolocation) error, resync func() error) error {
+	return r.hub.Listen(ctx, orgID, handler, resync)
+}
+
+func (r *RelayRepo) ListenerStats() database.ListenerStats {
+	return r.hub.Stats()
+}
+
+func (r *RelayRepo) Stats() Stats {
+	stats := Stats{}
+	r.queue.fillStats(&stats)
+	r.muStats.Lock()
+	defer r.muStats.Unlock()
+	stats.Persisted = r.persisted
+	stats.Duplicates = r.duplicates
+	stats.Rejected = r.rejected
+	stats.FailedRows = r.failedRows
+	stats.Batches = r.batches
+	stats.LastBatchRows = r.lastBatchRows
+	stats.LastBatchTime = r.lastBatchTime
+	stats.LastError = r.lastError
+	return stats
+}
+
+func (r *RelayRepo) persist(ctx context.Context) {
+	defer close(r.done)
+	ticker := time.NewTicker(r.config.FlushPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// drain what's left before the wrapped repo is closed
+			r.queue.close()
+			for r.queue.len() > 0 {
+				r.persistBatch(r.queue.pop(r.config.BatchSize))
+			}
+			return
+		case <-ticker.C:
+		case <-r.queue.wake:
+			if r.queue.len() < r.config.BatchSize {
+				continue
+			}
+		}
+
+		for r.queue.len() > 0 {
+			r.persistBatch(r.queue.pop(r.config.BatchSize))
+			if r.queue.len() < r.config.BatchSize {
+				break
+			}
+		}
+	}
+}
+
+type batchKey struct {
+	orgID  string
+	policy database.DuplicatePolicy
+}
+
+// persistBatch writes each org's geolocations separately, and ingested ones separately from inserted ones, in the order they were queued
+func (r *RelayRepo) persistBatch(batch []queuedGeolocation) {
+	keys := []batchKey{}
+	byKey := map[batchKey][]*database.DeviceGeolocation{}
+	for _, queued := range batch {
+		key := batchKey{queued.orgID, queued.duplicatePolicy}
+		if _, ok := byKey[key]; !ok {
+			keys = append(keys, key)
+		}
+		byKey[key] = append(byKey[key], queued.geolocation)
+	}
+	for _, key := range keys {
+		if key.policy == "" {
+			r.persistOrgBatch(key.orgID, byKey[key])
+		} else {
+			r.persistIngestBatch(key.orgID, key.policy, byKey[key])
+		}
+	}
+}
+
+// persistIngestBatch applies the duplicate policy that geolocations were ingested with, now that what is stored can be compared
+func (r *RelayRepo) persistIngestBatch(orgID string, policy database.DuplicatePolicy, batch []*database.DeviceGeolocation) {
+	ctx := context.Background()
+	before := time.Now()
+
+	var results []*database.IngestResult
+	err := r.retry(func() error {
+		var err error
+		results, err = r.Repo.IngestMultiGeolocation(ctx, orgID, batch, policy)
+		return err
+	})
+	if err != nil {
+		fmt.Printf("relay failed to persist batch of %d ingested geolocations: %v\n", len(batch), err)
+		r.recordBatch(0, len(batch), time.Since(before), err)
+		return
+	}
+
+	persisted := 0
+	duplicates := 0
+	rejected := 0
+	for _, result := range results {
+		switch result.Status {
+		case database.IngestInserted, database.IngestUpdated:
+			persisted++
+		case database.IngestRejected:
+			rejected++
+		default:
+			duplicates++
+		}
+	}
+	r.recordBatch(persisted, 0, time.Since(before), nil)
+	r.recordSkipped(duplicates, rejected)
+}
+
+// persistOrgBatch deliberately ignores the shutdown context, so stopping the relay doesn't abort writes that are in flight
+func (r *RelayRepo) persistOrgBatch(orgID string, batch []*database.DeviceGeolocation) {
+	if len(batch) == 0 {
+		return
+	}
+	ctx := context.Background()
+	before := time.Now()
+
+	// points that are already stored are skipped, so only something like a lost connection fails the batch
+	var stats *database.IngestStats
+	err := r.retry(func() error {
+		var err error
+		stats, err = r.Repo.CopyMultiGeolocation(ctx, orgID, batch)
+		return err
+	})
+	if err == nil {
+		r.recordBatch(stats.Rows, 0, stats.Duration, nil)
+		r.recordSkipped(stats.Duplicates, 0)
+		return
+	}
+	if database.IsTransient(err) {
+		fmt.Printf("relay failed to persist batch of %d geolocations: %v\n", len(batch), err)
+		r.recordBatch(0, len(batch), time.Since(before), err)
+		return
+	}
+	fmt.Printf("relay failed to persist batch of %d geolocations, falling back to row by row: %v\n", len(batch), err)
+
+	// one bad row fails the whole COPY, so salvage the rest
+	persisted := 0
+	duplicates := 0
+	failed := 0
+	var rowErr error
+	for _, geolocation := range batch {
+		stats, err := r.Repo.CopyMultiGeolocation(ctx, orgID, []*database.DeviceGeolocation{geolocation})
+		if err != nil {
+			failed++
+			rowErr = err
+			continue
+		}
+		persisted += stats.Rows
+		duplicates += stats.Duplicates
+	}
+	r.recordBatch(persisted, failed, time.Since(before), rowErr)
+	r.recordSkipped(duplicates, 0)
+}
+
+// retry calls write until it succeeds, fails in a way that retrying won't fix, or runs out of retries
+func (r *RelayRepo) retry(write func() error) error {
+	var err error
+	for attempt := 0; attempt <= r.config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(r.config.RetryDelay * time.Duration(1<<(attempt-1)))
+		}
+		err = write()
+		if err == nil || !database.IsTransient(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// recordSkipped counts points that were already stored, or that the duplicate policy rejected
+func (r *RelayRepo) recordSkipped(duplicates int, rejected int) {
+	r.muStats.Lock()
+	defer r.muStats.Unlock()
+	r.duplicates += int64(duplicates)
+	r.rejected += int64(rejected)
+}
+
+func (r *RelayRepo) recordBatch(persisted int, failed int, duration time.Duration, err error) {
+	r.muStats.Lock()
+	defer r.muStats.Unlock()
+	r.persisted += int64(persisted)
+	r.failedRows += int64(failed)
+	r.batches++
+	r.lastBatchRows = persisted
+	r.lastBatchTime = duration
+	if err != nil {
+		r.lastError = err.Error()
+	}
+}
@@ -0,0 +1,134 @@
+package relay
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/database"
+)
+
+// newTestRelay relays to the repo without persisting in the background, so tests can call persistBatch themselves
+func newTestRelay(repo database.Repo) *RelayRepo {
+	config := DefaultConfig()
+	return &RelayRepo{
+		Repo:    repo,
+		config:  config,
+		hub:     database.NewNotificationHub(),
+		queue:   newPersistQueue(config.QueueSize, config.Overflow),
+		latest:  map[string]*database.DeviceGeolocation{},
+		deleted: map[string]bool{},
+		orgs:    map[string]string{},
+	}
+}
+
+func TestPersistBatch(t *testing.T) {
+	ctx := context.Background()
+	repo := database.NewMemory()
+	otherOrgID, err := repo.InsertOrganization(ctx, &database.Organization{Name: "other"})
+	if err != nil {
+		t.Fatalf("failed to insert org: %v", err)
+	}
+	deviceID, err := repo.InsertDevice(ctx, database.DefaultOrgID, &database.Device{Name: "alpha"})
+	if err != nil {
+		t.Fatalf("failed to insert device: %v", err)
+	}
+	otherDeviceID, err := repo.InsertDevice(ctx, otherOrgID, &database.Device{Name: "bravo"})
+	if err != nil {
+		t.Fatalf("failed to insert device: %v", err)
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	point := func(deviceID string, seconds int, latitude float64) *database.DeviceGeolocation {
+		return &database.DeviceGeolocation{DeviceID: deviceID, EventTime: start.Add(time.Duration(seconds) * time.Second), Latitude: latitude}
+	}
+	if err := repo.InsertGeolocation(ctx, database.DefaultOrgID, point(deviceID, 0, 1)); err != nil {
+		t.Fatalf("failed to insert geolocation: %v", err)
+	}
+
+	queued := func(orgID string, policy database.DuplicatePolicy, geolocation *database.DeviceGeolocation) queuedGeolocation {
+		return queuedGeolocation{orgID: orgID, duplicatePolicy: policy, geolocation: geolocation}
+	}
+	tests := []struct {
+		name  string
+		batch []queuedGeolocation
+		// each device's latitude at the last time it has in the batch, afterwards
+		want           map[string]float64
+		wantPersisted  int64
+		wantDuplicates int64
+		wantRejected   int64
+		wantFailed     int64
+	}{
+		{
+			name: "orgs are written separately",
+			batch: []queuedGeolocation{
+				queued(database.DefaultOrgID, "", point(deviceID, 1, 2)),
+				queued(otherOrgID, "", point(otherDeviceID, 1, 3)),
+			},
+			want:          map[string]float64{deviceID: 2, otherDeviceID: 3},
+			wantPersisted: 2,
+		},
+		{
+			name: "stored points are skipped without failing the rest",
+			batch: []queuedGeolocation{
+				queued(database.DefaultOrgID, "", point(deviceID, 0, 1)),
+				queued(database.DefaultOrgID, "", point(deviceID, 2, 4)),
+			},
+			want:           map[string]float64{deviceID: 4},
+			wantPersisted:  1,
+			wantDuplicates: 1,
+		},
+		{
+			// if they were copied with the plain inserts, the replacement would be skipped as a duplicate
+			name: "ingested points get their own policy",
+			batch: []queuedGeolocation{
+				queued(database.DefaultOrgID, "", point(deviceID, 3, 5)),
+				queued(database.DefaultOrgID, database.LastWriteWins, point(deviceID, 3, 6)),
+				queued(otherOrgID, "", point(otherDeviceID, 3, 7)),
+				queued(otherOrgID, database.RejectDuplicates, point(otherDeviceID, 3, 8)),
+			},
+			want:          map[string]float64{deviceID: 6, otherDeviceID: 7},
+			wantPersisted: 3,
+			wantRejected:  1,
+		},
+		{
+			name: "a bad row falls back to row by row",
+			batch: []queuedGeolocation{
+				queued(database.DefaultOrgID, "", point(deviceID, 4, 9)),
+				queued(database.DefaultOrgID, "", point(otherDeviceID, 4, 10)),
+			},
+			want:          map[string]float64{deviceID: 9},
+			wantPersisted: 1,
+			wantFailed:    1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newTestRelay(repo)
+			r.persistBatch(test.batch)
+
+			stats := r.Stats()
+			if stats.Persisted != test.wantPersisted || stats.Duplicates != test.wantDuplicates || stats.Rejected != test.wantRejected || stats.FailedRows != test.wantFailed {
+				t.Errorf("got %d persisted, %d duplicates, %d rejected and %d failed", stats.Persisted, stats.Duplicates, stats.Rejected, stats.FailedRows)
+			}
+			for id, latitude := range test.want {
+				orgID := database.DefaultOrgID
+				if id == otherDeviceID {
+					orgID = otherOrgID
+				}
+				var at time.Time
+				for _, queued := range test.batch {
+					if queued.geolocation.DeviceID == id && queued.geolocation.EventTime.After(at) {
+						at = queued.geolocation.EventTime
+					}
+				}
+				stored, err := repo.GetMultiGeolocationsAt(ctx, orgID, []string{id}, at)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if stored[0] == nil || !stored[0].EventTime.Equal(at) || stored[0].Latitude != latitude {
+					t.Errorf("device %v: got %+v, want latitude %v", id, stored[0], latitude)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,10 @@
+package retention
+
+import (
+	"context"
+)
+
+type Retention interface {
+	Run(ctx context.Context) error
+	Stats() Stats
+}
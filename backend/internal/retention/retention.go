@@ -0,0 +1,229 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/database"
+)
+
+type Tier struct {
+	// geolocations older than this are thinned out to the resolution
+	OlderThan  time.Duration `json:"older_than"`
+	Resolution time.Duration `json:"resolution"`
+}
+
+type Policy struct {
+	// tiers apply until the next tier's age takes over, so they should get coarser as they get older
+	Tiers []Tier `json:"tiers"`
+	// geolocations older than this are deleted outright. zero keeps them forever
+	MaxAge time.Duration `json:"max_age"`
+}
+
+// Enabled is false for a policy that keeps everything, which there's no point running
+func (p Policy) Enabled() bool {
+	return len(p.Tiers) > 0 || p.MaxAge > 0
+}
+
+// ParseTiers reads tiers written as comma separated age=resolution pairs.
+// e.g. 24h=1s,720h=1m keeps full resolution for a day, 1 sample/sec for 30 days, and 1 sample/min after that
+func ParseTiers(s string) ([]Tier, error) {
+	tiers := []Tier{}
+	for _, pair := range strings.Split(s, ",") {
+		olderThan, resolution, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid retention tier, expected age=resolution: %q", pair)
+		}
+		tier := Tier{}
+		var err error
+		tier.OlderThan, err = time.ParseDuration(olderThan)
+		if err != nil || tier.OlderThan <= 0 {
+			return nil, fmt.Errorf("invalid retention tier age: %q", olderThan)
+		}
+		tier.Resolution, err = time.ParseDuration(resolution)
+		if err != nil || tier.Resolution <= 0 {
+			return nil, fmt.Errorf("invalid retention tier resolution: %q", resolution)
+		}
+		tiers = append(tiers, tier)
+	}
+	return tiers, nil
+}
+
+type TierStats struct {
+	Tier      Tier      `json:"tier"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Compacted int64     `json:"compacted"`
+}
+
+type RunStats struct {
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+	Deleted  int64         `json:"deleted"`
+	Tiers    []TierStats   `json:"tiers"`
+	Error    string        `json:"error,omitempty"`
+}
+
+func (s *RunStats) compacted() int64 {
+	var compacted int64
+	for _, tier := range s.Tiers {
+		compacted += tier.Compacted
+	}
+	return compacted
+}
+
+type TierProgress struct {
+	Tier Tier `json:"tier"`
+	// history in the tier's window from before this has been compacted, so later runs start here. nil until the tier's first run
+	CompactedTo *time.Time `json:"compacted_to"`
+}
+
+type Stats struct {
+	Policy   Policy         `json:"policy"`
+	Runs     int64          `json:"runs"`
+	LastRun  *RunStats      `json:"last_run"`
+	Progress []TierProgress `json:"progress"`
+}
+
+// RetentionImpl compacts each tier from where its last run stopped, so a run only covers what aged into the tier since.
+// that's kept in memory, so the first run after a restart goes over everything again,
+// which is also when history imported with event times a tier has already passed gets compacted
+type RetentionImpl struct {
+	repo     database.Repo
+	policy   Policy
+	interval time.Duration
+
+	mu      sync.Mutex
+	runs    int64
+	lastRun *RunStats
+	// by tier, in the order of policy.Tiers. only runs change it, and they don't overlap, so they read it without the lock
+	compactedTo []time.Time
+}
+
+func New(repo database.Repo, policy Policy, interval time.Duration) *RetentionImpl {
+	tiers := append([]Tier{}, policy.Tiers...)
+	sort.Slice(tiers, func(i, j int) bool {
+		return tiers[i].OlderThan < tiers[j].OlderThan
+	})
+	policy.Tiers = tiers
+
+	return &RetentionImpl{
+		repo:        repo,
+		policy:      policy,
+		interval:    interval,
+		compactedTo: make([]time.Time, len(tiers)),
+	}
+}
+
+func (r *RetentionImpl) Run(ctx context.Context) error {
+	for {
+		stats := r.runOnce(ctx)
+		if stats.Error != "" {
+			fmt.Printf("retention run failed: %v\n", stats.Error)
+		} else {
+			fmt.Printf("retention run deleted %d and compacted %d geolocations in %v\n", stats.Deleted, stats.compacted(), stats.Duration)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(r.interval):
+		}
+	}
+}
+
+func (r *RetentionImpl) runOnce(ctx context.Context) *RunStats {
+	stats := &RunStats{
+		Started: time.Now(),
+		Tiers:   []TierStats{},
+	}
+	err := r.apply(ctx, stats)
+	if err != nil {
+		stats.Error = err.Error()
+	}
+	stats.Duration = time.Since(stats.Started)
+
+	r.mu.Lock()
+	r.runs++
+	r.lastRun = stats
+	r.mu.Unlock()
+	return stats
+}
+
+func (r *RetentionImpl) apply(ctx context.Context, stats *RunStats) error {
+	now := stats.Started
+
+	// everything before this is either deleted, or untouched when we keep history forever
+	var oldest time.Time
+	if r.policy.MaxAge > 0 {
+		oldest = now.Add(-r.policy.MaxAge)
+		deleted, err := r.repo.DeleteGeolocationsBefore(ctx, oldest)
+		if err != nil {
+			return err
+		}
+		stats.Deleted = deleted
+	}
+
+	// each tier covers the window between its own age and the next tier's age, less what earlier runs already compacted
+	for i, tier := range r.policy.Tiers {
+		// ending on a bucket boundary, so the next run doesn't keep a second sample from the bucket this one stopped in
+		to := alignDown(now.Add(-tier.OlderThan), tier.Resolution)
+		from := oldest
+		if i+1 < len(r.policy.Tiers) {
+			from = now.Add(-r.policy.Tiers[i+1].OlderThan)
+		}
+		if !oldest.IsZero() && from.Before(oldest) {
+			from = oldest
+		}
+		if from.Before(r.compactedTo[i]) {
+			from = r.compactedTo[i]
+		}
+		if !from.Before(to) {
+			continue
+		}
+
+		compacted, err := r.repo.CompactGeolocations(ctx, from, to, tier.Resolution)
+		if err != nil {
+			return err
+		}
+		r.mu.Lock()
+		r.compactedTo[i] = to
+		r.mu.Unlock()
+		stats.Tiers = append(stats.Tiers, TierStats{
+			Tier:      tier,
+			From:      from,
+			To:        to,
+			Compacted: compacted,
+		})
+	}
+	return nil
+}
+
+// alignDown rounds down to a multiple of the resolution since the unix epoch, which is where the repo's buckets start
+func alignDown(t time.Time, resolution time.Duration) time.Time {
+	epoch := time.Unix(0, 0)
+	return epoch.Add(t.Sub(epoch).Truncate(resolution))
+}
+
+func (r *RetentionImpl) Stats() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	progress := make([]TierProgress, len(r.policy.Tiers))
+	for i, tier := range r.policy.Tiers {
+		progress[i] = TierProgress{Tier: tier}
+		if !r.compactedTo[i].IsZero() {
+			compactedTo := r.compactedTo[i]
+			progress[i].CompactedTo = &compactedTo
+		}
+	}
+	return Stats{
+		Policy:   r.policy,
+		Runs:     r.runs,
+		LastRun:  r.lastRun,
+		Progress: progress,
+	}
+}
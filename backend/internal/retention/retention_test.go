@@ -0,0 +1,134 @@
+package retention
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/database"
+	"github.com/NinjaPerson24119/MapProject/backend/internal/filters"
+)
+
+func TestParseTiers(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []Tier
+		valid bool
+	}{
+		{
+			value: "24h=1s,720h=1m",
+			want:  []Tier{{OlderThan: 24 * time.Hour, Resolution: time.Second}, {OlderThan: 720 * time.Hour, Resolution: time.Minute}},
+			valid: true,
+		},
+		{value: " 1h=10s ", want: []Tier{{OlderThan: time.Hour, Resolution: 10 * time.Second}}, valid: true},
+		{value: "24h"},
+		{value: "24h=1s,"},
+		{value: "a day=1s"},
+		{value: "24h=0s"},
+		{value: "-24h=1s"},
+	}
+	for _, test := range tests {
+		got, err := ParseTiers(test.value)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.value, err)
+			continue
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("%q: got %v, want %v", test.value, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%q: got %v, want %v", test.value, got, test.want)
+			}
+		}
+	}
+}
+
+func TestPolicyEnabled(t *testing.T) {
+	if (Policy{}).Enabled() {
+		t.Errorf("a policy that keeps everything is enabled")
+	}
+	if !(Policy{MaxAge: time.Hour}).Enabled() {
+		t.Errorf("a policy with a max age isn't enabled")
+	}
+	if !(Policy{Tiers: []Tier{{OlderThan: time.Hour, Resolution: time.Second}}}).Enabled() {
+		t.Errorf("a policy with tiers isn't enabled")
+	}
+}
+
+func TestRunsStartWhereTheLastOneStopped(t *testing.T) {
+	ctx := context.Background()
+	repo := database.NewMemory()
+	deviceID, err := repo.InsertDevice(ctx, database.DefaultOrgID, &database.Device{Name: "alpha"})
+	if err != nil {
+		t.Fatalf("failed to insert device: %v", err)
+	}
+	// a point every second for ten minutes
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	geolocations := []*database.DeviceGeolocation{}
+	for i := 0; i < 600; i++ {
+		geolocations = append(geolocations, &database.DeviceGeolocation{DeviceID: deviceID, EventTime: start.Add(time.Duration(i) * time.Second)})
+	}
+	if err := repo.InsertMultiGeolocation(ctx, database.DefaultOrgID, geolocations); err != nil {
+		t.Fatalf("failed to insert geolocations: %v", err)
+	}
+	r := New(repo, Policy{Tiers: []Tier{{OlderThan: time.Minute, Resolution: time.Minute}}}, time.Hour)
+
+	// in order, since each run starts where the one before stopped
+	tests := []struct {
+		name string
+		// when the run happens, as an offset from start
+		at time.Duration
+		// the window compacted, or nothing when there was nothing new
+		from          time.Time
+		to            time.Time
+		wantCompacted int64
+	}{
+		// everything up to partway through a minute, which is left for the next run so it isn't sampled twice
+		{name: "first run", at: 6*time.Minute + 30*time.Second, to: start.Add(5 * time.Minute), wantCompacted: 5 * 59},
+		{name: "only what's new", at: 9*time.Minute + 30*time.Second, from: start.Add(5 * time.Minute), to: start.Add(8 * time.Minute), wantCompacted: 3 * 59},
+		{name: "nothing new", at: 9*time.Minute + 40*time.Second},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stats := &RunStats{Started: start.Add(test.at)}
+			if err := r.apply(ctx, stats); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if test.to.IsZero() {
+				if len(stats.Tiers) != 0 {
+					t.Errorf("got %+v, want nothing compacted", stats.Tiers)
+				}
+				return
+			}
+			if len(stats.Tiers) != 1 {
+				t.Fatalf("got %d tiers, want 1", len(stats.Tiers))
+			}
+			tier := stats.Tiers[0]
+			if !tier.From.Equal(test.from) || !tier.To.Equal(test.to) || tier.Compacted != test.wantCompacted {
+				t.Errorf("compacted %d from %v to %v", tier.Compacted, tier.From, tier.To)
+			}
+			progress := r.Stats().Progress
+			if len(progress) != 1 || progress[0].CompactedTo == nil || !progress[0].CompactedTo.Equal(tier.To) {
+				t.Errorf("got progress %+v", progress)
+			}
+		})
+	}
+
+	// one point a minute for the eight compacted minutes, and every point since
+	history := filters.HistoryOptions{StartTime: start, EndTime: start.Add(time.Hour)}
+	kept, _, err := repo.ListGeolocationHistory(ctx, database.DefaultOrgID, deviceID, history, filters.PageOptions{PageSize: 1000})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(kept) != 8+120 {
+		t.Errorf("kept %d points, want %d", len(kept), 8+120)
+	}
+}
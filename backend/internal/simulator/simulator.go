@@ -19,6 +19,22 @@ type SimulatedDevice struct {
 	// if this is >0, we won't switch directions until N steps have passed
 	switchDirectionCooldownSteps int
 	lastUpdate                   time.Time
+	// meters above ground level, drifting around cruiseAltitude
+	altitude float64
+	battery  float64
+}
+
+const (
+	// roughly the ground elevation around the default center, so altitude_msl looks plausible
+	groundElevation = 668.0
+	cruiseAltitude  = 120.0
+	metersPerDegree = 111320.0
+	// percent per second, so a battery lasts about half an hour
+	batteryDrain = 100.0 / 1800
+)
+
+func ptr(value float64) *float64 {
+	return &value
 }
 
 type SimulatorImpl struct {
@@ -36,6 +52,13 @@ type SimulatorImpl struct {
 
 	maxInsertRetries int
 	insertRetryTime  time.Duration
+
+	// per-batch insert timing, summarized every reportEverySteps steps
+	reportEverySteps int
+	lastIngest       *database.IngestStats
+	ingestSteps      int
+	ingestTotal      time.Duration
+	ingestMax        time.Duration
 }
 
 func New(repo database.Repo, noDevices int, centerLatitude float64, centerLongitude float64, radius float64, frequency float64, movementPerSec float64) *SimulatorImpl {
@@ -50,6 +73,7 @@ func New(repo database.Repo, noDevices int, centerLatitude float64, centerLongit
 		movementPerSec:   movementPerSec,
 		maxInsertRetries: 5,
 		insertRetryTime:  time.Duration(2 * time.Millisecond),
+		reportEverySteps: int(10 * frequency),
 	}
 }
 
@@ -74,6 +98,9 @@ func (s *SimulatorImpl) Run(ctx context.Context) error {
 			delta := after.Sub(before)
 			if delta > s.sleepTime {
 				fmt.Printf("stepDevices exhausted allocated step time: %v > %v\n", delta, s.sleepTime)
+				if s.lastIngest != nil {
+					fmt.Printf("last batch copied %d geolocations in %v\n", s.lastIngest.Rows, s.lastIngest.Duration)
+				}
 			}
 			time.Sleep(s.sleepTime - delta)
 		}
@@ -81,11 +108,11 @@ func (s *SimulatorImpl) Run(ctx context.Context) error {
 }
 
 func (s *SimulatorImpl) setupDevices(ctx context.Context) error {
-	// fetch devices
-	devices, err := s.repo.ListDevices(ctx, filters.PageOptions{
+	// fetch devices. simulated devices belong to the default org
+	devices, _, err := s.repo.ListDevices(ctx, database.DefaultOrgID, filters.PageOptions{
 		Page:     1,
 		PageSize: s.noDevices,
-	})
+	}, filters.DeviceFilter{})
 	if err != nil {
 		return err
 	}
@@ -96,7 +123,7 @@ func (s *SimulatorImpl) setupDevices(ctx context.Context) error {
 			device := &database.Device{
 				Name: fmt.Sprintf("ReallyBigTruck-%d", i),
 			}
-			id, err := s.repo.InsertDevice(ctx, device)
+			id, err := s.repo.InsertDevice(ctx, database.DefaultOrgID, device)
 			if err != nil {
 				return err
 			}
@@ -122,6 +149,8 @@ func (s *SimulatorImpl) setupDevices(ctx context.Context) error {
 			stepDisplacementY: s.movementPerSec * math.Sin(directionRadians),
 			stepDisplacementX: s.movementPerSec * math.Cos(directionRadians),
 			lastUpdate:        time.Now(),
+			altitude:          cruiseAltitude,
+			battery:           50 + 50*rand.Float64(),
 		})
 	}
 	return nil
@@ -151,25 +180,77 @@ func (s *SimulatorImpl) stepDevices(ctx context.Context) error {
 			device.switchDirectionCooldownSteps = 5
 		}
 		device.switchDirectionCooldownSteps--
+		s.stepTelemetry(device, deltaSeconds)
 
 		geolocationsToInsert = append(geolocationsToInsert, device.geolocation)
 		device.lastUpdate = time.Now()
 	}
 
+	// COPY is much cheaper than a batch of INSERTs, which is what lets this scale past a handful of drones
 	retries := 0
 	var err error
+	var stats *database.IngestStats
 	for retries < s.maxInsertRetries {
-		err := s.repo.InsertMultiGeolocation(ctx, geolocationsToInsert)
+		stats, err = s.repo.CopyMultiGeolocation(ctx, database.DefaultOrgID, geolocationsToInsert)
 		if err == nil {
 			break
 		}
 		retries++
 		time.Sleep(s.insertRetryTime)
 	}
-	if retries > s.maxInsertRetries {
+	if err != nil {
 		fmt.Printf("Failed to insert geolocations after %d retries: %v\n", retries, err)
 		return err
 	}
+	s.recordIngestStats(stats)
 
 	return nil
 }
+
+// stepTelemetry reports what a drone flying this path would.
+// the geolocation is reused between steps, so every value is a new pointer
+func (s *SimulatorImpl) stepTelemetry(device *SimulatedDevice, deltaSeconds float64) {
+	northSpeed := device.stepDisplacementY * metersPerDegree
+	eastSpeed := device.stepDisplacementX * metersPerDegree * math.Cos(device.geolocation.Latitude*math.Pi/180)
+	heading := math.Mod(math.Atan2(eastSpeed, northSpeed)*180/math.Pi+360, 360)
+
+	previousAltitude := device.altitude
+	device.altitude += (cruiseAltitude-device.altitude)*0.1 + (rand.Float64()-0.5)*2
+	verticalSpeed := 0.0
+	if deltaSeconds > 0 {
+		verticalSpeed = (device.altitude - previousAltitude) / deltaSeconds
+	}
+
+	// swap the battery when it runs out
+	device.battery -= batteryDrain * deltaSeconds
+	if device.battery < 5 {
+		device.battery = 100
+	}
+
+	device.geolocation.Telemetry = database.Telemetry{
+		AltitudeMSL:        ptr(groundElevation + device.altitude),
+		AltitudeAGL:        ptr(device.altitude),
+		Heading:            ptr(heading),
+		GroundSpeed:        ptr(math.Hypot(northSpeed, eastSpeed)),
+		VerticalSpeed:      ptr(verticalSpeed),
+		HorizontalAccuracy: ptr(1.5 + 3.5*rand.Float64()),
+		BatteryPercent:     ptr(device.battery),
+	}
+}
+
+func (s *SimulatorImpl) recordIngestStats(stats *database.IngestStats) {
+	s.lastIngest = stats
+	s.ingestSteps++
+	s.ingestTotal += stats.Duration
+	if stats.Duration > s.ingestMax {
+		s.ingestMax = stats.Duration
+	}
+	if s.ingestSteps < s.reportEverySteps {
+		return
+	}
+
+	fmt.Printf("copied %d geolocations per step, avg %v, max %v over the last %d steps\n", stats.Rows, s.ingestTotal/time.Duration(s.ingestSteps), s.ingestMax, s.ingestSteps)
+	s.ingestSteps = 0
+	s.ingestTotal = 0
+	s.ingestMax = 0
+}
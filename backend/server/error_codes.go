@@ -3,4 +3,8 @@ package main
 const (
 	successCode              = 0
 	postgresConnectionFailed = 1
+	invalidConfiguration     = 2
+	migrationFailed          = 3
+	importFailed             = 4
+	serverFailed             = 5
 )
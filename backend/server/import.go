@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/database"
+	"github.com/NinjaPerson24119/MapProject/backend/internal/importer"
+)
+
+// runImportCommand handles `map-project-server import [flags] file...` and returns the exit code
+func runImportCommand(ctx context.Context, connectionURL string, args []string) int {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	orgID := flags.String("org", database.DefaultOrgID, "org to import into")
+	formatFlag := flags.String("format", "", "csv or gpx, instead of going by each file's extension")
+	mappingFlag := flags.String("mapping", "", `csv column mapping as JSON, e.g. {"device_name": "drone", "time_format": "unix_ms"}`)
+	deviceName := flags.String("device-name", "", "device for rows and tracks that don't name one")
+	policyFlag := flags.String("duplicate-policy", string(database.RejectDuplicates), "reject, keep_first or last_write_wins")
+	if err := flags.Parse(args); err != nil {
+		return invalidConfiguration
+	}
+	if flags.NArg() == 0 {
+		fmt.Println("usage: map-project-server import [flags] file...")
+		flags.PrintDefaults()
+		return invalidConfiguration
+	}
+
+	mapping := importer.ColumnMapping{}
+	if *mappingFlag != "" {
+		if err := json.Unmarshal([]byte(*mappingFlag), &mapping); err != nil {
+			fmt.Printf("invalid mapping: %v\n", err)
+			return invalidConfiguration
+		}
+	}
+	policy, err := database.ParseDuplicatePolicy(*policyFlag)
+	if err != nil {
+		fmt.Println(err)
+		return invalidConfiguration
+	}
+
+	repo, err := database.Open(ctx, connectionURL)
+	if err != nil {
+		fmt.Println(err)
+		return postgresConnectionFailed
+	}
+	defer repo.Close()
+
+	// every file is attempted, and the exit code says whether any failed
+	code := successCode
+	for _, path := range flags.Args() {
+		if err := importFile(ctx, repo, *orgID, path, *formatFlag, mapping, *deviceName, policy); err != nil {
+			fmt.Printf("%v: %v\n", path, err)
+			code = importFailed
+		}
+	}
+	return code
+}
+
+func importFile(ctx context.Context, repo database.Repo, orgID string, path string, formatName string, mapping importer.ColumnMapping, deviceName string, policy database.DuplicatePolicy) error {
+	format, err := importer.FormatFromFilename(path)
+	if formatName != "" {
+		format, err = importer.ParseFormat(formatName)
+	}
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	source, err := importer.NewSource(format, file, mapping, deviceName)
+	if err != nil {
+		return err
+	}
+
+	report, err := importer.Import(ctx, repo, orgID, source, policy)
+	if report != nil {
+		fmt.Printf("%v: %d rows, %d imported, %d duplicates, %d rejected, %d devices created\n",
+			path, report.Rows, report.Imported, report.Duplicates, report.RejectedRows, len(report.CreatedDevices))
+		for _, device := range report.CreatedDevices {
+			fmt.Printf("  created device %v %v\n", device.DeviceID, device.Name)
+		}
+		for _, rejected := range report.Rejected {
+			fmt.Printf("  line %d: %v\n", rejected.Line, rejected.Error)
+		}
+		if report.RejectedRows > len(report.Rejected) {
+			fmt.Printf("  and %d more rejected rows\n", report.RejectedRows-len(report.Rejected))
+		}
+	}
+	return err
+}
@@ -3,18 +3,33 @@ package main
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/NinjaPerson24119/MapProject/backend/internal/api"
 	"github.com/NinjaPerson24119/MapProject/backend/internal/constants"
 	"github.com/NinjaPerson24119/MapProject/backend/internal/database"
+	"github.com/NinjaPerson24119/MapProject/backend/internal/metrics"
+	"github.com/NinjaPerson24119/MapProject/backend/internal/partitions"
+	"github.com/NinjaPerson24119/MapProject/backend/internal/relay"
+	"github.com/NinjaPerson24119/MapProject/backend/internal/retention"
 	"github.com/NinjaPerson24119/MapProject/backend/internal/simulator"
 	"github.com/gin-gonic/gin"
 )
 
+// how long requests in flight get to finish when the server is stopped
+const shutdownTimeout = 10 * time.Second
+
+// keys chosen by operators have to be about as hard to guess as generated ones
+const minAPIKeyLength = 32
+
 func setupBaseRouter() *gin.Engine {
-	router := gin.Default()
+	router := gin.New()
+	router.Use(api.APIKeyFromQuery, gin.Logger(), gin.Recovery())
 
 	router.GET("/ping", func(c *gin.Context) {
 		c.String(http.StatusOK, "pong")
@@ -27,12 +42,45 @@ func main() {
 	ctx := context.Background()
 
 	connectionURL := os.Getenv("POSTGRES_CONNECTION_URL")
-	repo, err := database.New(ctx, connectionURL)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrateCommand(ctx, connectionURL, os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		os.Exit(runImportCommand(ctx, connectionURL, os.Args[2:]))
+	}
+	if database.IsPostgres(connectionURL) {
+		err := migrateOnStartup(ctx, connectionURL)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(migrationFailed)
+		}
+	}
+
+	repo, err := database.Open(ctx, connectionURL)
 	if err != nil {
+		fmt.Println(err)
 		os.Exit(postgresConnectionFailed)
 	}
-	defer repo.Close()
-	fmt.Println("Connected to postgres")
+	// wraps the store itself, so in relay mode it times the background writes rather than the relay
+	instrumentedRepo := metrics.New(repo)
+	repo = instrumentedRepo
+
+	// by default the database is the source of truth and websockets hear about rows once they are committed.
+	// in relay mode, geolocations are streamed first and written behind in batches
+	var relayRepo *relay.RelayRepo
+	if os.Getenv("INGEST_MODE") == "relay" {
+		config := relay.DefaultConfig()
+		if policy := os.Getenv("RELAY_OVERFLOW_POLICY"); policy != "" {
+			config.Overflow, err = relay.ParseOverflowPolicy(policy)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(invalidConfiguration)
+			}
+		}
+		relayRepo = relay.New(repo, config)
+		repo = relayRepo
+		fmt.Printf("Relaying geolocations and persisting them in the background, overflow policy %v\n", config.Overflow)
+	}
 
 	// Edmonton legislature
 	latitude := 53.5357
@@ -42,9 +90,111 @@ func main() {
 	defer cancel()
 	go simulator.Run(ctxWithCancel)
 
+	// daily partitions are created a week ahead. expired ones are dropped by retention
+	partitionJob := partitions.New(repo, 7, time.Hour)
+	go partitionJob.Run(ctxWithCancel)
+
+	// thin out old history so the geolocation table doesn't grow forever at 10Hz per drone.
+	// it removes data, so it only runs when it's configured
+	retentionPolicy := retention.Policy{}
+	if tiers := os.Getenv("RETENTION_TIERS"); tiers != "" {
+		retentionPolicy.Tiers, err = retention.ParseTiers(tiers)
+		if err != nil {
+			fmt.Printf("invalid RETENTION_TIERS: %v\n", err)
+			os.Exit(invalidConfiguration)
+		}
+	}
+	if maxAge := os.Getenv("RETENTION_MAX_AGE"); maxAge != "" {
+		retentionPolicy.MaxAge, err = time.ParseDuration(maxAge)
+		if err != nil {
+			fmt.Printf("invalid RETENTION_MAX_AGE: %v\n", err)
+			os.Exit(invalidConfiguration)
+		}
+	}
+	retentionJob := retention.New(repo, retentionPolicy, time.Hour)
+	if retentionPolicy.Enabled() {
+		go retentionJob.Run(ctxWithCancel)
+	}
+
+	duplicatePolicy := database.RejectDuplicates
+	if policy := os.Getenv("DUPLICATE_POLICY"); policy != "" {
+		duplicatePolicy, err = database.ParseDuplicatePolicy(policy)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(invalidConfiguration)
+		}
+	}
+
+	// every request needs an org's key, and without either of these there's no way to get one
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	defaultOrgKey := os.Getenv("DEFAULT_ORG_API_KEY")
+	if adminToken == "" && defaultOrgKey == "" {
+		fmt.Println("set DEFAULT_ORG_API_KEY to use the default org, or ADMIN_TOKEN to issue org keys with the admin api")
+		os.Exit(invalidConfiguration)
+	}
+
+	// single team deployments can skip the admin api, and give the default org a key of their choosing
+	if defaultOrgKey != "" {
+		if len(defaultOrgKey) < minAPIKeyLength {
+			fmt.Printf("DEFAULT_ORG_API_KEY must be at least %v characters\n", minAPIKeyLength)
+			os.Exit(invalidConfiguration)
+		}
+		err = repo.SetOrganizationAPIKey(ctx, database.DefaultOrgID, database.HashAPIKey(defaultOrgKey))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(invalidConfiguration)
+		}
+	}
+
 	router := setupBaseRouter()
-	api.RouterWithGeolocationAPI(router, repo)
-	router.Run(":8080")
+	api.RouterWithGeolocationAPI(router, repo, duplicatePolicy)
+	stats := api.StatsGroup(router, adminToken)
+	api.RouterWithListenerStatsAPI(stats, repo)
+	api.RouterWithRetentionAPI(stats, retentionJob, partitionJob)
+	api.RouterWithMetricsAPI(stats, instrumentedRepo)
+	if relayRepo != nil {
+		api.RouterWithRelayAPI(stats, relayRepo)
+	}
+	// creating orgs and moving devices between them is left out unless there's a token to guard it
+	if adminToken != "" {
+		api.RouterWithAdminAPI(router, repo, adminToken)
+	} else {
+		fmt.Println("ADMIN_TOKEN is not set, so the admin api is disabled and stats are open to anyone")
+	}
+
+	// requests are cancelled along with the background jobs, so websockets, which shutdown doesn't wait for, stop too
+	server := &http.Server{
+		Addr:    ":8080",
+		Handler: router,
+		BaseContext: func(net.Listener) context.Context {
+			return ctxWithCancel
+		},
+	}
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	// deploys stop us with SIGTERM. in relay mode, geolocations that were streamed but not written yet are lost unless the repo is closed
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	exitCode := successCode
+	select {
+	case err := <-serverErr:
+		fmt.Printf("server failed: %v\n", err)
+		exitCode = serverFailed
+	case sig := <-signals:
+		fmt.Printf("received %v, shutting down\n", sig)
+		shutdownCtx, cancelShutdown := context.WithTimeout(ctx, shutdownTimeout)
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			fmt.Printf("failed to finish requests before shutting down: %v\n", err)
+		}
+		cancelShutdown()
+	}
 
-	os.Exit(successCode)
+	// stop everything using the repo before closing it, which writes out the relay's queue
+	cancel()
+	repo.Close()
+	fmt.Println("shut down")
+	os.Exit(exitCode)
 }
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/NinjaPerson24119/MapProject/backend/internal/migrations"
+)
+
+// runMigrateCommand handles `map-project-server migrate up|status` and returns the exit code
+func runMigrateCommand(ctx context.Context, connectionURL string, args []string) int {
+	if len(args) != 1 {
+		fmt.Println("usage: map-project-server migrate up|status")
+		return invalidConfiguration
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrations.Up(ctx, connectionURL)
+		if err != nil {
+			fmt.Println(err)
+			return migrationFailed
+		}
+		fmt.Printf("applied %d migrations\n", len(applied))
+	case "status":
+		statuses, err := migrations.GetStatus(ctx, connectionURL)
+		if err != nil {
+			fmt.Println(err)
+			return migrationFailed
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.Applied != nil {
+				applied = status.Applied.Format("2006-01-02 15:04:05 MST")
+			}
+			fmt.Printf("%-40s %v\n", status.Name, applied)
+		}
+	default:
+		fmt.Printf("unknown migrate command: %v\n", args[0])
+		return invalidConfiguration
+	}
+	return successCode
+}
+
+// migrateOnStartup reads MIGRATE_ON_STARTUP: "apply" runs pending migrations, "check" refuses to start while any are pending
+func migrateOnStartup(ctx context.Context, connectionURL string) error {
+	switch mode := os.Getenv("MIGRATE_ON_STARTUP"); mode {
+	case "":
+		return nil
+	case "apply":
+		applied, err := migrations.Up(ctx, connectionURL)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("applied %d migrations\n", len(applied))
+		return nil
+	case "check":
+		pending, err := migrations.Pending(ctx, connectionURL)
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			return fmt.Errorf("%d migrations are pending, starting with %v", len(pending), pending[0].Name)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown MIGRATE_ON_STARTUP: %v", mode)
+	}
+}
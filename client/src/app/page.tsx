@@ -6,9 +6,22 @@ import { Feature, Geometry, GeoJsonProperties } from 'geojson';
 import mapboxgl, { GeoJSONSource } from 'mapbox-gl';
 
 const geolocationStreamAPI = process.env.NEXT_PUBLIC_WEBSOCKET || '';
+// the org whose devices are shown. browsers can't set headers on websockets, so it goes in the url
+const apiKey = process.env.NEXT_PUBLIC_API_KEY || '';
+
+function geolocationStreamURL(): string {
+  if (!apiKey) {
+    return geolocationStreamAPI;
+  }
+  const url = new URL(geolocationStreamAPI);
+  url.searchParams.set('api_key', apiKey);
+  return url.toString();
+}
 
 interface GeolocationMessage {
   geolocations: Geolocation[];
+  removed_device_ids?: string[];
+  resync?: boolean;
 }
 
 interface Geolocation {
@@ -16,6 +29,14 @@ interface Geolocation {
   event_time: Date;
   latitude: number;
   longitude: number;
+  // optional drone telemetry, missing for devices that only report a position
+  altitude_msl?: number;
+  altitude_agl?: number;
+  heading?: number;
+  ground_speed?: number;
+  vertical_speed?: number;
+  horizontal_accuracy?: number;
+  battery_percent?: number;
 }
 
 function GeolocationsToFeatureCollection(geolocations: Geolocation[]): Feature<Geometry, GeoJsonProperties>[] {
@@ -87,7 +108,7 @@ export default function Home() {
       return;
     }
     console.log('Connecting to WebSocket...')
-    const ws = new WebSocket(geolocationStreamAPI);
+    const ws = new WebSocket(geolocationStreamURL());
     const sendPing = () => {
       setLastPing(new Date());
       ws.send('ping');
@@ -117,6 +138,10 @@ export default function Home() {
           return value;
         });
         console.log('Received geolocations', json.geolocations.length);
+        if (json.resync) {
+          // the server may have missed updates, so start over from its snapshot
+          geolocations.clear();
+        }
         for (const geolocation of json.geolocations) {
           const lastGeolocation = geolocations.get(geolocation.device_id);
           if (!lastGeolocation) {
@@ -131,6 +156,10 @@ export default function Home() {
           // update geolocation
           geolocations.set(geolocation.device_id, geolocation);
         }
+        for (const deviceID of json.removed_device_ids ?? []) {
+          // deleted device
+          geolocations.delete(deviceID);
+        }
         setGeolocations(new Map(geolocations));
       } catch (error) {
         console.error('Error while reading WebSocket message:', error);